@@ -18,15 +18,21 @@ package main
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/joeholley/tomolink/internal/app/tomolink"
 	"github.com/joeholley/tomolink/internal/config"
 	"github.com/joeholley/tomolink/internal/logging"
+	"github.com/joeholley/tomolink/internal/metrics"
+	"github.com/joeholley/tomolink/internal/storage"
+	"github.com/joeholley/tomolink/internal/tracing"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 )
 
 var (
@@ -63,6 +69,31 @@ func main() {
 			"error": err.Error(),
 		}).Fatalf("Cannot connect to database")
 	}
+	// The client connects lazily, so check the database is reachable now.  Not
+	// being able to reach it isn't fatal, as it may be temporary; the
+	// readiness check fails until it can be reached.
+	pingCtx, cancelPing := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := ac.DB.(storage.Engine).Ping(pingCtx); err != nil {
+		tlLog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Cannot reach database; readiness check will fail until it can be reached")
+	}
+	cancelPing()
+
+	// Put the relationship types defined at runtime in effect, and keep
+	// reading them, so changes made through any instance reach this one.
+	db := ac.DB.(storage.Engine)
+	if err := ac.RefreshDefinitions(context.Background(), db); err != nil {
+		tlLog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Cannot read relationship types defined at runtime; only the config file's are in effect")
+	}
+	refresh, err := ac.Cfg.IntOr("relationships.refresh", 10)
+	if err != nil || refresh <= 0 {
+		tlLog.Warn("Invalid 'relationships.refresh'; defaulting to reading relationship types every 10 seconds")
+		refresh = 10
+	}
+	go ac.WatchDefinitions(context.Background(), db, time.Duration(refresh)*time.Second)
 
 	// set up logrus structured logging
 	format, err := ac.Cfg.StringOr("logging.format", "text")
@@ -85,6 +116,32 @@ func main() {
 	}
 	logging.ConfigureLogging(format, lvl, verbose)
 
+	// Set up OpenTelemetry tracing, exporting spans to an OTLP collector
+	shutdownTracing := func(context.Context) error { return nil }
+	if trace, _ := ac.Cfg.BoolOr("tracing.enabled", false); trace == true {
+		endpoint, _ := ac.Cfg.StringOr("tracing.otlp.endpoint", "localhost:4317")
+		insecure, _ := ac.Cfg.BoolOr("tracing.otlp.insecure", false)
+		service, _ := ac.Cfg.StringOr("tracing.serviceName", "tomolink")
+		ratio, err := ac.Cfg.FloatOr("tracing.sampleRatio", 1.0)
+		if err != nil {
+			tlLog.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Warn("Unable to read trace sample ratio from config; defaulting to sampling all traces")
+			ratio = 1.0
+		}
+		exporter, err := tracing.NewOTLPExporter(context.Background(), endpoint, insecure)
+		if err != nil {
+			tlLog.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalf("Cannot set up trace exporter")
+		}
+		shutdownTracing = tracing.Setup(exporter, service, ratio).Shutdown
+		tlLog.WithFields(logrus.Fields{
+			"tracing.otlp.endpoint": endpoint,
+			"tracing.sampleRatio":   ratio,
+		}).Info("Exporting traces")
+	}
+
 	// Instantiate router
 	router := tomolink.Router(&ac)
 
@@ -97,6 +154,68 @@ func main() {
 	}
 	tlLog = tlLog.WithFields(logrus.Fields{"port": port})
 
+	// The health checks, OpenAPI document, relationship type definitions and
+	// Prometheus metrics go on a ServeMux in front of the router, so none of
+	// the router middleware (auth, rate limiting, etc) applies to them.
+	topMux := http.NewServeMux()
+	health := tomolink.HealthHandler(&ac)
+	topMux.Handle("/healthz", health)
+	topMux.Handle("/readyz", health)
+	topMux.Handle("/status", health)
+	topMux.Handle("/openapi.json", tomolink.OpenAPIHandler(&ac, router))
+	definitions := tomolink.DefinitionsHandler(&ac)
+	topMux.Handle("/relationshipTypes", definitions)
+	topMux.Handle("/relationshipTypes/", definitions)
+	topMux.Handle("/", router)
+
+	// Serve Prometheus metrics, either on their own port or alongside the API.
+	var metricsSrv *http.Server
+	if enabled, _ := ac.Cfg.BoolOr("http.metrics.enabled", true); enabled == true {
+		path, _ := ac.Cfg.StringOr("http.metrics.path", "/metrics")
+		metricsPort, _ := ac.Cfg.StringOr("http.metrics.port", "")
+		if metricsPort == "" || metricsPort == port {
+			topMux.Handle(path, metrics.Handler())
+			metricsPort = port
+		} else {
+			metricsMux := http.NewServeMux()
+			metricsMux.Handle(path, metrics.Handler())
+			metricsSrv = &http.Server{
+				Addr:         "0.0.0.0:" + metricsPort,
+				WriteTimeout: time.Second * 15,
+				ReadTimeout:  time.Second * 15,
+				IdleTimeout:  time.Second * 60,
+				Handler:      metricsMux,
+			}
+		}
+		tlLog.WithFields(logrus.Fields{
+			"metrics.port": metricsPort,
+			"metrics.path": path,
+		}).Info("Serving Prometheus metrics")
+	}
+
+	// Serve the gRPC API, either on its own port or alongside the HTTP API.
+	handler := http.Handler(topMux)
+	var grpcSrv *grpc.Server
+	var grpcLis net.Listener
+	if enabled, _ := ac.Cfg.BoolOr("grpc.enabled", true); enabled == true {
+		grpcSrv = tomolink.NewGRPCServer(&ac)
+		grpcPort, _ := ac.Cfg.StringOr("grpc.port", "")
+		if grpcPort == "" || grpcPort == port {
+			handler = tomolink.GRPCHandler(grpcSrv, topMux)
+			grpcPort = port
+		} else {
+			grpcLis, err = net.Listen("tcp", "0.0.0.0:"+grpcPort)
+			if err != nil {
+				tlLog.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Fatalf("Cannot listen for gRPC requests")
+			}
+		}
+		tlLog.WithFields(logrus.Fields{
+			"grpc.port": grpcPort,
+		}).Info("Serving gRPC API")
+	}
+
 	// Start server.  Largely this is using the example code from https://github.com/gorilla/mux
 	tlLog.Info("Starting HTTP server")
 	//tlLog.Fatal(http.ListenAndServe(":"+port, router))
@@ -106,7 +225,7 @@ func main() {
 		WriteTimeout: time.Second * 15,
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
-		Handler:      router, // Pass our instance of gorilla/mux in.
+		Handler:      handler, // Pass our instance of gorilla/mux in.
 	}
 
 	// Run our server in a goroutine so that it doesn't block.
@@ -115,11 +234,26 @@ func main() {
 			tlLog.Println(err)
 		}
 	}()
+	if grpcLis != nil {
+		go func() {
+			if err := grpcSrv.Serve(grpcLis); err != nil {
+				tlLog.Println(err)
+			}
+		}()
+	}
+	if metricsSrv != nil {
+		go func() {
+			if err := metricsSrv.ListenAndServe(); err != nil {
+				tlLog.Println(err)
+			}
+		}()
+	}
 
 	c := make(chan os.Signal, 1)
-	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
-	// SIGKILL, SIGQUIT or SIGTERM (Ctrl+/) will not be caught.
-	signal.Notify(c, os.Interrupt)
+	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C) or
+	// SIGTERM (sent by Kubernetes and Cloud Run).
+	// SIGKILL or SIGQUIT (Ctrl+/) will not be caught.
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	// Block until we receive our signal.
 	<-c
@@ -130,13 +264,48 @@ func main() {
 		tlLog.WithFields(logrus.Fields{
 			"error": err.Error(),
 		}).Warn("Unable to read http graceful wait duration from config; defaulting to 15 seconds")
+		deadline = 15
 	}
 	wait := time.Duration(deadline) * time.Second
 	ctx, cancel := context.WithTimeout(context.Background(), wait)
 	defer cancel()
+
+	// Fail the readiness check and keep serving for the first half of the
+	// graceful wait, so load balancers have time to stop sending us new
+	// requests.  The second half is left for in-flight requests to finish.
+	tomolink.Drain()
+	tlLog.WithFields(logrus.Fields{
+		"http.gracefulwait": deadline,
+	}).Info("Draining; readiness check now failing")
+	time.Sleep(wait / 2)
+
 	// Doesn't block if no connections, but will otherwise wait
 	// until the timeout deadline.
 	srv.Shutdown(ctx)
+	if metricsSrv != nil {
+		metricsSrv.Shutdown(ctx)
+	}
+	if grpcLis != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcSrv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcSrv.Stop()
+		}
+	}
+	// Stop the deletions and merges running here, and let them record
+	// their status so they can be resumed
+	if err := tomolink.StopJobs(ctx); err != nil {
+		tlLog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Background jobs didn't record their status before the deadline")
+	}
+	// Send any spans that haven't been exported yet
+	shutdownTracing(ctx)
 	// Optionally, you could run srv.Shutdown in a goroutine and block on
 	// <-ctx.Done() if your application should wait for other services
 	// to finalize based on context cancellation.
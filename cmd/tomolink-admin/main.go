@@ -0,0 +1,373 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package main is a command for inspecting and repairing Tomolink's
+// relationship data directly in the database.  It reads the same
+// tomolink_defaults.yaml and environment variables as the server, from the
+// directory it is run in, and writes through the same caches, so Tomolink
+// instances see its changes.  -engine overrides 'database.engine', for
+// importing into a different engine than the one exported from.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/joeholley/tomolink/internal/admin"
+	"github.com/joeholley/tomolink/internal/config"
+	"github.com/joeholley/tomolink/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// Fields to add to the structured logs
+	aLog = logrus.WithFields(logrus.Fields{
+		"app":       "tomolink",
+		"component": "app.admin",
+	})
+)
+
+// progressEvery is how many users are exported between progress logs.
+const progressEvery = 1000
+
+const usage = `Usage: %s [-engine <engine>] <command> [arguments]
+
+Commands:
+  get <user>                                   print the user's relationships as JSON
+  set [-mutual] <user> <relationship> <target> <score>
+                                               set the score of a relationship
+  delete [-mutual] <user> <relationship> <target>
+                                               delete a relationship
+  asymmetric [-repair add|remove] <relationship>
+                                               list relationships whose reciprocal is missing or
+                                               has a different score, optionally repairing them
+  rename <from> <to>                           move every user's relationships to another type
+  rewrite-aliases                              move every user's relationships under the old names of
+                                               renamed types to the current names
+  delete-user <user>                           delete the user and every relationship with them
+  merge <from> <to>                            move every relationship of and with a user to another user,
+                                               and delete the first user
+  export [-format ndjson|csv] [-o file] [-after user]
+                                               write every relationship to the file (default stdout)
+  import [-format ndjson|csv] [-batch n] [-checkpoint file] <file>
+                                               set every relationship in the file ('-' for stdin)
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
+	}
+	engine := flag.String("engine", "", "database engine to use instead of 'database.engine'")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+
+	ac := config.AppConfig{}
+	if err := ac.Load("tomolink"); err != nil {
+		aLog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatal("Cannot load configuration")
+	}
+	dbEngine, err := ac.Cfg.StringOr("database.engine", "firestore")
+	if err != nil {
+		aLog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatal("Problem retreiving database configuration")
+	}
+	if *engine != "" {
+		dbEngine = *engine
+	}
+	if err := ac.Connect(dbEngine); err != nil {
+		aLog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatal("Cannot connect to database")
+	}
+	db := ac.DB.(storage.Engine)
+	defer db.Close()
+
+	ctx := context.Background()
+	switch cmd {
+	case "get":
+		err = get(ctx, db, args)
+	case "set":
+		err = write(ctx, db, cmd, args, 4)
+	case "delete":
+		err = write(ctx, db, cmd, args, 3)
+	case "asymmetric":
+		err = asymmetric(ctx, db, args)
+	case "rename":
+		err = rename(ctx, db, args)
+	case "rewrite-aliases":
+		err = rewriteAliases(ctx, db, ac.RelationshipAliases, args)
+	case "delete-user":
+		err = deleteUser(ctx, db, args)
+	case "merge":
+		// Use the merge policies of the relationship types defined at
+		// runtime too
+		if err = ac.RefreshDefinitions(ctx, db); err == nil {
+			err = merge(ctx, db, ac.Definitions().MergePolicies, args)
+		}
+	case "export":
+		err = export(ctx, db, args)
+	case "import":
+		err = importEdges(ctx, db, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		aLog.WithFields(logrus.Fields{
+			"command": cmd,
+			"error":   err.Error(),
+		}).Fatal("Failed")
+	}
+}
+
+// get prints a user's relationships and version.
+func get(ctx context.Context, db storage.Engine, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("get takes a user")
+	}
+	relationships, version, err := db.GetUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	return out.Encode(struct {
+		User          string                    `json:"user"`
+		Version       string                    `json:"version"`
+		Relationships map[string]storage.Scores `json:"relationships"`
+	}{args[0], version, relationships})
+}
+
+// write sets or deletes a single relationship, and with -mutual its
+// reciprocal too.  Caps aren't checked.
+func write(ctx context.Context, db storage.Engine, cmd string, args []string, nargs int) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	mutual := fs.Bool("mutual", false, "also write the reciprocal relationship")
+	fs.Parse(args)
+	if fs.NArg() != nargs {
+		return fmt.Errorf("%s takes %d arguments", cmd, nargs)
+	}
+	w := storage.Write{User: fs.Arg(0), Relationship: fs.Arg(1), Target: fs.Arg(2), Op: storage.Delete}
+	if cmd == "set" {
+		score, err := strconv.ParseInt(fs.Arg(3), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid score: %v", err)
+		}
+		w.Op, w.Value = storage.Set, score
+	}
+	writes := []storage.Write{w}
+	if *mutual {
+		w.User, w.Target = w.Target, w.User
+		writes = append(writes, w)
+	}
+	_, err := db.Write(ctx, writes...)
+	return err
+}
+
+// asymmetric prints the asymmetric pairs of a relationship type, one per line
+// as source, target, score, and the reciprocal's score or '-' if it is
+// missing, optionally repairing each.
+func asymmetric(ctx context.Context, db storage.Engine, args []string) error {
+	fs := flag.NewFlagSet("asymmetric", flag.ExitOnError)
+	repair := fs.String("repair", "", "'add' creates missing reciprocals, 'remove' deletes relationships without one")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("asymmetric takes a relationship type")
+	}
+	mode := admin.RepairMode(*repair)
+	if mode != "" && mode != admin.AddMissing && mode != admin.RemoveOneSided {
+		return fmt.Errorf("unknown repair mode '%s'", mode)
+	}
+	found := 0
+	err := admin.FindAsymmetric(ctx, db, fs.Arg(0), func(a admin.Asymmetry) error {
+		found++
+		reverse := "-"
+		if a.ReverseExists {
+			reverse = strconv.FormatInt(a.Reverse, 10)
+		}
+		fmt.Printf("%s\t%s\t%d\t%s\n", a.User, a.Target, a.Score, reverse)
+		if mode == "" {
+			return nil
+		}
+		return admin.Repair(ctx, db, a, mode)
+	})
+	aLog.WithFields(logrus.Fields{"asymmetric": found, "repaired": mode != ""}).Info("Done")
+	return err
+}
+
+// rename moves every user's relationships from one type to another.
+func rename(ctx context.Context, db storage.Engine, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("rename takes the current and new relationship types")
+	}
+	users, err := admin.RenameRelationship(ctx, db, args[0], args[1], nil)
+	aLog.WithFields(logrus.Fields{"from": args[0], "to": args[1], "users": users}).Info("Done")
+	return err
+}
+
+// rewriteAliases moves every user's relationships under the old names in the
+// relationship definitions' aliases to the current names, one old name at a
+// time.
+func rewriteAliases(ctx context.Context, db storage.Engine, aliases map[string]string, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("rewrite-aliases takes no arguments")
+	}
+	olds := make([]string, 0, len(aliases))
+	for old := range aliases {
+		olds = append(olds, old)
+	}
+	sort.Strings(olds)
+	for _, old := range olds {
+		users, err := admin.RenameRelationship(ctx, db, old, aliases[old], nil)
+		aLog.WithFields(logrus.Fields{"from": old, "to": aliases[old], "users": users}).Info("Rewrote relationships")
+		if err != nil {
+			return err
+		}
+	}
+	aLog.WithFields(logrus.Fields{"aliases": len(olds)}).Info("Done")
+	return nil
+}
+
+// deleteUser deletes a user and every relationship with them.
+func deleteUser(ctx context.Context, db storage.Engine, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("delete-user takes a user")
+	}
+	removed, err := admin.DeleteUser(ctx, db, args[0])
+	aLog.WithFields(logrus.Fields{"user": args[0], "inbound": removed}).Info("Done")
+	return err
+}
+
+// merge merges a user into another, using the merge policies of the
+// relationship definitions.
+func merge(ctx context.Context, db storage.Engine, policies map[string]storage.MergePolicy, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("merge takes the user to merge and the user to merge into")
+	}
+	stats, err := admin.MergeUser(ctx, db, args[0], args[1], policies)
+	aLog.WithFields(logrus.Fields{
+		"from":       args[0],
+		"to":         args[1],
+		"moved":      stats.Moved,
+		"redirected": stats.Redirected,
+		"dropped":    stats.Dropped,
+	}).Info("Done")
+	return err
+}
+
+// export writes every relationship to a file or stdout.  Progress is logged
+// with the last user exported, so an interrupted export can be resumed with
+// -after.
+func export(ctx context.Context, db storage.Engine, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", admin.NDJSON, "'ndjson' or 'csv'")
+	out := fs.String("o", "", "file to write to instead of stdout")
+	after := fs.String("after", "", "only export users after this one, to resume an export")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		return fmt.Errorf("export takes no arguments")
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		// Resumed exports add to the file
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if *after != "" {
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		f, err := os.OpenFile(*out, flags, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc, err := admin.NewEncoder(*format, w)
+	if err != nil {
+		return err
+	}
+	n := 0
+	users, err := admin.Export(ctx, db, enc, *after, func(user string) {
+		n++
+		if n%progressEvery == 0 {
+			aLog.WithFields(logrus.Fields{"users": n, "last": user}).Info("Exporting")
+		}
+	})
+	aLog.WithFields(logrus.Fields{"users": users}).Info("Done")
+	return err
+}
+
+// importEdges sets every relationship in a file or stdin.  With -checkpoint,
+// how many relationships have been written is saved in the checkpoint file
+// after each batch, and an import with an existing checkpoint file carries on
+// from there.
+func importEdges(ctx context.Context, db storage.Engine, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", admin.NDJSON, "'ndjson' or 'csv'")
+	batch := fs.Int("batch", 0, "relationships to write at a time; 0 writes as many as the database allows")
+	checkpoint := fs.String("checkpoint", "", "file recording the progress of the import, to resume it")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("import takes a file")
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	dec, err := admin.NewDecoder(*format, r)
+	if err != nil {
+		return err
+	}
+
+	opts := admin.ImportOptions{BatchSize: *batch}
+	if *checkpoint != "" {
+		data, err := ioutil.ReadFile(*checkpoint)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return err
+		default:
+			if opts.Skip, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil {
+				return fmt.Errorf("invalid checkpoint file: %v", err)
+			}
+			aLog.WithFields(logrus.Fields{"skip": opts.Skip}).Info("Resuming import")
+		}
+		opts.Checkpoint = func(done int) error {
+			return ioutil.WriteFile(*checkpoint, []byte(strconv.Itoa(done)+"\n"), 0644)
+		}
+	}
+	done, err := admin.Import(ctx, db, dec, opts)
+	aLog.WithFields(logrus.Fields{"relationships": done}).Info("Done")
+	return err
+}
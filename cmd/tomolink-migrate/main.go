@@ -0,0 +1,169 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package main is a command moving Tomolink's Firestore data from the
+// document layout to the subcollection layout while Tomolink keeps serving.
+// Every Tomolink instance must use the 'migrating' database layout first; see
+// the user guide for the whole procedure.
+//
+// Usage:
+//
+//	tomolink-migrate [all|copy|verify|flip|rollback|cleanup]
+//
+// 'all', the default, copies the relationships, verifies them, and switches
+// reads to the subcollection layout if they match.  'rollback' switches reads
+// back to the document layout.  'cleanup' deletes the relationships in the
+// document layout, once every instance uses the 'subcollections' layout.
+//
+// It reads the same tomolink_defaults.yaml and environment variables as the
+// server, from the directory it is run in.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/joeholley/tomolink/internal/config"
+	"github.com/joeholley/tomolink/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// Fields to add to the structured logs
+	mLog = logrus.WithFields(logrus.Fields{
+		"app":       "tomolink",
+		"component": "app.migrate",
+	})
+)
+
+// progressEvery is how many users are processed between progress logs.
+const progressEvery = 1000
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [all|copy|verify|flip|rollback|cleanup]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	step := "all"
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if flag.NArg() == 1 {
+		step = flag.Arg(0)
+	}
+
+	ac := config.AppConfig{}
+	if err := ac.Load("tomolink"); err != nil {
+		mLog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatal("Cannot load configuration")
+	}
+	fs, err := ac.ConnectFirestore(storage.MigratingLayout)
+	if err != nil {
+		mLog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatal("Cannot connect to database")
+	}
+	defer fs.Close()
+
+	ctx := context.Background()
+	switch step {
+	case "all":
+		copyUsers(ctx, fs)
+		if verify(ctx, fs) {
+			setReads(ctx, fs, storage.SubcollectionLayout)
+		} else {
+			mLog.Fatal("Relationships don't match; reads still come from the document layout")
+		}
+	case "copy":
+		copyUsers(ctx, fs)
+	case "verify":
+		if !verify(ctx, fs) {
+			os.Exit(1)
+		}
+	case "flip":
+		setReads(ctx, fs, storage.SubcollectionLayout)
+	case "rollback":
+		setReads(ctx, fs, storage.DocumentLayout)
+	case "cleanup":
+		stats, err := fs.RemoveDocumentLayout(ctx, progress("cleanup"))
+		logStats("cleanup", stats, err)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// copyUsers copies every user's relationships to the subcollection layout.
+func copyUsers(ctx context.Context, fs *storage.Firestore) {
+	stats, err := fs.CopyToSubcollections(ctx, progress("copy"))
+	logStats("copy", stats, err)
+}
+
+// verify compares every user's relationships in the two layouts, returning
+// whether they all match.
+func verify(ctx context.Context, fs *storage.Firestore) bool {
+	stats, err := fs.VerifySubcollections(ctx, progress("verify"))
+	logStats("verify", stats, err)
+	if len(stats.Mismatched) == 0 {
+		return true
+	}
+	sort.Strings(stats.Mismatched)
+	for _, user := range stats.Mismatched {
+		mLog.WithFields(logrus.Fields{"user": user}).Warn("Relationships don't match")
+	}
+	return false
+}
+
+// setReads sets the layout Tomolink instances using the 'migrating' layout
+// read from.
+func setReads(ctx context.Context, fs *storage.Firestore, layout string) {
+	if err := fs.SetReadLayout(ctx, layout); err != nil {
+		mLog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatal("Cannot set the read layout")
+	}
+	mLog.WithFields(logrus.Fields{"layout": layout}).Info("Reads switched; every instance reads from the layout within 10 seconds")
+}
+
+// progress returns a function logging every progressEvery users.
+func progress(step string) func(user string) {
+	n := 0
+	return func(user string) {
+		n++
+		if n%progressEvery == 0 {
+			mLog.WithFields(logrus.Fields{"step": step, "users": n}).Info("In progress")
+		}
+	}
+}
+
+// logStats logs the outcome of a step, exiting if it failed.
+func logStats(step string, stats storage.MigrationStats, err error) {
+	fields := logrus.Fields{
+		"step":          step,
+		"users":         stats.Users,
+		"relationships": stats.Relationships,
+		"mismatched":    len(stats.Mismatched),
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+		mLog.WithFields(fields).Fatal("Failed")
+	}
+	mLog.WithFields(fields).Info("Done")
+}
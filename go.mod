@@ -1,6 +1,6 @@
 module github.com/joeholley/tomolink
 
-go 1.13
+go 1.17
 
 require (
 	cloud.google.com/go/firestore v1.1.0
@@ -9,16 +9,25 @@ require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.0 // indirect
 	github.com/go-ini/ini v1.51.1 // indirect
 	github.com/golang/gddo v0.0.0-20191216155521-fbfc0f5e7810
+	github.com/gomodule/redigo v1.7.0
 	github.com/gorilla/mux v1.7.3
+	github.com/prometheus/client_golang v1.5.1
 	github.com/sirupsen/logrus v1.4.2
 	github.com/smartystreets/goconvey v1.6.4 // indirect
 	github.com/spf13/viper v1.5.0
 	github.com/stretchr/testify v1.4.0
 	github.com/urfave/cli v1.22.2 // indirect
 	github.com/zpatrick/go-config v0.0.0-20191118215128-80ba6b3e54f6
+	golang.org/x/net v0.11.0
 	golang.org/x/sys v0.0.0-20191224085550-c709ea063b76 // indirect
+	go.opentelemetry.io/otel v1.7.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc v1.7.0
+	go.opentelemetry.io/otel/sdk v1.7.0
+	go.opentelemetry.io/otel/trace v1.7.0
 	google.golang.org/api v0.13.0
+	google.golang.org/grpc v1.46.0
+	google.golang.org/protobuf v1.28.1
 	gopkg.in/ini.v1 v1.51.1 // indirect
-	gopkg.in/yaml.v2 v2.2.7 // indirect
+	gopkg.in/yaml.v2 v2.2.7
 	open-match.dev/open-match v0.8.0
 )
@@ -0,0 +1,220 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package admin provides maintenance operations on the relationship data that
+// cover many users, such as repairing mutual relationships and renaming
+// relationship types.  They work with any storage engine, and can run while
+// Tomolink is serving.
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/joeholley/tomolink/internal/storage"
+)
+
+// maxAttempts is how many times an operation on a user is retried because
+// the user's relationships changed while it ran.
+const maxAttempts = 5
+
+// Asymmetry is a relationship from User to Target whose reciprocal, from
+// Target to User, is missing or has a different score.
+type Asymmetry struct {
+	Relationship string
+	User         string
+	Target       string
+	Score        int64
+	// The score of the reciprocal relationship, if it exists
+	Reverse       int64
+	ReverseExists bool
+}
+
+// RepairMode is how Repair fixes a relationship whose reciprocal is missing.
+type RepairMode string
+
+const (
+	// AddMissing creates the missing reciprocal, with the same score.
+	AddMissing RepairMode = "add"
+	// RemoveOneSided deletes the relationship without a reciprocal.
+	RemoveOneSided RepairMode = "remove"
+)
+
+// FindAsymmetric calls f for every relationship of the type whose reciprocal
+// is missing or has a different score.  Pairs with different scores are only
+// reported once.  Relationships changing during the scan can be reported even
+// though they were made symmetric straight after.
+func FindAsymmetric(ctx context.Context, db storage.Engine, relationship string, f func(Asymmetry) error) error {
+	return db.ScanUsers(ctx, "", func(user string) error {
+		scores, _, err := db.GetRelationships(ctx, user, relationship)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, target := range sortedTargets(scores) {
+			if target == user {
+				continue
+			}
+			a := Asymmetry{Relationship: relationship, User: user, Target: target, Score: scores[target]}
+			a.Reverse, _, err = db.GetScore(ctx, target, relationship, user)
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+			case err != nil:
+				return err
+			case a.Reverse == a.Score || user > target:
+				// Symmetric, or reported from the other user
+				continue
+			default:
+				a.ReverseExists = true
+			}
+			if err := f(a); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Repair makes the pair of relationships symmetric.  Pairs with different
+// scores get the higher score in both directions, whatever the mode.
+func Repair(ctx context.Context, db storage.Engine, a Asymmetry, mode RepairMode) error {
+	if a.ReverseExists {
+		score := a.Score
+		if a.Reverse > score {
+			score = a.Reverse
+		}
+		_, err := db.Write(ctx,
+			storage.Write{User: a.User, Relationship: a.Relationship, Target: a.Target, Op: storage.Max, Value: score},
+			storage.Write{User: a.Target, Relationship: a.Relationship, Target: a.User, Op: storage.Max, Value: score},
+		)
+		return err
+	}
+
+	var w storage.Write
+	switch mode {
+	case AddMissing:
+		// Max keeps the score of a reciprocal created since it was found
+		w = storage.Write{User: a.Target, Relationship: a.Relationship, Target: a.User, Op: storage.Max, Value: a.Score}
+	case RemoveOneSided:
+		w = storage.Write{User: a.User, Relationship: a.Relationship, Target: a.Target, Op: storage.Delete}
+	default:
+		return fmt.Errorf("unknown repair mode '%s'", mode)
+	}
+	_, err := db.Write(ctx, w)
+	return err
+}
+
+// RenameRelationship moves every user's relationships of type from to type
+// to, returning how many users had relationships to move.  Where a user
+// already has a relationship of type to with the same target, the higher
+// score is kept.  Each batch of a user's relationships is moved atomically,
+// so no relationship is lost or duplicated if it fails part way, and running
+// it again finishes the job.
+func RenameRelationship(ctx context.Context, db storage.Engine, from, to string, progress func(user string)) (int, error) {
+	if from == to {
+		return 0, errors.New("the relationship types are the same")
+	}
+	users := 0
+	err := db.ScanUsers(ctx, "", func(user string) error {
+		moved, err := storage.MoveRelationships(ctx, db, user, from, to)
+		if err != nil {
+			return fmt.Errorf("renaming user %s's relationships: %v", user, err)
+		}
+		if moved {
+			users++
+		}
+		if progress != nil {
+			progress(user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+// DeleteUser deletes the user, and every other user's relationships with
+// them, returning how many of those there were.  If it fails part way,
+// running it again finishes the job.
+func DeleteUser(ctx context.Context, db storage.Engine, user string) (int, error) {
+	if err := db.DeleteUser(ctx, user); err != nil {
+		return 0, err
+	}
+	return DeleteInbound(ctx, db, user)
+}
+
+// DeleteInbound deletes every other user's relationships with the user,
+// returning how many there were.  There is no index of the relationships
+// with each user, so it reads every user, which is slow for large numbers of
+// users.
+func DeleteInbound(ctx context.Context, db storage.Engine, user string) (int, error) {
+	removed := 0
+	err := db.ScanUsers(ctx, "", func(source string) error {
+		if source == user {
+			return nil
+		}
+		n, err := deleteInbound(ctx, db, source, user)
+		removed += n
+		return err
+	})
+	return removed, err
+}
+
+// deleteInbound deletes the source user's relationships with the target, of
+// any type.
+func deleteInbound(ctx context.Context, db storage.Engine, source, target string) (int, error) {
+	relationships, _, err := db.GetUser(ctx, source)
+	if errors.Is(err, storage.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var writes []storage.Write
+	for rel, scores := range relationships {
+		if _, ok := scores[target]; ok {
+			writes = append(writes, storage.Write{User: source, Relationship: rel, Target: target, Op: storage.Delete})
+		}
+	}
+	if len(writes) == 0 {
+		return 0, nil
+	}
+	if _, err := db.Write(ctx, writes...); err != nil {
+		return 0, err
+	}
+	return len(writes), nil
+}
+
+// sortedTypes returns the relationship types in order.
+func sortedTypes(relationships map[string]storage.Scores) []string {
+	types := make([]string, 0, len(relationships))
+	for rel := range relationships {
+		types = append(types, rel)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// sortedTargets returns the targets of the relationships in order, so
+// operations on large users make progress in a predictable order.
+func sortedTargets(scores storage.Scores) []string {
+	targets := make([]string, 0, len(scores))
+	for target := range scores {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets
+}
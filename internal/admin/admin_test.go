@@ -0,0 +1,200 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/joeholley/tomolink/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func set(user, relationship, target string, score int64) storage.Write {
+	return storage.Write{User: user, Relationship: relationship, Target: target, Op: storage.Set, Value: score}
+}
+
+func TestAsymmetric(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	db := storage.NewMemory()
+	_, err := db.Write(ctx,
+		set("a", "friends", "b", 1), set("b", "friends", "a", 1),
+		set("a", "friends", "c", 2),
+		set("b", "friends", "c", 3), set("c", "friends", "b", 5),
+	)
+	assert.Nil(err)
+
+	var found []Asymmetry
+	err = FindAsymmetric(ctx, db, "friends", func(a Asymmetry) error {
+		found = append(found, a)
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal([]Asymmetry{
+		{Relationship: "friends", User: "a", Target: "c", Score: 2},
+		{Relationship: "friends", User: "b", Target: "c", Score: 3, Reverse: 5, ReverseExists: true},
+	}, found)
+
+	assert.Nil(Repair(ctx, db, found[0], AddMissing))
+	assert.Nil(Repair(ctx, db, found[1], RemoveOneSided))
+	score, _, err := db.GetScore(ctx, "c", "friends", "a")
+	assert.Nil(err)
+	assert.Equal(int64(2), score)
+	// Different scores are repaired to the higher one
+	score, _, err = db.GetScore(ctx, "b", "friends", "c")
+	assert.Nil(err)
+	assert.Equal(int64(5), score)
+
+	found = nil
+	assert.Nil(FindAsymmetric(ctx, db, "friends", func(a Asymmetry) error {
+		found = append(found, a)
+		return nil
+	}))
+	assert.Empty(found)
+}
+
+func TestRenameRelationship(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	db := storage.NewMemory()
+	writes := []storage.Write{set("a", "influencers", "b", 1), set("a", "following", "b", 4), set("c", "friends", "a", 1)}
+	// More relationships than fit in one batch
+	for i := 0; i < db.MaxWrites(); i++ {
+		writes = append(writes, set("b", "influencers", string(rune('A'+i%26))+string(rune('a'+i/26)), int64(i)))
+	}
+	_, err := db.Write(ctx, writes...)
+	assert.Nil(err)
+
+	users, err := RenameRelationship(ctx, db, "influencers", "following", nil)
+	assert.Nil(err)
+	assert.Equal(2, users)
+
+	// The higher score is kept where both types had the relationship
+	scores, _, err := db.GetRelationships(ctx, "a", "following")
+	assert.Nil(err)
+	assert.Equal(storage.Scores{"b": 4}, scores)
+	scores, _, err = db.GetRelationships(ctx, "b", "following")
+	assert.Nil(err)
+	assert.Len(scores, db.MaxWrites())
+	scores, _, err = db.GetRelationships(ctx, "b", "influencers")
+	assert.Nil(err)
+	assert.Empty(scores)
+}
+
+func TestDeleteUser(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	db := storage.NewMemory()
+	_, err := db.Write(ctx,
+		set("a", "friends", "b", 1), set("b", "friends", "a", 1),
+		set("c", "followers", "a", 1), set("c", "friends", "b", 1),
+	)
+	assert.Nil(err)
+
+	removed, err := DeleteUser(ctx, db, "a")
+	assert.Nil(err)
+	assert.Equal(2, removed)
+	_, _, err = db.GetUser(ctx, "a")
+	assert.Equal(storage.ErrNotFound, err)
+	relationships, _, err := db.GetUser(ctx, "c")
+	assert.Nil(err)
+	assert.Equal(map[string]storage.Scores{"followers": {}, "friends": {"b": 1}}, relationships)
+}
+
+func TestExportImport(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	src := storage.NewMemory()
+	_, err := src.Write(ctx,
+		set("a", "friends", "b", 1), set("a", "blocks", "c", -1), set("b", "friends", "a", 7),
+	)
+	assert.Nil(err)
+	want, _, err := src.GetUser(ctx, "a")
+	assert.Nil(err)
+
+	for _, format := range []string{NDJSON, CSV} {
+		var buf bytes.Buffer
+		enc, err := NewEncoder(format, &buf)
+		assert.Nil(err)
+		users, err := Export(ctx, src, enc, "", nil)
+		assert.Nil(err)
+		assert.Equal(2, users)
+
+		dst := storage.NewMemory()
+		dec, err := NewDecoder(format, bytes.NewReader(buf.Bytes()))
+		assert.Nil(err)
+		var checkpoints []int
+		done, err := Import(ctx, dst, dec, ImportOptions{
+			BatchSize:  2,
+			Checkpoint: func(done int) error { checkpoints = append(checkpoints, done); return nil },
+		})
+		assert.Nil(err, format)
+		assert.Equal(3, done)
+		assert.Equal([]int{2, 3}, checkpoints)
+		got, _, err := dst.GetUser(ctx, "a")
+		assert.Nil(err)
+		assert.Equal(want, got, format)
+
+		// Resuming skips the relationships already written
+		dst = storage.NewMemory()
+		dec, err = NewDecoder(format, bytes.NewReader(buf.Bytes()))
+		assert.Nil(err)
+		done, err = Import(ctx, dst, dec, ImportOptions{Skip: 2})
+		assert.Nil(err)
+		assert.Equal(3, done)
+		_, _, err = dst.GetUser(ctx, "a")
+		assert.Equal(storage.ErrNotFound, err)
+		score, _, err := dst.GetScore(ctx, "b", "friends", "a")
+		assert.Nil(err)
+		assert.Equal(int64(7), score)
+	}
+}
+
+func TestMergeUser(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	db := storage.NewMemory()
+	_, err := db.Write(ctx,
+		set("old", "friends", "b", 2), set("old", "friends", "new", 1), set("old", "blocks", "c", 1),
+		set("new", "friends", "b", 3), set("new", "friends", "old", 1), set("new", "blocks", "c", 5),
+		set("d", "friends", "old", 4), set("d", "friends", "new", 1),
+		set("e", "followers", "old", 1),
+	)
+	assert.Nil(err)
+
+	policies := map[string]storage.MergePolicy{"friends": storage.MergeSum, "blocks": storage.MergeKeep}
+	stats, err := MergeUser(ctx, db, "old", "new", policies)
+	assert.Nil(err)
+	assert.Equal(MergeStats{Moved: 2, Redirected: 2, Dropped: 2}, stats)
+
+	_, _, err = db.GetUser(ctx, "old")
+	assert.Equal(storage.ErrNotFound, err)
+	relationships, _, err := db.GetUser(ctx, "new")
+	assert.Nil(err)
+	assert.Equal(map[string]storage.Scores{"friends": {"b": 5}, "blocks": {"c": 5}}, relationships)
+	relationships, _, err = db.GetUser(ctx, "d")
+	assert.Nil(err)
+	assert.Equal(map[string]storage.Scores{"friends": {"new": 5}}, relationships)
+	relationships, _, err = db.GetUser(ctx, "e")
+	assert.Nil(err)
+	assert.Equal(map[string]storage.Scores{"followers": {"new": 1}}, relationships)
+
+	// Merging again finds nothing left to move
+	stats, err = MergeUser(ctx, db, "old", "new", policies)
+	assert.Nil(err)
+	assert.Equal(MergeStats{}, stats)
+}
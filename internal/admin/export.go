@@ -0,0 +1,245 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/joeholley/tomolink/internal/storage"
+)
+
+// Formats of exported relationships.
+const (
+	// NDJSON is newline-delimited JSON, with an object per relationship.
+	NDJSON = "ndjson"
+	// CSV has a header line, and then a line per relationship.
+	CSV = "csv"
+)
+
+// csvHeader is the first line of CSV exports.
+var csvHeader = []string{"source", "relationship", "target", "score"}
+
+// Edge is a single relationship, as exported and imported.
+type Edge struct {
+	Source       string `json:"source"`
+	Relationship string `json:"relationship"`
+	Target       string `json:"target"`
+	Score        int64  `json:"score"`
+}
+
+// EdgeEncoder writes relationships in an export format.
+type EdgeEncoder interface {
+	Encode(e Edge) error
+	// Flush writes any buffered relationships.
+	Flush() error
+}
+
+// EdgeDecoder reads relationships in an export format.
+type EdgeDecoder interface {
+	// Decode returns the next relationship, or io.EOF after the last one.
+	Decode() (Edge, error)
+}
+
+// NewEncoder returns an EdgeEncoder writing the format to w.
+func NewEncoder(format string, w io.Writer) (EdgeEncoder, error) {
+	switch format {
+	case NDJSON:
+		buf := bufio.NewWriter(w)
+		return &jsonEncoder{buf: buf, enc: json.NewEncoder(buf)}, nil
+	case CSV:
+		enc := csv.NewWriter(w)
+		if err := enc.Write(csvHeader); err != nil {
+			return nil, err
+		}
+		return &csvEncoder{enc: enc}, nil
+	}
+	return nil, fmt.Errorf("unknown format '%s'", format)
+}
+
+// NewDecoder returns an EdgeDecoder reading the format from r.
+func NewDecoder(format string, r io.Reader) (EdgeDecoder, error) {
+	switch format {
+	case NDJSON:
+		return &jsonDecoder{dec: json.NewDecoder(r)}, nil
+	case CSV:
+		dec := csv.NewReader(r)
+		dec.FieldsPerRecord = len(csvHeader)
+		if _, err := dec.Read(); err != nil {
+			return nil, fmt.Errorf("reading the CSV header: %v", err)
+		}
+		return &csvDecoder{dec: dec}, nil
+	}
+	return nil, fmt.Errorf("unknown format '%s'", format)
+}
+
+type jsonEncoder struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func (j *jsonEncoder) Encode(e Edge) error {
+	return j.enc.Encode(e)
+}
+
+func (j *jsonEncoder) Flush() error {
+	return j.buf.Flush()
+}
+
+type jsonDecoder struct {
+	dec *json.Decoder
+}
+
+func (j *jsonDecoder) Decode() (Edge, error) {
+	var e Edge
+	err := j.dec.Decode(&e)
+	return e, err
+}
+
+type csvEncoder struct {
+	enc *csv.Writer
+}
+
+func (c *csvEncoder) Encode(e Edge) error {
+	return c.enc.Write([]string{e.Source, e.Relationship, e.Target, strconv.FormatInt(e.Score, 10)})
+}
+
+func (c *csvEncoder) Flush() error {
+	c.enc.Flush()
+	return c.enc.Error()
+}
+
+type csvDecoder struct {
+	dec *csv.Reader
+}
+
+func (c *csvDecoder) Decode() (Edge, error) {
+	record, err := c.dec.Read()
+	if err != nil {
+		return Edge{}, err
+	}
+	score, err := strconv.ParseInt(record[3], 10, 64)
+	if err != nil {
+		return Edge{}, fmt.Errorf("invalid score '%s'", record[3])
+	}
+	return Edge{Source: record[0], Relationship: record[1], Target: record[2], Score: score}, nil
+}
+
+// Export writes every relationship of the users after the user after ("" to
+// start from the first user), a user at a time in user ID order, returning
+// how many users it exported.  Each user's relationships are read at once,
+// so they are consistent with each other, but users changing during the
+// export are exported as they were when they were read.  progress, if not
+// nil, is called after each user, so an interrupted export can be resumed
+// from the last user.
+func Export(ctx context.Context, db storage.Engine, enc EdgeEncoder, after string, progress func(user string)) (int, error) {
+	users := 0
+	err := db.ScanUsers(ctx, after, func(user string) error {
+		relationships, _, err := db.GetUser(ctx, user)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, rel := range sortedTypes(relationships) {
+			for _, target := range sortedTargets(relationships[rel]) {
+				e := Edge{Source: user, Relationship: rel, Target: target, Score: relationships[rel][target]}
+				if err := enc.Encode(e); err != nil {
+					return err
+				}
+			}
+		}
+		users++
+		if progress != nil {
+			progress(user)
+		}
+		return nil
+	})
+	if flushErr := enc.Flush(); err == nil {
+		err = flushErr
+	}
+	return users, err
+}
+
+// ImportOptions configures Import.
+type ImportOptions struct {
+	// How many relationships to write at a time; at most, and by default,
+	// the storage engine's MaxWrites.
+	BatchSize int
+	// How many relationships at the start of the input to skip, because an
+	// earlier import already wrote them.
+	Skip int
+	// If not nil, called after each batch is written, with how many
+	// relationships from the start of the input have been written, for
+	// resuming with Skip.
+	Checkpoint func(done int) error
+}
+
+// Import sets the score of every relationship read from dec, in batches,
+// returning how many relationships from the start of the input have been
+// written, including the ones skipped.  Setting a score is idempotent, so if
+// an import fails, running it again from the last checkpoint, or from the
+// start, gives the same result.
+func Import(ctx context.Context, db storage.Engine, dec EdgeDecoder, opts ImportOptions) (int, error) {
+	if opts.BatchSize <= 0 || opts.BatchSize > db.MaxWrites() {
+		opts.BatchSize = db.MaxWrites()
+	}
+	done := 0
+	var writes []storage.Write
+	flush := func() error {
+		if len(writes) == 0 {
+			return nil
+		}
+		if _, err := db.Write(ctx, writes...); err != nil {
+			return err
+		}
+		done += len(writes)
+		writes = writes[:0]
+		if opts.Checkpoint != nil {
+			return opts.Checkpoint(done)
+		}
+		return nil
+	}
+
+	for {
+		e, err := dec.Decode()
+		if err == io.EOF {
+			return done, flush()
+		}
+		if err != nil {
+			return done, fmt.Errorf("relationship %d: %v", done+len(writes)+1, err)
+		}
+		if done < opts.Skip {
+			done++
+			continue
+		}
+		if e.Source == "" || e.Relationship == "" || e.Target == "" {
+			return done, fmt.Errorf("relationship %d: missing source, relationship or target", done+len(writes)+1)
+		}
+		writes = append(writes, storage.Write{User: e.Source, Relationship: e.Relationship, Target: e.Target, Op: storage.Set, Value: e.Score})
+		if len(writes) >= opts.BatchSize {
+			if err := flush(); err != nil {
+				return done, err
+			}
+		}
+	}
+}
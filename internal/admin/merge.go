@@ -0,0 +1,166 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/joeholley/tomolink/internal/storage"
+)
+
+// MergeStats counts the relationships changed by MergeUser.
+type MergeStats struct {
+	// The merged user's relationships moved to the user merged into
+	Moved int
+	// Other users' relationships with the merged user moved to the user
+	// merged into
+	Redirected int
+	// Relationships between the two users, which are dropped rather than
+	// becoming relationships of a user with themselves
+	Dropped int
+}
+
+// MergeUser merges user from into user to: every relationship of from's, and
+// every other user's relationship with from, becomes a relationship of to's
+// or with to, and then from is deleted.  Where to already has the
+// relationship, it is combined using the policy for its type in policies
+// (storage.MergeMax for types without one).  Each batch of relationships is moved
+// atomically, so if it fails part way no relationship is lost or counted
+// twice, and running it again finishes the job.  Relationships written to
+// from while it runs can be lost, so writes to from should be stopped first.
+// Other users' relationships are found by reading every user, which is slow
+// for large numbers of users.
+func MergeUser(ctx context.Context, db storage.Engine, from, to string, policies map[string]storage.MergePolicy) (MergeStats, error) {
+	var stats MergeStats
+	if from == to {
+		return stats, errors.New("the users are the same")
+	}
+	if err := mergeOutbound(ctx, db, from, to, policies, &stats); err != nil {
+		return stats, fmt.Errorf("moving user %s's relationships: %v", from, err)
+	}
+	err := db.ScanUsers(ctx, "", func(source string) error {
+		if source == from {
+			return nil
+		}
+		if err := mergeInbound(ctx, db, source, from, to, policies, &stats); err != nil {
+			return fmt.Errorf("moving user %s's relationships with %s: %v", source, from, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	return stats, db.DeleteUser(ctx, from)
+}
+
+// policyOp returns the write operation that merges relationships of the type.
+func policyOp(policies map[string]storage.MergePolicy, relationship string) storage.Op {
+	return policies[relationship].Op()
+}
+
+// mergeOutbound moves from's relationships to to, a batch at a time.  Each
+// batch is only written if from's relationships haven't changed since they
+// were read.
+func mergeOutbound(ctx context.Context, db storage.Engine, from, to string, policies map[string]storage.MergePolicy, stats *MergeStats) error {
+	for attempt := 0; attempt < maxAttempts; {
+		relationships, version, err := db.GetUser(ctx, from)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var writes []storage.Write
+		var moved, dropped int
+	batch:
+		for _, rel := range sortedTypes(relationships) {
+			scores := relationships[rel]
+			for _, target := range sortedTargets(scores) {
+				if len(writes)+2 > db.MaxWrites() {
+					break batch
+				}
+				writes = append(writes, storage.Write{User: from, Relationship: rel, Target: target, Op: storage.Delete, IfVersion: version})
+				if target == from || target == to {
+					dropped++
+					continue
+				}
+				writes = append(writes, storage.Write{User: to, Relationship: rel, Target: target, Op: policyOp(policies, rel), Value: scores[target]})
+				moved++
+			}
+		}
+		if len(writes) == 0 {
+			return nil
+		}
+		_, err = db.Write(ctx, writes...)
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			attempt++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		stats.Moved += moved
+		stats.Dropped += dropped
+	}
+	return errors.New("relationships kept changing")
+}
+
+// mergeInbound moves the source user's relationships with from to to, in one
+// write that is only made if the source's relationships haven't changed since
+// they were read.
+func mergeInbound(ctx context.Context, db storage.Engine, source, from, to string, policies map[string]storage.MergePolicy, stats *MergeStats) error {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		relationships, version, err := db.GetUser(ctx, source)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var writes []storage.Write
+		var redirected, dropped int
+		for _, rel := range sortedTypes(relationships) {
+			score, ok := relationships[rel][from]
+			if !ok {
+				continue
+			}
+			writes = append(writes, storage.Write{User: source, Relationship: rel, Target: from, Op: storage.Delete, IfVersion: version})
+			if source == to {
+				dropped++
+				continue
+			}
+			writes = append(writes, storage.Write{User: source, Relationship: rel, Target: to, Op: policyOp(policies, rel), Value: score})
+			redirected++
+		}
+		if len(writes) == 0 {
+			return nil
+		}
+		_, err = db.Write(ctx, writes...)
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		stats.Redirected += redirected
+		stats.Dropped += dropped
+		return nil
+	}
+	return errors.New("relationships kept changing")
+}
@@ -0,0 +1,34 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// caps.go:
+// Per-user relationship caps, set using the 'max' key of the relationship
+// definitions in the config.  The caps themselves are enforced by the storage
+// engine, in the same transaction as the write.
+
+package tomolink
+
+import (
+	"github.com/joeholley/tomolink/internal/config"
+)
+
+// relationshipCap returns the cap that applies to a request, or 0 if the
+// relationship type isn't capped or the request is from an admin.
+func relationshipCap(ac *config.AppConfig, admin bool, rel string) int {
+	max, ok := ac.Definitions().RelationshipCaps[rel]
+	if !ok || admin {
+		return 0
+	}
+	return max
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tomolink
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/joeholley/tomolink/internal/config"
+	"github.com/joeholley/tomolink/pkg/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// capConfig caps users at one friend.
+var capConfig = map[string]string{
+	"RELATIONSHIPS_DEFINITIONS_FRIENDS_MAX": "1",
+	"AUTH_ADMIN_KEY":                        testAdminKey,
+}
+
+func TestCaps(t *testing.T) {
+	assert := assert.New(t)
+	router := Router(newTestConfig(t, capConfig))
+
+	w := serve(router, "PUT", "/v2/users/a/friends/b?delta=1", "")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	// Existing relationships can still be written
+	w = serve(router, "PUT", "/v2/users/a/friends/b?delta=2", "")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	w = serve(router, "PUT", "/v2/users/a/friends/c?delta=1", "")
+	assert.Equal(http.StatusConflict, w.Code, w.Body.String())
+
+	// The admin key overrides the cap
+	w = serve(router, "PUT", "/v2/users/a/friends/c?delta=1", "", config.AdminKeyHeader, testAdminKey)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	w = serve(router, "GET", "/v2/users/a/friends", "")
+	assert.JSONEq(`{"b": 2, "c": 1}`, w.Body.String())
+}
+
+func TestCapsCountBatchWrites(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	client := newTestGRPCClient(t, newTestConfig(t, capConfig))
+
+	// Neither relationship exists yet, but together they go over the cap
+	_, err := client.BatchWrite(ctx, &pb.BatchWriteRequest{Writes: []*pb.BatchWriteRequest_Write{
+		{Op: pb.BatchWriteRequest_CREATE, Relationship: &pb.Relationship{Uuidsource: "a", Uuidtarget: "b", Relationship: "friends", Delta: 1}},
+		{Op: pb.BatchWriteRequest_CREATE, Relationship: &pb.Relationship{Uuidsource: "a", Uuidtarget: "c", Relationship: "friends", Delta: 1}},
+	}})
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+	_, err = client.RetrieveUserRelationships(ctx, &pb.RetrieveUserRelationshipsRequest{Uuidsource: "a"})
+	assert.Equal(codes.NotFound, status.Code(err))
+
+	// Writing the same relationship twice only adds it once
+	_, err = client.BatchWrite(ctx, &pb.BatchWriteRequest{Writes: []*pb.BatchWriteRequest_Write{
+		{Op: pb.BatchWriteRequest_CREATE, Relationship: &pb.Relationship{Uuidsource: "a", Uuidtarget: "b", Relationship: "friends", Delta: 1}},
+		{Op: pb.BatchWriteRequest_UPDATE, Relationship: &pb.Relationship{Uuidsource: "a", Uuidtarget: "b", Relationship: "friends", Delta: 1}},
+	}})
+	assert.Nil(err)
+}
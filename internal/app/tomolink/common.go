@@ -31,12 +31,14 @@
 package tomolink
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 
 	"github.com/joeholley/tomolink/internal/config"
 	"github.com/joeholley/tomolink/internal/models"
+	"github.com/joeholley/tomolink/internal/storage"
 )
 
 // Error represents a handler error. It provides methods for a HTTP status
@@ -96,8 +98,100 @@ func retrieveAndValidateParameters(ac *config.AppConfig, r *http.Request) (*mode
 	params := r.Context().Value("params").(*models.Relationship)
 	err := params.Validate()
 	if err != nil {
-		return nil, fmt.Errorf("cannot process parameters as provided: %w", err)
+		return nil, StatusError{
+			Code: http.StatusBadRequest,
+			Err:  fmt.Errorf("cannot process parameters as provided: %w", err),
+		}
 	}
 
 	return params, nil
 }
+
+// validateNotUpdate rejects creates and deletes that set the fields that only
+// apply to updates, rather than ignoring them.
+func validateNotUpdate(params *models.Relationship) error {
+	if err := params.ValidateNotUpdate(); err != nil {
+		return StatusError{
+			Code: http.StatusBadRequest,
+			Err:  fmt.Errorf("cannot process parameters as provided: %w", err),
+		}
+	}
+	return nil
+}
+
+// relationshipWrites returns the storage writes needed to make the requested
+// change: one for the relationship from the source to the target user, and
+// for mutual requests, a second for the reciprocal relationship.
+func relationshipWrites(params *models.Relationship, op storage.Op, value int64, cap int) []storage.Write {
+	writes := []storage.Write{{
+		User:         params.UUIDSource,
+		Relationship: params.Relationship,
+		Target:       params.UUIDTarget,
+		Op:           op,
+		Value:        value,
+		Cap:          cap,
+	}}
+	if params.IsMultipleDirection() {
+		writes = append(writes, storage.Write{
+			User:         params.UUIDTarget,
+			Relationship: params.Relationship,
+			Target:       params.UUIDSource,
+			Op:           op,
+			Value:        value,
+			Cap:          cap,
+		})
+	}
+	return writes
+}
+
+// updateWrites returns the storage writes for an update, using the operation
+// and conditions in the request.
+func updateWrites(params *models.Relationship, cap int) []storage.Write {
+	op := storage.Increment
+	switch params.Operation {
+	case models.CompareAndSet:
+		op = storage.CompareAndSet
+	case models.Max:
+		op = storage.Max
+	}
+	writes := relationshipWrites(params, op, int64(params.Delta), cap)
+	for i := range writes {
+		if params.Expected != nil {
+			writes[i].Expected = *params.Expected
+		}
+		writes[i].Ceiling = params.Ceiling
+		writes[i].DeleteIfNotPositive = params.DeleteIfNotPositive
+	}
+	return writes
+}
+
+// directionDescription describes the direction of the request for logging.
+func directionDescription(params *models.Relationship) string {
+	if params.IsMultipleDirection() {
+		return "bi-directional"
+	}
+	return "uni-directional"
+}
+
+// storageError converts errors from the storage engine that the client can do
+// something about into StatusErrors.
+func storageError(err error) error {
+	var capErr *storage.CapExceededError
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return StatusError{Code: http.StatusNotFound, Err: err}
+	case errors.As(err, &capErr):
+		return StatusError{Code: http.StatusConflict, Err: err}
+	case errors.Is(err, storage.ErrConditionFailed):
+		return StatusError{Code: http.StatusConflict, Err: err}
+	case errors.Is(err, storage.ErrVersionMismatch):
+		return StatusError{Code: http.StatusPreconditionFailed, Err: err}
+	case errors.Is(err, storage.ErrIdempotencyKeyReused):
+		return StatusError{Code: http.StatusUnprocessableEntity, Err: err}
+	case errors.Is(err, errIdempotencyKeyTooLong):
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	case errors.Is(err, storage.ErrUnavailable):
+		return StatusError{Code: http.StatusServiceUnavailable, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,216 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// definitions.go:
+// Handlers managing the relationship type definitions at runtime, without
+// changing the config file and restarting Tomolink.
+
+package tomolink
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/joeholley/tomolink/internal/config"
+	tljson "github.com/joeholley/tomolink/internal/json"
+	"github.com/joeholley/tomolink/internal/storage"
+	"github.com/joeholley/tomolink/internal/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+// definitionsPath is where the relationship type definitions are managed.
+const definitionsPath = "/relationshipTypes"
+
+// Where a relationship type definition in effect comes from.
+const (
+	definitionFromConfig  = "config"
+	definitionFromRuntime = "runtime"
+)
+
+// relationshipType is a relationship type definition in effect.  The cache
+// and aliases settings can only be set in the config file, as they change
+// how the database is used.
+type relationshipType struct {
+	Name string `json:"name"`
+	storage.Definition
+	Cache   bool     `json:"cache"`
+	Aliases []string `json:"aliases,omitempty"`
+	Source  string   `json:"source"`
+}
+
+// DefinitionsHandler returns the handler for the relationship type
+// definition endpoints.  They're served outside the router, as they don't act
+// on a user's relationships: the request body is the definition rather than
+// relationship parameters, and changes need the admin key rather than an
+// end-user token.
+func DefinitionsHandler(ac *config.AppConfig) http.Handler {
+	r := mux.NewRouter()
+	for _, route := range []struct {
+		name, path, method string
+		h                  func(*config.AppConfig, http.ResponseWriter, *http.Request) error
+	}{
+		// GET endpoint for every relationship type definition in effect
+		{"listRelationshipTypes", definitionsPath, "GET", ListDefinitions},
+		// GET endpoint for one relationship type definition
+		{"retrieveRelationshipType", definitionsPath + "/{name}", "GET", RetrieveDefinition},
+		// PUT endpoint to create or replace a relationship type definition
+		{"putRelationshipType", definitionsPath + "/{name}", "PUT", PutDefinition},
+		// DELETE endpoint to retire a relationship type
+		{"retireRelationshipType", definitionsPath + "/{name}", "DELETE", RetireDefinition},
+	} {
+		r.Handle(route.path, Handler{ac, route.h}).
+			Methods(route.method).
+			Name(route.name)
+		tlLog.WithFields(logrus.Fields{
+			"route": route.path,
+			"name":  route.name,
+		}).Info("Added route")
+	}
+	return r
+}
+
+// describeDefinition returns the definition of a relationship type in effect,
+// and whether it's defined.
+func describeDefinition(ac *config.AppConfig, defs *config.Definitions, name string) (relationshipType, bool) {
+	d, ok := defs.Definition(name)
+	if !ok {
+		return relationshipType{}, false
+	}
+	t := relationshipType{
+		Name:       name,
+		Definition: d,
+		Cache:      !ac.UncachedRelationships[name],
+		Source:     definitionFromConfig,
+	}
+	if _, ok := defs.Managed[name]; ok {
+		t.Source = definitionFromRuntime
+	}
+	for alias, current := range ac.RelationshipAliases {
+		if current == name {
+			t.Aliases = append(t.Aliases, alias)
+		}
+	}
+	sort.Strings(t.Aliases)
+	return t, true
+}
+
+// ListDefinitions returns every relationship type definition in effect on
+// this instance, in name order.
+func ListDefinitions(ac *config.AppConfig, w http.ResponseWriter, r *http.Request) error {
+	defs := ac.Definitions()
+	names := make([]string, 0, len(defs.Relationships))
+	for name := range defs.Relationships {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	types := make([]relationshipType, 0, len(names))
+	for _, name := range names {
+		t, _ := describeDefinition(ac, defs, name)
+		types = append(types, t)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(types)
+}
+
+// RetrieveDefinition returns the definition in effect of one relationship
+// type.
+func RetrieveDefinition(ac *config.AppConfig, w http.ResponseWriter, r *http.Request) error {
+	name := mux.Vars(r)["name"]
+	t, ok := describeDefinition(ac, ac.Definitions(), name)
+	if !ok {
+		return StatusError{Code: http.StatusNotFound, Err: config.ErrUnknownRelationship}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(t)
+}
+
+// definitionsEngine returns the storage engine the definitions are stored
+// in, or a 501 if none is connected.
+func definitionsEngine(ac *config.AppConfig) (storage.Engine, error) {
+	db, ok := ac.DB.(storage.Engine)
+	if !ok {
+		return nil, StatusError{Code: http.StatusNotImplemented, Err: errors.New("no storage engine connected to store relationship types in")}
+	}
+	return db, nil
+}
+
+// PutDefinition creates or replaces the definition of a relationship type,
+// from the type, max, merge and deprecated fields of the request body.  It is
+// stored in the database, and in effect on every instance after their next
+// refresh.  It returns the new definition, with a 201 if the type wasn't
+// defined before.
+func PutDefinition(ac *config.AppConfig, w http.ResponseWriter, r *http.Request) error {
+	pdLog := tracing.Logger(r.Context(), hnLog)
+	if !ac.IsAdmin(r) {
+		return StatusError{Code: http.StatusForbidden, Err: errors.New("managing relationship types requires the admin key")}
+	}
+	name := mux.Vars(r)["name"]
+	var d storage.Definition
+	if err := tljson.DecodeJSONBody(w, r, &d); err != nil && err.Error() != "Request body is empty" {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	db, err := definitionsEngine(ac)
+	if err != nil {
+		return err
+	}
+	created, err := ac.PutDefinition(r.Context(), db, name, d)
+	if errors.Is(err, config.ErrInvalidDefinition) {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+	if err != nil {
+		pdLog.WithFields(logrus.Fields{"relationship": name, "error": err.Error()}).Error("Cannot store relationship type definition")
+		return storageError(err)
+	}
+	pdLog.WithFields(logrus.Fields{"relationship": name, "created": created}).Info("Relationship type definition stored")
+
+	t, _ := describeDefinition(ac, ac.Definitions(), name)
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.Header().Set("Location", r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+	}
+	return json.NewEncoder(w).Encode(t)
+}
+
+// RetireDefinition deprecates a relationship type: its relationships can
+// still be read, but no longer written.  Nothing is deleted, and it can be
+// undone by putting the definition again with deprecated false.
+func RetireDefinition(ac *config.AppConfig, w http.ResponseWriter, r *http.Request) error {
+	rdLog := tracing.Logger(r.Context(), hnLog)
+	if !ac.IsAdmin(r) {
+		return StatusError{Code: http.StatusForbidden, Err: errors.New("managing relationship types requires the admin key")}
+	}
+	name := mux.Vars(r)["name"]
+	db, err := definitionsEngine(ac)
+	if err != nil {
+		return err
+	}
+	err = ac.RetireDefinition(r.Context(), db, name)
+	if errors.Is(err, config.ErrUnknownRelationship) {
+		return StatusError{Code: http.StatusNotFound, Err: err}
+	}
+	if err != nil {
+		rdLog.WithFields(logrus.Fields{"relationship": name, "error": err.Error()}).Error("Cannot retire relationship type")
+		return storageError(err)
+	}
+	rdLog.WithFields(logrus.Fields{"relationship": name}).Info("Relationship type retired")
+
+	t, _ := describeDefinition(ac, ac.Definitions(), name)
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(t)
+}
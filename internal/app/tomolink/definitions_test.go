@@ -0,0 +1,133 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tomolink
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/joeholley/tomolink/internal/config"
+	"github.com/joeholley/tomolink/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// adminConfig sets the admin key the definition changes need.
+var adminConfig = map[string]string{"AUTH_ADMIN_KEY": testAdminKey}
+
+func TestListAndRetrieveDefinitions(t *testing.T) {
+	assert := assert.New(t)
+	h := DefinitionsHandler(newTestConfig(t, adminConfig))
+
+	w := serve(h, "GET", "/relationshipTypes", "")
+	assert.Equal(http.StatusOK, w.Code)
+	var types []relationshipType
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &types))
+	var names []string
+	for _, t := range types {
+		names = append(names, t.Name)
+	}
+	assert.Equal([]string{"blocks", "followers", "friends", "influencers"}, names)
+
+	w = serve(h, "GET", "/relationshipTypes/friends", "")
+	assert.Equal(http.StatusOK, w.Code)
+	var friends relationshipType
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &friends))
+	assert.Equal("score", friends.Type)
+	assert.Equal(definitionFromConfig, friends.Source)
+
+	w = serve(h, "GET", "/relationshipTypes/rivals", "")
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestPutAndRetireDefinition(t *testing.T) {
+	assert := assert.New(t)
+	ac := newTestConfig(t, adminConfig)
+	h := DefinitionsHandler(ac)
+	router := Router(ac)
+	admin := []string{config.AdminKeyHeader, testAdminKey}
+
+	// Changes need the admin key
+	w := serve(h, "PUT", "/relationshipTypes/rivals", `{"max": 1}`)
+	assert.Equal(http.StatusForbidden, w.Code)
+	w = serve(h, "DELETE", "/relationshipTypes/friends", "")
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	w = serve(h, "PUT", "/relationshipTypes/rivals", `{"max": 1}`, admin...)
+	assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal("/relationshipTypes/rivals", w.Header().Get("Location"))
+	var rivals relationshipType
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &rivals))
+	assert.Equal(relationshipType{
+		Name:       "rivals",
+		Definition: storage.Definition{Type: "score", Max: 1, Merge: "max"},
+		Cache:      true,
+		Source:     definitionFromRuntime,
+	}, rivals)
+	w = serve(h, "PUT", "/relationshipTypes/rivals", `{"max": 2}`, admin...)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	w = serve(h, "PUT", "/relationshipTypes/rivals", `{"merge": "average"}`, admin...)
+	assert.Equal(http.StatusBadRequest, w.Code, w.Body.String())
+
+	// The new type is in effect, with its cap
+	w = serve(router, "PUT", "/v2/users/a/rivals/b?delta=1", "")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	// Retired types can be read, but not written
+	w = serve(h, "DELETE", "/relationshipTypes/rivals", "", admin...)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &rivals))
+	assert.True(rivals.Deprecated)
+	w = serve(router, "GET", "/v2/users/a/rivals/b", "")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	w = serve(router, "PUT", "/v2/users/a/rivals/c?delta=1", "")
+	assert.NotEqual(http.StatusOK, w.Code, w.Body.String())
+	w = serve(h, "DELETE", "/relationshipTypes/nemeses", "", admin...)
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestDefinitionsWithoutEngine(t *testing.T) {
+	ac := newTestConfig(t, adminConfig)
+	ac.DB = nil
+	h := DefinitionsHandler(ac)
+	w := serve(h, "PUT", "/relationshipTypes/rivals", `{}`, config.AdminKeyHeader, testAdminKey)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+	w = serve(h, "DELETE", "/relationshipTypes/friends", "", config.AdminKeyHeader, testAdminKey)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
+
+func TestWatchDefinitions(t *testing.T) {
+	assert := assert.New(t)
+	ac := newTestConfig(t, adminConfig)
+	// Another instance using the same database
+	other := newTestConfig(t, nil)
+	other.DB = ac.DB
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go other.WatchDefinitions(ctx, other.DB.(storage.Engine), 10*time.Millisecond)
+
+	w := serve(DefinitionsHandler(ac), "PUT", "/relationshipTypes/rivals", `{"max": 3}`, config.AdminKeyHeader, testAdminKey)
+	assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, ok := other.Definitions().Relationships["rivals"]; ok {
+			break
+		}
+	}
+	defs := other.Definitions()
+	assert.Equal("score", defs.Relationships["rivals"])
+	assert.Equal(3, defs.RelationshipCaps["rivals"])
+}
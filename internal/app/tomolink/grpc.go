@@ -0,0 +1,466 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// grpc.go:
+// The gRPC API, defined in api/tomolink.proto.  Requests go through the same
+// validation, strict relationship, end-user token and rate limit checks as the
+// HTTP API, are traced and counted in the same way, and use the same storage
+// operations.
+
+package tomolink
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/joeholley/tomolink/internal/auth"
+	"github.com/joeholley/tomolink/internal/config"
+	"github.com/joeholley/tomolink/internal/models"
+	"github.com/joeholley/tomolink/internal/storage"
+	"github.com/joeholley/tomolink/internal/tracing"
+	"github.com/joeholley/tomolink/pkg/pb"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// maxBatchSize is the most operations a batch request can contain.  Batch
+// writes are also limited by how many writes the storage engine can make in
+// one transaction (see BatchWrite).
+const maxBatchSize = 250
+
+type grpcServer struct {
+	pb.UnimplementedTomolinkServer
+	ac *config.AppConfig
+	// The rate limits shared with the HTTP API, or nil if they're off
+	rl *rateLimits
+}
+
+// NewGRPCServer returns a gRPC server with the Tomolink service registered.
+// Tracing, request metrics and rate limiting are turned on by the same config
+// values as they are for the HTTP API (see Router).
+func NewGRPCServer(ac *config.AppConfig) *grpc.Server {
+	srv := &grpcServer{ac: ac}
+
+	// As in the router, tracing comes first so the request span is the parent
+	// of all the others, followed by the request metrics.
+	var interceptors []grpc.UnaryServerInterceptor
+	if trace, _ := ac.Cfg.BoolOr("tracing.enabled", false); trace == true {
+		interceptors = append(interceptors, traceGRPC)
+	}
+	interceptors = append(interceptors, grpcMetrics(ac))
+
+	// Rate limits are checked in validate, after the end-user tokens, like
+	// they are in the router.
+	if limit, _ := ac.Cfg.BoolOr("ratelimit.enabled", false); limit == true {
+		rl, err := sharedRateLimits(ac)
+		if err != nil {
+			tlLog.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatal("Cannot configure rate limiting")
+		}
+		srv.rl = rl
+	}
+
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+	pb.RegisterTomolinkServer(s, srv)
+	return s
+}
+
+// GRPCHandler returns a handler that sends gRPC requests to the gRPC server
+// and all other requests to next, so both APIs can be served on the same
+// port.  gRPC needs HTTP/2, so this also accepts HTTP/2 without TLS (h2c),
+// as used by Cloud Run.
+func GRPCHandler(s *grpc.Server, next http.Handler) http.Handler {
+	return h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			s.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}), &http2.Server{})
+}
+
+func (s *grpcServer) RetrieveUserRelationships(ctx context.Context, req *pb.RetrieveUserRelationshipsRequest) (*pb.RetrieveUserRelationshipsResponse, error) {
+	params := &models.Relationship{UUIDSource: req.Uuidsource}
+	if err := s.validate(ctx, params, false, field{"uuidsource", req.Uuidsource}); err != nil {
+		return nil, err
+	}
+
+	relationships, version, err := s.ac.DB.(storage.Engine).GetUser(ctx, params.UUIDSource)
+	if err != nil {
+		return nil, s.grpcError(ctx, params, err)
+	}
+	setVersion(ctx, version)
+	resp := &pb.RetrieveUserRelationshipsResponse{Relationships: map[string]*pb.Scores{}}
+	for rel, scores := range relationships {
+		resp.Relationships[rel] = &pb.Scores{Scores: scores}
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) RetrieveUserRelationshipsByType(ctx context.Context, req *pb.RetrieveUserRelationshipsByTypeRequest) (*pb.Scores, error) {
+	params := &models.Relationship{UUIDSource: req.Uuidsource, Relationship: req.Relationship}
+	if err := s.validate(ctx, params, false,
+		field{"uuidsource", req.Uuidsource},
+		field{"relationship", req.Relationship},
+	); err != nil {
+		return nil, err
+	}
+
+	scores, version, err := s.ac.DB.(storage.Engine).GetRelationships(ctx, params.UUIDSource, params.Relationship)
+	if err != nil {
+		return nil, s.grpcError(ctx, params, err)
+	}
+	setVersion(ctx, version)
+	return &pb.Scores{Scores: scores}, nil
+}
+
+func (s *grpcServer) RetrieveSingleRelationship(ctx context.Context, req *pb.RetrieveSingleRelationshipRequest) (*pb.RetrieveSingleRelationshipResponse, error) {
+	params, err := s.singleParams(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	score, version, err := s.ac.DB.(storage.Engine).GetScore(ctx, params.UUIDSource, params.Relationship, params.UUIDTarget)
+	if err != nil {
+		return nil, s.grpcError(ctx, params, err)
+	}
+	setVersion(ctx, version)
+	return &pb.RetrieveSingleRelationshipResponse{Score: score}, nil
+}
+
+func (s *grpcServer) CreateRelationship(ctx context.Context, req *pb.Relationship) (*pb.WriteResponse, error) {
+	return s.write(ctx, pb.BatchWriteRequest_CREATE, req)
+}
+
+func (s *grpcServer) UpdateRelationship(ctx context.Context, req *pb.Relationship) (*pb.WriteResponse, error) {
+	return s.write(ctx, pb.BatchWriteRequest_UPDATE, req)
+}
+
+func (s *grpcServer) DeleteRelationship(ctx context.Context, req *pb.Relationship) (*pb.WriteResponse, error) {
+	return s.write(ctx, pb.BatchWriteRequest_DELETE, req)
+}
+
+func (s *grpcServer) BatchRetrieveSingleRelationships(ctx context.Context, req *pb.BatchRetrieveSingleRelationshipsRequest) (*pb.BatchRetrieveSingleRelationshipsResponse, error) {
+	if len(req.Requests) > maxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "batch has %d requests, the maximum is %d", len(req.Requests), maxBatchSize)
+	}
+
+	resp := &pb.BatchRetrieveSingleRelationshipsResponse{}
+	for _, r := range req.Requests {
+		params, err := s.singleParams(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		result := &pb.BatchRetrieveSingleRelationshipsResponse_Result{}
+		score, _, err := s.ac.DB.(storage.Engine).GetScore(ctx, params.UUIDSource, params.Relationship, params.UUIDTarget)
+		switch {
+		case err == nil:
+			result.Found, result.Score = true, score
+		case !errors.Is(err, storage.ErrNotFound):
+			return nil, s.grpcError(ctx, params, err)
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) BatchWrite(ctx context.Context, req *pb.BatchWriteRequest) (*pb.WriteResponse, error) {
+	if len(req.Writes) > maxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "batch has %d writes, the maximum is %d", len(req.Writes), maxBatchSize)
+	}
+
+	var writes []storage.Write
+	for _, w := range req.Writes {
+		if w.Relationship == nil {
+			return nil, status.Error(codes.InvalidArgument, "relationship is required")
+		}
+		params, ws, err := s.relationshipWrites(ctx, w.Op, w.Relationship)
+		if err != nil {
+			return nil, err
+		}
+		writes = append(writes, ws...)
+		tracing.Logger(ctx, params.VerboseLogger()).Debug("batch write added")
+	}
+
+	// Mutual writes take two storage writes, and an idempotency key one more
+	n := len(writes)
+	if header := idempotencyHeader(s.ac); header != "" && fromMetadata(ctx, header) != "" {
+		n++
+	}
+	if max := s.ac.DB.(storage.Engine).MaxWrites(); n > max {
+		return nil, status.Errorf(codes.InvalidArgument, "batch needs %d storage writes, the maximum is %d", n, max)
+	}
+
+	resp, err := s.applyWrites(ctx, writes)
+	if err != nil {
+		return nil, s.grpcError(ctx, nil, err)
+	}
+	return resp, nil
+}
+
+// write makes a single create, update or delete, conditional on the version
+// in the 'if-match' request metadata if there is one.
+func (s *grpcServer) write(ctx context.Context, op pb.BatchWriteRequest_Op, req *pb.Relationship) (*pb.WriteResponse, error) {
+	params, writes, err := s.relationshipWrites(ctx, op, req)
+	if err != nil {
+		return nil, err
+	}
+	writes = withVersion(writes, ifMatch(fromMetadata(ctx, ifMatchHeader)))
+	resp, err := s.applyWrites(ctx, writes)
+	if err != nil {
+		return nil, s.grpcError(ctx, params, err)
+	}
+	tracing.Logger(ctx, params.VerboseLogger()).Info(directionDescription(params) + " relationship written")
+	return resp, nil
+}
+
+// applyWrites makes the writes, using the idempotency key in the request
+// metadata if there is one, in the scope of the first write's source user.  Replayed requests get the ReplayedHeader in the
+// response metadata.
+func (s *grpcServer) applyWrites(ctx context.Context, writes []storage.Write) (*pb.WriteResponse, error) {
+	var key string
+	if header := idempotencyHeader(s.ac); header != "" {
+		key = fromMetadata(ctx, header)
+	}
+	var user string
+	if len(writes) > 0 {
+		user = writes[0].User
+	}
+	scope := idempotencyScope(s.ac, fromMetadata(ctx, config.AdminKeyHeader), user)
+	result, err := applyWrites(ctx, s.ac, scope, key, writes)
+	if err != nil {
+		return nil, err
+	}
+	if result.Replayed {
+		grpc.SetHeader(ctx, metadata.Pairs(ReplayedHeader, "true"))
+	}
+	resp := &pb.WriteResponse{}
+	if !result.Time.IsZero() {
+		resp.WriteTime = timestamppb.New(result.Time)
+	}
+	return resp, nil
+}
+
+// relationshipWrites validates a write request and returns the storage
+// writes needed to make it.
+func (s *grpcServer) relationshipWrites(ctx context.Context, op pb.BatchWriteRequest_Op, req *pb.Relationship) (*models.Relationship, []storage.Write, error) {
+	params := &models.Relationship{
+		UUIDSource:          req.Uuidsource,
+		UUIDTarget:          req.Uuidtarget,
+		Relationship:        req.Relationship,
+		Delta:               int(req.Delta),
+		Direction:           direction(req.Direction),
+		Expected:            req.Expected,
+		Ceiling:             req.Ceiling,
+		DeleteIfNotPositive: req.DeleteIfNotPositive,
+	}
+	if op == pb.BatchWriteRequest_UPDATE || req.Operation != pb.Operation_OPERATION_UNSPECIFIED {
+		params.Operation = updateOperation(req.Operation)
+	}
+	// Creates and deletes reject the update fields, as over HTTP
+	if op != pb.BatchWriteRequest_UPDATE {
+		if err := params.ValidateNotUpdate(); err != nil {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "cannot process parameters as provided: %v", err)
+		}
+	}
+	if err := s.validate(ctx, params, true,
+		field{"uuidsource", req.Uuidsource},
+		field{"uuidtarget", req.Uuidtarget},
+		field{"relationship", req.Relationship},
+	); err != nil {
+		return nil, nil, err
+	}
+
+	limit := relationshipCap(s.ac, s.ac.IsAdminKey(fromMetadata(ctx, config.AdminKeyHeader)), params.Relationship)
+	switch op {
+	case pb.BatchWriteRequest_CREATE:
+		return params, relationshipWrites(params, storage.Set, req.Delta, limit), nil
+	case pb.BatchWriteRequest_UPDATE:
+		return params, updateWrites(params, limit), nil
+	case pb.BatchWriteRequest_DELETE:
+		return params, relationshipWrites(params, storage.Delete, 0, 0), nil
+	}
+	return nil, nil, status.Errorf(codes.InvalidArgument, "unsupported write op %v", op)
+}
+
+// singleParams validates a request for a single relationship.
+func (s *grpcServer) singleParams(ctx context.Context, req *pb.RetrieveSingleRelationshipRequest) (*models.Relationship, error) {
+	params := &models.Relationship{
+		UUIDSource:   req.Uuidsource,
+		UUIDTarget:   req.Uuidtarget,
+		Relationship: req.Relationship,
+	}
+	err := s.validate(ctx, params, false,
+		field{"uuidsource", req.Uuidsource},
+		field{"uuidtarget", req.Uuidtarget},
+		field{"relationship", req.Relationship},
+	)
+	return params, err
+}
+
+// field is a request field that must not be empty.
+type field struct {
+	name, value string
+}
+
+// validate runs the same checks on gRPC requests that the router middleware
+// and retrieveAndValidateParameters run on HTTP requests.
+func (s *grpcServer) validate(ctx context.Context, params *models.Relationship, write bool, required ...field) error {
+	for _, f := range required {
+		if f.value == "" {
+			return status.Errorf(codes.InvalidArgument, "%s is required", f.name)
+		}
+	}
+	if err := params.Validate(); err != nil {
+		return status.Errorf(codes.InvalidArgument, "cannot process parameters as provided: %v", err)
+	}
+	params.Relationship = s.ac.RelationshipName(params.Relationship)
+	if write && s.ac.Definitions().DeprecatedRelationships[params.Relationship] {
+		tracing.Logger(ctx, params.VerboseLogger()).Warn("refused write to deprecated relationship type")
+		return status.Errorf(codes.InvalidArgument, "relationship type '%s' is deprecated, and can't be written", params.Relationship)
+	}
+	if params.Relationship != "" && !s.ac.ValidRelationship(params) {
+		tracing.Logger(ctx, params.VerboseLogger()).Warn("failed strict relationship validity check")
+		return status.Errorf(codes.InvalidArgument, "relationship type '%s' is not defined", params.Relationship)
+	}
+
+	if err := s.checkTokens(ctx, params, write); err != nil {
+		return err
+	}
+	return s.rateLimit(ctx, params, write)
+}
+
+// checkTokens checks end-user tokens under the same conditions as EndUserMW.
+func (s *grpcServer) checkTokens(ctx context.Context, params *models.Relationship, write bool) error {
+	if enduser, _ := s.ac.Cfg.BoolOr("auth.enduser.enabled", false); enduser == false {
+		return nil
+	}
+	if enforceReads, _ := s.ac.Cfg.BoolOr("auth.enduser.enforceReads", false); !write && !enforceReads {
+		return nil
+	}
+	if s.ac.IsAdminKey(fromMetadata(ctx, config.AdminKeyHeader)) {
+		return nil
+	}
+	headerName, _ := s.ac.Cfg.StringOr("auth.enduser.header", "Authorization")
+	err := s.ac.CheckEndUserToken(auth.FromHeader(fromMetadata(ctx, headerName)), params.UUIDSource)
+	if err == nil && write && params.IsMultipleDirection() {
+		targetHeaderName, _ := s.ac.Cfg.StringOr("auth.enduser.targetHeader", "X-Tomolink-Target-Token")
+		err = s.ac.CheckTargetToken(auth.FromHeader(fromMetadata(ctx, targetHeaderName)), params.UUIDTarget)
+	}
+	switch {
+	case err == config.ErrWrongUser || err == config.ErrWrongTarget:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case err != nil:
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return nil
+}
+
+// rateLimit applies the rate limits shared with the HTTP API.  Each request in
+// a batch counts as a separate request.  Refused requests get the number of
+// seconds to wait in the 'retry-after' response metadata.
+func (s *grpcServer) rateLimit(ctx context.Context, params *models.Relationship, write bool) error {
+	if s.rl == nil {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var remoteAddr string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+	caller := s.rl.callerID(func(name string) string { return fromMetadata(ctx, name) }, md.Get("x-forwarded-for"), remoteAddr)
+	if retry, ok := s.rl.allow(ctx, caller, params, write); !ok {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(retry)))
+		return status.Error(codes.ResourceExhausted, http.StatusText(http.StatusTooManyRequests))
+	}
+	return nil
+}
+
+// grpcError converts errors from the storage engine to gRPC status errors.
+func (s *grpcServer) grpcError(ctx context.Context, params *models.Relationship, err error) error {
+	gLog := tracing.Logger(ctx, hnLog)
+	if params != nil {
+		gLog = tracing.Logger(ctx, params.VerboseLogger())
+	}
+
+	var capErr *storage.CapExceededError
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.As(err, &capErr):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, storage.ErrVersionMismatch) || errors.Is(err, storage.ErrConditionFailed):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, storage.ErrIdempotencyKeyReused) || errors.Is(err, errIdempotencyKeyTooLong):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, storage.ErrUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+
+	gLog.WithFields(logrus.Fields{"error": err.Error()}).Error("storage operation failed")
+	// Errors from the database client are often already gRPC status errors
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// fromMetadata returns the value of the named header in the request metadata.
+func fromMetadata(ctx context.Context, name string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(name); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// setVersion sends the version of the user's relationships in the 'etag'
+// response metadata.
+func setVersion(ctx context.Context, version string) {
+	grpc.SetHeader(ctx, metadata.Pairs(etagHeader, etag(version)))
+}
+
+// direction converts a gRPC direction to the equivalent models.Relationship
+// direction.
+func direction(d pb.Direction) string {
+	if d == pb.Direction_MUTUAL {
+		return "mutual"
+	}
+	return "single"
+}
+
+// updateOperation converts a gRPC update operation to the equivalent
+// models.Relationship operation.
+func updateOperation(o pb.Operation) string {
+	switch o {
+	case pb.Operation_COMPARE_AND_SET:
+		return models.CompareAndSet
+	case pb.Operation_MAX:
+		return models.Max
+	}
+	return models.Increment
+}
@@ -0,0 +1,251 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tomolink
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/joeholley/tomolink/internal/config"
+	"github.com/joeholley/tomolink/internal/storage"
+	"github.com/joeholley/tomolink/pkg/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestGRPCClient serves the gRPC API for the config in memory, and returns
+// a client connected to it.
+func newTestGRPCClient(t *testing.T, ac *config.AppConfig) pb.TomolinkClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := NewGRPCServer(ac)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewTomolinkClient(conn)
+}
+
+func TestGRPCWriteAndRetrieve(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	client := newTestGRPCClient(t, newTestConfig(t, nil))
+
+	resp, err := client.CreateRelationship(ctx, &pb.Relationship{
+		Uuidsource: "a", Uuidtarget: "b", Relationship: "friends", Delta: 3, Direction: pb.Direction_MUTUAL,
+	})
+	assert.Nil(err)
+	assert.NotNil(resp.WriteTime)
+	_, err = client.UpdateRelationship(ctx, &pb.Relationship{
+		Uuidsource: "a", Uuidtarget: "b", Relationship: "friends", Delta: 2,
+	})
+	assert.Nil(err)
+
+	var header metadata.MD
+	single, err := client.RetrieveSingleRelationship(ctx, &pb.RetrieveSingleRelationshipRequest{
+		Uuidsource: "a", Uuidtarget: "b", Relationship: "friends",
+	}, grpc.Header(&header))
+	assert.Nil(err)
+	assert.Equal(int64(5), single.Score)
+	assert.NotEmpty(header.Get(etagHeader))
+
+	// Only the source user's relationship was updated
+	scores, err := client.RetrieveUserRelationshipsByType(ctx, &pb.RetrieveUserRelationshipsByTypeRequest{
+		Uuidsource: "b", Relationship: "friends",
+	})
+	assert.Nil(err)
+	assert.Equal(map[string]int64{"a": 3}, scores.Scores)
+
+	_, err = client.DeleteRelationship(ctx, &pb.Relationship{
+		Uuidsource: "a", Uuidtarget: "b", Relationship: "friends", Direction: pb.Direction_MUTUAL,
+	})
+	assert.Nil(err)
+	_, err = client.RetrieveSingleRelationship(ctx, &pb.RetrieveSingleRelationshipRequest{
+		Uuidsource: "b", Uuidtarget: "a", Relationship: "friends",
+	})
+	assert.Equal(codes.NotFound, status.Code(err))
+
+	_, err = client.CreateRelationship(ctx, &pb.Relationship{Uuidsource: "a", Relationship: "friends"})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCBatch(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	client := newTestGRPCClient(t, newTestConfig(t, nil))
+
+	_, err := client.BatchWrite(ctx, &pb.BatchWriteRequest{Writes: []*pb.BatchWriteRequest_Write{
+		{Op: pb.BatchWriteRequest_CREATE, Relationship: &pb.Relationship{Uuidsource: "a", Uuidtarget: "b", Relationship: "friends", Delta: 1}},
+		{Op: pb.BatchWriteRequest_CREATE, Relationship: &pb.Relationship{Uuidsource: "a", Uuidtarget: "c", Relationship: "blocks", Delta: 1}},
+	}})
+	assert.Nil(err)
+
+	resp, err := client.BatchRetrieveSingleRelationships(ctx, &pb.BatchRetrieveSingleRelationshipsRequest{
+		Requests: []*pb.RetrieveSingleRelationshipRequest{
+			{Uuidsource: "a", Uuidtarget: "b", Relationship: "friends"},
+			{Uuidsource: "a", Uuidtarget: "b", Relationship: "blocks"},
+		},
+	})
+	assert.Nil(err)
+	if assert.Len(resp.Results, 2) {
+		assert.True(resp.Results[0].Found)
+		assert.Equal(int64(1), resp.Results[0].Score)
+		assert.False(resp.Results[1].Found)
+	}
+
+	writes := make([]*pb.BatchWriteRequest_Write, maxBatchSize+1)
+	for i := range writes {
+		writes[i] = &pb.BatchWriteRequest_Write{Op: pb.BatchWriteRequest_DELETE,
+			Relationship: &pb.Relationship{Uuidsource: "a", Uuidtarget: "b", Relationship: "friends"}}
+	}
+	_, err = client.BatchWrite(ctx, &pb.BatchWriteRequest{Writes: writes})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCBatchStorageWrites(t *testing.T) {
+	assert := assert.New(t)
+	ac := newTestConfig(t, nil)
+	client := newTestGRPCClient(t, ac)
+	max := ac.DB.(storage.Engine).MaxWrites()
+	mutual := func(n int) *pb.BatchWriteRequest {
+		req := &pb.BatchWriteRequest{}
+		for i := 0; i < n; i++ {
+			req.Writes = append(req.Writes, &pb.BatchWriteRequest_Write{Op: pb.BatchWriteRequest_CREATE, Relationship: &pb.Relationship{
+				Uuidsource: "a", Uuidtarget: fmt.Sprint("t", i), Relationship: "friends", Delta: 1, Direction: pb.Direction_MUTUAL,
+			}})
+		}
+		return req
+	}
+
+	// Each mutual write takes two storage writes, and the key one more
+	withKey := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("idempotency-key", "k1"))
+	_, err := client.BatchWrite(withKey, mutual(max/2))
+	assert.Nil(err)
+	_, err = client.BatchWrite(context.Background(), mutual(max/2+1))
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCUpdateFieldsOnlyForUpdates(t *testing.T) {
+	assert := assert.New(t)
+	client := newTestGRPCClient(t, newTestConfig(t, nil))
+	ctx := context.Background()
+	one := int64(1)
+
+	for _, rel := range []*pb.Relationship{
+		{Operation: pb.Operation_MAX},
+		{Expected: &one},
+		{Ceiling: &one},
+		{DeleteIfNotPositive: true},
+	} {
+		rel.Uuidsource, rel.Uuidtarget, rel.Relationship, rel.Delta = "a", "b", "friends", 1
+		_, err := client.CreateRelationship(ctx, rel)
+		assert.Equal(codes.InvalidArgument, status.Code(err), "create %v", rel)
+		_, err = client.DeleteRelationship(ctx, rel)
+		assert.Equal(codes.InvalidArgument, status.Code(err), "delete %v", rel)
+		_, err = client.BatchWrite(ctx, &pb.BatchWriteRequest{Writes: []*pb.BatchWriteRequest_Write{
+			{Op: pb.BatchWriteRequest_CREATE, Relationship: rel},
+		}})
+		assert.Equal(codes.InvalidArgument, status.Code(err), "batch create %v", rel)
+	}
+	_, err := client.UpdateRelationship(ctx, &pb.Relationship{
+		Uuidsource: "a", Uuidtarget: "b", Relationship: "friends", Delta: 1, Operation: pb.Operation_MAX, Ceiling: &one,
+	})
+	assert.Nil(err)
+}
+
+func TestGRPCEndUserTokens(t *testing.T) {
+	assert := assert.New(t)
+	client := newTestGRPCClient(t, newTestConfig(t, map[string]string{
+		"AUTH_ENDUSER_ENABLED": "true",
+		"AUTH_ENDUSER_SECRET":  testSecret,
+		"AUTH_ADMIN_KEY":       testAdminKey,
+	}))
+	single := &pb.Relationship{Uuidsource: "a", Uuidtarget: "b", Relationship: "friends", Delta: 1}
+	mutual := &pb.Relationship{Uuidsource: "a", Uuidtarget: "b", Relationship: "friends", Delta: 1, Direction: pb.Direction_MUTUAL}
+	withMD := func(kv ...string) context.Context {
+		return metadata.NewOutgoingContext(context.Background(), metadata.Pairs(kv...))
+	}
+
+	_, err := client.CreateRelationship(context.Background(), single)
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+	_, err = client.CreateRelationship(withMD("authorization", "Bearer "+testToken(t, "b")), single)
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+	_, err = client.CreateRelationship(withMD("authorization", "Bearer "+testToken(t, "a")), single)
+	assert.Nil(err)
+
+	// Mutual writes also need the target user's token, and the admin key
+	// stands in for both tokens
+	_, err = client.CreateRelationship(withMD("authorization", "Bearer "+testToken(t, "a")), mutual)
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+	_, err = client.CreateRelationship(withMD(
+		"authorization", "Bearer "+testToken(t, "a"),
+		"x-tomolink-target-token", testToken(t, "c"),
+	), mutual)
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+	_, err = client.CreateRelationship(withMD(
+		"authorization", "Bearer "+testToken(t, "a"),
+		"x-tomolink-target-token", testToken(t, "b"),
+	), mutual)
+	assert.Nil(err)
+	_, err = client.CreateRelationship(withMD(
+		"authorization", "Bearer "+testToken(t, "a"),
+		"x-tomolink-admin-key", testAdminKey,
+	), mutual)
+	assert.Nil(err)
+	_, err = client.CreateRelationship(withMD("x-tomolink-admin-key", testAdminKey), mutual)
+	assert.Nil(err)
+
+	// Reads aren't checked unless 'auth.enduser.enforceReads' is on
+	_, err = client.RetrieveUserRelationships(context.Background(), &pb.RetrieveUserRelationshipsRequest{Uuidsource: "b"})
+	assert.Nil(err)
+}
+
+func TestGRPCRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	client := newTestGRPCClient(t, newTestConfig(t, map[string]string{
+		"RATELIMIT_ENABLED":     "true",
+		"RATELIMIT_USER_RATE":   "1",
+		"RATELIMIT_USER_PERIOD": "3600",
+	}))
+	req := &pb.RetrieveUserRelationshipsRequest{Uuidsource: "a"}
+
+	_, err := client.RetrieveUserRelationships(ctx, req)
+	assert.Equal(codes.NotFound, status.Code(err))
+	var header metadata.MD
+	_, err = client.RetrieveUserRelationships(ctx, req, grpc.Header(&header))
+	assert.Equal(codes.ResourceExhausted, status.Code(err))
+	assert.NotEmpty(header.Get("retry-after"))
+
+	// Other users have their own limits
+	_, err = client.RetrieveUserRelationships(ctx, &pb.RetrieveUserRelationshipsRequest{Uuidsource: "b"})
+	assert.Equal(codes.NotFound, status.Code(err))
+}
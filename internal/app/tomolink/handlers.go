@@ -16,12 +16,13 @@ package tomolink
 
 import (
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
+	"time"
 
-	"cloud.google.com/go/firestore"
 	"github.com/joeholley/tomolink/internal/config"
+	"github.com/joeholley/tomolink/internal/storage"
+	"github.com/joeholley/tomolink/internal/tracing"
 	"github.com/sirupsen/logrus"
 )
 
@@ -35,28 +36,28 @@ var (
 func RetrieveUserRelationships(ac *config.AppConfig, w http.ResponseWriter, r *http.Request) error {
 	// Retrieve request input parameters from Context & validate them
 	// This is populated by middleware.go:NormalizeRequestParams()
-	reLog := hnLog
+	reLog := tracing.Logger(r.Context(), hnLog)
 	params, err := retrieveAndValidateParameters(ac, r)
 	if err != nil {
 		reLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot process client input")
 		return err
 	}
 	if verbose, _ := ac.Cfg.BoolOr("logging.verbose", true); verbose == true {
-		reLog = params.VerboseLogger()
+		reLog = tracing.Logger(r.Context(), params.VerboseLogger())
 	}
 	reLog.Debug("request parameters retrieved")
 
-	// Get this relationship type for this user
-	doc := ac.DB.(*firestore.Client).Collection("users").Doc(params.UUIDSource)
-	docsnap, err := doc.Get(r.Context())
+	// Get all relationships for this user
+	relationships, version, err := ac.DB.(storage.Engine).GetUser(r.Context(), params.UUIDSource)
 	if err != nil {
-		reLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot process client input")
-		return fmt.Errorf("Cannot process client input: %w", err.Error())
+		reLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot retrieve relationships")
+		return storageError(err)
 	}
 
 	// Send the results back to the client
 	w.Header().Set("Content-Type", "application/json")
-	t, err := json.Marshal(docsnap.Data())
+	w.Header().Set(etagHeader, etag(version))
+	t, err := json.Marshal(relationships)
 	io.WriteString(w, string(t))
 
 	return err
@@ -68,33 +69,28 @@ func RetrieveSingleRelationship(ac *config.AppConfig, w http.ResponseWriter, r *
 
 	// Retrieve request input parameters from Context & validate them
 	// This is populated by middleware.go:NormalizeRequestParams()
-	reLog := hnLog
+	reLog := tracing.Logger(r.Context(), hnLog)
 	params, err := retrieveAndValidateParameters(ac, r)
 	if err != nil {
 		reLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot process client input")
-		return fmt.Errorf("Cannot process client input: %w", err.Error())
+		return err
 	}
 	if verbose, _ := ac.Cfg.BoolOr("logging.verbose", true); verbose == true {
-		reLog = params.VerboseLogger()
+		reLog = tracing.Logger(r.Context(), params.VerboseLogger())
 	}
 	reLog.Debug("request parameters retrieved")
 
-	// Get this relationship type for this user
-	doc := ac.DB.(*firestore.Client).Collection("users").Doc(params.UUIDSource)
-	docsnap, err := doc.Get(r.Context())
-	if err != nil {
-		reLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot process client input")
-		return fmt.Errorf("Cannot process client input: %w", err.Error())
-	}
-	dataMap, err := docsnap.DataAt(params.Relationship)
+	// Get the score of this relationship
+	score, version, err := ac.DB.(storage.Engine).GetScore(r.Context(), params.UUIDSource, params.Relationship, params.UUIDTarget)
 	if err != nil {
-		reLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot process client input")
-		return fmt.Errorf("Cannot process client input: %w", err.Error())
+		reLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot retrieve relationships")
+		return storageError(err)
 	}
 
 	// Send the results back to the client
 	w.Header().Set("Content-Type", "application/json")
-	t, err := json.Marshal(dataMap.(map[string]interface{})[params.UUIDTarget].(int64))
+	w.Header().Set(etagHeader, etag(version))
+	t, err := json.Marshal(score)
 	io.WriteString(w, string(t))
 
 	return err
@@ -106,33 +102,28 @@ func RetrieveUserRelationshipsByType(ac *config.AppConfig, w http.ResponseWriter
 
 	// Retrieve request input parameters from Context & validate them
 	// This is populated by middleware.go:NormalizeRequestParams()
-	reLog := hnLog
+	reLog := tracing.Logger(r.Context(), hnLog)
 	params, err := retrieveAndValidateParameters(ac, r)
 	if err != nil {
 		reLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot process client input")
-		return fmt.Errorf("Cannot process client input: %w", err.Error())
+		return err
 	}
 	if verbose, _ := ac.Cfg.BoolOr("logging.verbose", true); verbose == true {
-		reLog = params.VerboseLogger()
+		reLog = tracing.Logger(r.Context(), params.VerboseLogger())
 	}
 	reLog.Debug("request parameters retrieved")
 
 	// Get this relationship type for this user
-	doc := ac.DB.(*firestore.Client).Collection("users").Doc(params.UUIDSource)
-	docsnap, err := doc.Get(r.Context())
+	scores, version, err := ac.DB.(storage.Engine).GetRelationships(r.Context(), params.UUIDSource, params.Relationship)
 	if err != nil {
-		reLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot process client input")
-		return fmt.Errorf("Cannot process client input: %w", err.Error())
-	}
-	dataMap, err := docsnap.DataAt(params.Relationship)
-	if err != nil {
-		reLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot process client input")
-		return fmt.Errorf("Cannot process client input: %w", err.Error())
+		reLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot retrieve relationships")
+		return storageError(err)
 	}
 
 	// Send the results back to the client
 	w.Header().Set("Content-Type", "application/json")
-	t, err := json.Marshal(dataMap)
+	w.Header().Set(etagHeader, etag(version))
+	t, err := json.Marshal(scores)
 	io.WriteString(w, string(t))
 
 	return err
@@ -142,58 +133,38 @@ func RetrieveUserRelationshipsByType(ac *config.AppConfig, w http.ResponseWriter
 func CreateRelationship(ac *config.AppConfig, w http.ResponseWriter, r *http.Request) error {
 	// Retrieve request input parameters from Context & validate them
 	// This is populated by middleware.go:NormalizeRequestParams()
-	crLog := hnLog
+	crLog := tracing.Logger(r.Context(), hnLog)
 	params, err := retrieveAndValidateParameters(ac, r)
 	if err != nil {
 		return err
 	}
+	if err := validateNotUpdate(params); err != nil {
+		return err
+	}
 	if verbose, _ := ac.Cfg.BoolOr("logging.verbose", true); verbose == true {
-		crLog = params.VerboseLogger()
+		crLog = tracing.Logger(r.Context(), params.VerboseLogger())
 	}
 	crLog.Debug("request parameters retrieved")
 
-	// Create the relationship
-	newdata := make(map[string]map[string]interface{})
-	newdata[params.Relationship] = make(map[string]interface{})
-	newdata[params.Relationship][params.UUIDTarget] = params.Delta
-	doc := ac.DB.(*firestore.Client).Collection("users").Doc(params.UUIDSource)
-	if params.IsMultipleDirection() {
-		// Multiple relationships to create; make a batch
-		crLog.Debug("attempting bi-directional relationship batch")
-		batch := ac.DB.(*firestore.Client).Batch()
-		// First relationship is already ready to go because it's the same as a single directional relationship update
-		batch.Set(doc,
-			newdata,
-			firestore.MergeAll)
-
-		// Add a second write query for the reciprocal relationship
-		newdata[params.Relationship] = make(map[string]interface{})
-		newdata[params.Relationship][params.UUIDSource] = params.Delta
-		doc = ac.DB.(*firestore.Client).Collection("users").Doc(params.UUIDTarget)
-		batch.Set(doc,
-			newdata,
-			firestore.MergeAll)
-
-		// Send in the batch update
-		_, err := batch.Commit(r.Context())
-		if err != nil {
-			crLog.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("failure when attempting bi-directional relationship create")
-			return err
-		}
-		crLog.Info("bi-directional relationship created")
-
+	// Create the relationship (and the reciprocal relationship, if mutual)
+	writes := relationshipWrites(params, storage.Set, int64(params.Delta), relationshipCap(ac, ac.IsAdmin(r), params.Relationship))
+	writes = withVersion(writes, ifMatch(r.Header.Get(ifMatchHeader)))
+	crLog.Debug("attempting " + directionDescription(params) + " relationship create")
+	scope := idempotencyScope(ac, r.Header.Get(config.AdminKeyHeader), params.UUIDSource)
+	result, err := applyWrites(r.Context(), ac, scope, r.Header.Get(idempotencyHeader(ac)), writes)
+	if err != nil {
+		crLog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("failure when attempting " + directionDescription(params) + " relationship create")
+		return storageError(err)
+	}
+	if result.Replayed {
+		crLog.Info(directionDescription(params) + " relationship create already applied with this idempotency key")
 	} else {
-		// single relationship create
-		crLog.Debug("attempting uni-directional relationship create")
-		doc.Set(r.Context(),
-			newdata,
-			firestore.MergeAll)
-		crLog.Info("uni-directional relationship created")
+		crLog.Info(directionDescription(params) + " relationship created")
 	}
 
-	return err
+	return writeResult(w, result)
 }
 
 // DeleteRelationship ...
@@ -201,114 +172,94 @@ func DeleteRelationship(ac *config.AppConfig, w http.ResponseWriter, r *http.Req
 
 	// Retrieve request input parameters from Context & validate them
 	// This is populated by middleware.go:NormalizeRequestParams()
-	drLog := hnLog
+	drLog := tracing.Logger(r.Context(), hnLog)
 	params, err := retrieveAndValidateParameters(ac, r)
 	if err != nil {
 		return err
 	}
+	if err := validateNotUpdate(params); err != nil {
+		return err
+	}
 	if verbose, _ := ac.Cfg.BoolOr("logging.verbose", true); verbose == true {
-		drLog = params.VerboseLogger()
+		drLog = tracing.Logger(r.Context(), params.VerboseLogger())
 	}
 	drLog.Debug("request parameters retrieved")
 
-	// Delete the relationship
-	newdata := make(map[string]map[string]interface{})
-	newdata[params.Relationship] = make(map[string]interface{})
-	newdata[params.Relationship][params.UUIDTarget] = firestore.Delete
-	doc := ac.DB.(*firestore.Client).Collection("users").Doc(params.UUIDSource)
-	if params.IsMultipleDirection() {
-		// Multiple relationships to create; make a batch
-		drLog.Debug("attempting bi-directional relationship batch")
-		batch := ac.DB.(*firestore.Client).Batch()
-		// First relationship is already ready to go because it's the same as a single directional relationship update
-		batch.Set(doc,
-			newdata,
-			firestore.MergeAll)
-
-		// Add a second write query for the reciprocal relationship
-		newdata[params.Relationship] = make(map[string]interface{})
-		newdata[params.Relationship][params.UUIDSource] = firestore.Delete
-		doc = ac.DB.(*firestore.Client).Collection("users").Doc(params.UUIDTarget)
-		batch.Set(doc,
-			newdata,
-			firestore.MergeAll)
-
-		// Send in the batch update
-		_, err := batch.Commit(r.Context())
-		if err != nil {
-			drLog.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("failure when attempting bi-directional relationship delete")
-			return err
-		}
-		drLog.Info("bi-directional relationship deleted")
-
+	// Delete the relationship (and the reciprocal relationship, if mutual)
+	writes := relationshipWrites(params, storage.Delete, 0, 0)
+	writes = withVersion(writes, ifMatch(r.Header.Get(ifMatchHeader)))
+	drLog.Debug("attempting " + directionDescription(params) + " relationship delete")
+	scope := idempotencyScope(ac, r.Header.Get(config.AdminKeyHeader), params.UUIDSource)
+	result, err := applyWrites(r.Context(), ac, scope, r.Header.Get(idempotencyHeader(ac)), writes)
+	if err != nil {
+		drLog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("failure when attempting " + directionDescription(params) + " relationship delete")
+		return storageError(err)
+	}
+	if result.Replayed {
+		drLog.Info(directionDescription(params) + " relationship delete already applied with this idempotency key")
 	} else {
-		// single relationship delete
-		drLog.Debug("attempting uni-directional relationship delete")
-		doc.Set(r.Context(),
-			newdata,
-			firestore.MergeAll)
-		drLog.Info("uni-directional relationship deleted")
+		drLog.Info(directionDescription(params) + " relationship deleted")
 	}
 
-	return err
+	return writeResult(w, result)
 }
 
 //UpdateRelationship ...
 func UpdateRelationship(ac *config.AppConfig, w http.ResponseWriter, r *http.Request) error {
 	// Retrieve request input parameters from Context & validate them
 	// This is populated by middleware.go:NormalizeRequestParams()
-	urLog := hnLog
+	urLog := tracing.Logger(r.Context(), hnLog)
 	params, err := retrieveAndValidateParameters(ac, r)
 	if err != nil {
 		return err
 	}
 	if verbose, _ := ac.Cfg.BoolOr("logging.verbose", true); verbose == true {
-		urLog = params.VerboseLogger()
+		urLog = tracing.Logger(r.Context(), params.VerboseLogger())
 	}
 	urLog.Debug("request parameters retrieved")
 
-	// Delete the relationship
-	newdata := make(map[string]map[string]interface{})
-	newdata[params.Relationship] = make(map[string]interface{})
-	newdata[params.Relationship][params.UUIDTarget] = firestore.Increment(params.Delta)
-	doc := ac.DB.(*firestore.Client).Collection("users").Doc(params.UUIDSource)
-	if params.IsMultipleDirection() {
-		// Multiple relationships to create; make a batch
-		urLog.Debug("attempting bi-directional relationship batch")
-		batch := ac.DB.(*firestore.Client).Batch()
-		// First relationship is already ready to go because it's the same as a single directional relationship update
-		batch.Set(doc,
-			newdata,
-			firestore.MergeAll)
-
-		// Add a second write query for the reciprocal relationship
-		newdata[params.Relationship] = make(map[string]interface{})
-		newdata[params.Relationship][params.UUIDSource] = firestore.Increment(params.Delta)
-		doc = ac.DB.(*firestore.Client).Collection("users").Doc(params.UUIDTarget)
-		batch.Set(doc,
-			newdata,
-			firestore.MergeAll)
-
-		// Send in the batch update
-		_, err := batch.Commit(r.Context())
-		if err != nil {
-			urLog.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("failure when attempting bi-directional relationship delete")
-			return err
-		}
-		urLog.Info("bi-directional relationship deleted")
-
+	// Update the relationship (and the reciprocal relationship, if mutual)
+	writes := updateWrites(params, relationshipCap(ac, ac.IsAdmin(r), params.Relationship))
+	writes = withVersion(writes, ifMatch(r.Header.Get(ifMatchHeader)))
+	urLog.Debug("attempting " + directionDescription(params) + " relationship update")
+	scope := idempotencyScope(ac, r.Header.Get(config.AdminKeyHeader), params.UUIDSource)
+	result, err := applyWrites(r.Context(), ac, scope, r.Header.Get(idempotencyHeader(ac)), writes)
+	if err != nil {
+		urLog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("failure when attempting " + directionDescription(params) + " relationship update")
+		return storageError(err)
+	}
+	if result.Replayed {
+		urLog.Info(directionDescription(params) + " relationship update already applied with this idempotency key")
 	} else {
-		// single relationship delete
-		urLog.Debug("attempting uni-directional relationship delete")
-		doc.Set(r.Context(),
-			newdata,
-			firestore.MergeAll)
-		urLog.Info("uni-directional relationship deleted")
+		urLog.Info(directionDescription(params) + " relationship updated")
 	}
 
+	return writeResult(w, result)
+}
+
+// writeResponse is the JSON body of responses to successful writes.
+type writeResponse struct {
+	// When the storage engine committed the write, so callers can confirm
+	// it was persisted.  Left out if the storage engine can't tell.
+	WriteTime *time.Time `json:"writeTime,omitempty"`
+}
+
+// writeResult sends the result of a successful write to the client.
+func writeResult(w http.ResponseWriter, result storage.WriteResult) error {
+	if result.Replayed {
+		w.Header().Set(ReplayedHeader, "true")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	var resp writeResponse
+	if !result.Time.IsZero() {
+		resp.WriteTime = &result.Time
+	}
+	t, err := json.Marshal(resp)
+	io.WriteString(w, string(t))
+
 	return err
 }
@@ -0,0 +1,149 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tomolink
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateCompareAndSet(t *testing.T) {
+	assert := assert.New(t)
+	router := Router(newTestConfig(t, nil))
+	accept := "/v2/users/a/friends/b?operation=compareAndSet&expected=1&delta=2&direction=mutual"
+
+	// There's nothing to compare with yet
+	w := serve(router, "PATCH", accept, "")
+	assert.Equal(http.StatusConflict, w.Code)
+
+	serve(router, "PUT", "/v2/users/a/friends/b?delta=1&direction=mutual", "")
+	w = serve(router, "PATCH", accept, "")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal("2", serve(router, "GET", "/v2/users/a/friends/b", "").Body.String())
+	assert.Equal("2", serve(router, "GET", "/v2/users/b/friends/a", "").Body.String())
+
+	// The scores are no longer at the expected value
+	w = serve(router, "PATCH", accept, "")
+	assert.Equal(http.StatusConflict, w.Code)
+
+	// Both relationships of a mutual update must match, or neither is written
+	serve(router, "PUT", "/v2/users/a/friends/b?delta=1", "")
+	w = serve(router, "PATCH", accept, "")
+	assert.Equal(http.StatusConflict, w.Code)
+	assert.Equal("1", serve(router, "GET", "/v2/users/a/friends/b", "").Body.String())
+	assert.Equal("2", serve(router, "GET", "/v2/users/b/friends/a", "").Body.String())
+
+	// The v1 routes take the same fields in the body
+	w = serve(router, "POST", "/updateRelationship",
+		`{"uuidsource": "a", "uuidtarget": "b", "relationship": "friends", "operation": "compareAndSet", "expected": 1, "delta": 3}`)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal("3", serve(router, "GET", "/v2/users/a/friends/b", "").Body.String())
+}
+
+func TestUpdateMax(t *testing.T) {
+	assert := assert.New(t)
+	router := Router(newTestConfig(t, nil))
+
+	// A missing relationship is created with the delta
+	w := serve(router, "PATCH", "/v2/users/a/friends/b?operation=max&delta=5", "")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal("5", serve(router, "GET", "/v2/users/a/friends/b", "").Body.String())
+
+	serve(router, "PATCH", "/v2/users/a/friends/b?operation=max&delta=3", "")
+	assert.Equal("5", serve(router, "GET", "/v2/users/a/friends/b", "").Body.String())
+	serve(router, "PATCH", "/v2/users/a/friends/b?operation=max&delta=9", "")
+	assert.Equal("9", serve(router, "GET", "/v2/users/a/friends/b", "").Body.String())
+}
+
+func TestUpdateCeiling(t *testing.T) {
+	assert := assert.New(t)
+	router := Router(newTestConfig(t, nil))
+
+	serve(router, "PUT", "/v2/users/a/friends/b?delta=7", "")
+	w := serve(router, "PATCH", "/v2/users/a/friends/b?delta=5&ceiling=10", "")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal("10", serve(router, "GET", "/v2/users/a/friends/b", "").Body.String())
+
+	// Scores already over the ceiling aren't lowered
+	serve(router, "PUT", "/v2/users/a/friends/b?delta=12", "")
+	serve(router, "PATCH", "/v2/users/a/friends/b?delta=1&ceiling=10", "")
+	assert.Equal("12", serve(router, "GET", "/v2/users/a/friends/b", "").Body.String())
+
+	w = serve(router, "PATCH", "/v2/users/a/friends/b?delta=1&ceiling=high", "")
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
+
+func TestUpdateDeleteIfNotPositive(t *testing.T) {
+	assert := assert.New(t)
+	router := Router(newTestConfig(t, nil))
+
+	serve(router, "PUT", "/v2/users/a/friends/b?delta=2&direction=mutual", "")
+	serve(router, "PATCH", "/v2/users/a/friends/b?delta=-1&deleteIfNotPositive=true&direction=mutual", "")
+	assert.Equal("1", serve(router, "GET", "/v2/users/a/friends/b", "").Body.String())
+
+	w := serve(router, "PATCH", "/v2/users/a/friends/b?delta=-1&deleteIfNotPositive=true&direction=mutual", "")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(http.StatusNotFound, serve(router, "GET", "/v2/users/a/friends/b", "").Code)
+	assert.Equal(http.StatusNotFound, serve(router, "GET", "/v2/users/b/friends/a", "").Code)
+
+	// Without it, the score is kept at 0
+	serve(router, "PUT", "/v2/users/a/friends/b?delta=1", "")
+	serve(router, "PATCH", "/v2/users/a/friends/b?delta=-1", "")
+	assert.Equal("0", serve(router, "GET", "/v2/users/a/friends/b", "").Body.String())
+}
+
+func TestUpdateFieldsOnlyForUpdates(t *testing.T) {
+	router := Router(newTestConfig(t, nil))
+	serve(router, "PUT", "/v2/users/a/friends/b?delta=1", "")
+
+	for _, query := range []string{"operation=max", "expected=1", "ceiling=10", "deleteIfNotPositive=true"} {
+		w := serve(router, "PUT", "/v2/users/a/friends/c?delta=1&"+query, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, "create with %s", query)
+		w = serve(router, "DELETE", "/v2/users/a/friends/b?"+query, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, "delete with %s", query)
+	}
+	w := serve(router, "POST", "/createRelationship",
+		`{"uuidsource": "a", "uuidtarget": "c", "relationship": "friends", "operation": "compareAndSet", "expected": 1, "delta": 3}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Nothing was written
+	assert.Equal(t, http.StatusNotFound, serve(router, "GET", "/v2/users/a/friends/c", "").Code)
+	assert.Equal(t, "1", serve(router, "GET", "/v2/users/a/friends/b", "").Body.String())
+}
+
+func TestWriteTimes(t *testing.T) {
+	router := Router(newTestConfig(t, capConfig))
+	serve(router, "PUT", "/v2/users/a/friends/b?delta=1", "")
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		headers []string
+	}{
+		{"capped", "PUT", "/v2/users/a/friends/b?delta=2", nil},
+		{"conditional", "PATCH", "/v2/users/a/friends/b?operation=max&delta=5", nil},
+		{"if match", "PATCH", "/v2/users/a/friends/b?delta=1", []string{ifMatchHeader, "*"}},
+		{"idempotency key", "PATCH", "/v2/users/a/friends/b?delta=1", []string{"Idempotency-Key", "k1"}},
+		{"delete", "DELETE", "/v2/users/a/friends/b", nil},
+	}
+	for _, tt := range tests {
+		w := serve(router, tt.method, tt.url, "", tt.headers...)
+		assert.Equal(t, http.StatusOK, w.Code, tt.name+": "+w.Body.String())
+		assert.Contains(t, w.Body.String(), "writeTime", tt.name)
+	}
+}
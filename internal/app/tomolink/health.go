@@ -0,0 +1,179 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// health.go:
+// Liveness, readiness and status endpoints for Kubernetes and Cloud Run
+// probes.  These are served outside the router (see cmd/httpserver.go), so
+// none of the router middleware (auth, rate limiting, etc) applies to them.
+
+package tomolink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/joeholley/tomolink/internal/config"
+	"github.com/joeholley/tomolink/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// Version is the Tomolink version, set at build time using
+//   -ldflags "-X github.com/joeholley/tomolink/internal/app/tomolink.Version=<version>"
+var Version = "dev"
+
+// pingTimeout is how long the readiness check waits for the storage engine.
+const pingTimeout = 2 * time.Second
+
+var (
+	startTime = time.Now()
+	// Set to 1 once the server starts shutting down
+	draining int32
+)
+
+// Drain makes the readiness check fail from now on, so load balancers stop
+// sending new requests while the server shuts down.
+func Drain() {
+	atomic.StoreInt32(&draining, 1)
+}
+
+// check is the result of one of the readiness checks.
+type check struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// statusReport is the body of the /status response.
+type statusReport struct {
+	Ready         bool             `json:"ready"`
+	Checks        map[string]check `json:"checks"`
+	Engine        string           `json:"engine"`
+	Version       string           `json:"version"`
+	Started       time.Time        `json:"started"`
+	Uptime        string           `json:"uptime"`
+	Relationships []string         `json:"relationships"`
+}
+
+// HealthHandler returns the handler for the /healthz, /readyz and /status
+// endpoints.
+func HealthHandler(ac *config.AppConfig) http.Handler {
+	mux := http.NewServeMux()
+
+	// The process is alive as long as it can answer
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ready, _ := readiness(r.Context(), ac)
+		if !ready {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok\n")
+	})
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		ready, checks := readiness(r.Context(), ac)
+		s := statusReport{
+			Ready:         ready,
+			Checks:        checks,
+			Engine:        "none",
+			Version:       Version,
+			Started:       startTime.UTC(),
+			Uptime:        time.Since(startTime).Round(time.Second).String(),
+			Relationships: []string{},
+		}
+		if db, ok := ac.DB.(storage.Engine); ok {
+			s.Engine = db.Name()
+		}
+		for rel := range ac.Definitions().Relationships {
+			s.Relationships = append(s.Relationships, rel)
+		}
+		sort.Strings(s.Relationships)
+
+		w.Header().Set("Content-Type", "application/json")
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		t, err := json.Marshal(s)
+		if err != nil {
+			tlLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot marshal status")
+		}
+		w.Write(t)
+	})
+
+	return mux
+}
+
+// readiness runs the readiness checks: the config is loaded, the
+// relationships are parsed, the storage engine is reachable, and the server
+// isn't shutting down.
+func readiness(ctx context.Context, ac *config.AppConfig) (bool, map[string]check) {
+	checks := map[string]check{
+		"config":        result(configLoaded(ac)),
+		"relationships": result(relationshipsParsed(ac)),
+		"storage":       result(ping(ctx, ac)),
+		"serving":       result(serving()),
+	}
+	for _, c := range checks {
+		if !c.OK {
+			return false, checks
+		}
+	}
+	return true, checks
+}
+
+func result(err error) check {
+	if err != nil {
+		return check{Error: err.Error()}
+	}
+	return check{OK: true}
+}
+
+func configLoaded(ac *config.AppConfig) error {
+	if ac.Cfg == nil {
+		return errors.New("config not loaded")
+	}
+	return nil
+}
+
+func relationshipsParsed(ac *config.AppConfig) error {
+	if len(ac.Definitions().Relationships) == 0 {
+		return errors.New("no relationships configured")
+	}
+	return nil
+}
+
+func ping(ctx context.Context, ac *config.AppConfig) error {
+	db, ok := ac.DB.(storage.Engine)
+	if !ok {
+		return errors.New("no storage engine connected")
+	}
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	return db.Ping(ctx)
+}
+
+func serving() error {
+	if atomic.LoadInt32(&draining) == 1 {
+		return errors.New("shutting down")
+	}
+	return nil
+}
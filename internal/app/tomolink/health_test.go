@@ -0,0 +1,109 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tomolink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/joeholley/tomolink/internal/config"
+	"github.com/joeholley/tomolink/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// unreachable is a storage engine that can't be reached.
+type unreachable struct {
+	storage.Engine
+}
+
+func (unreachable) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestHealthz(t *testing.T) {
+	ac := newTestConfig(t, nil)
+	// The process is alive even when it can't reach the database
+	ac.DB = unreachable{ac.DB.(storage.Engine)}
+	w := serve(HealthHandler(ac), "GET", "/healthz", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok\n", w.Body.String())
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, ac *config.AppConfig)
+		code  int
+	}{
+		{"ready", func(t *testing.T, ac *config.AppConfig) {}, http.StatusOK},
+		{"storage unreachable", func(t *testing.T, ac *config.AppConfig) {
+			ac.DB = unreachable{ac.DB.(storage.Engine)}
+		}, http.StatusServiceUnavailable},
+		{"no storage engine", func(t *testing.T, ac *config.AppConfig) {
+			ac.DB = nil
+		}, http.StatusServiceUnavailable},
+		{"draining", func(t *testing.T, ac *config.AppConfig) {
+			Drain()
+			t.Cleanup(func() { atomic.StoreInt32(&draining, 0) })
+		}, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ac := newTestConfig(t, nil)
+			tt.setup(t, ac)
+			w := serve(HealthHandler(ac), "GET", "/readyz", "")
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	assert := assert.New(t)
+	ac := newTestConfig(t, nil)
+	h := HealthHandler(ac)
+
+	w := serve(h, "GET", "/status", "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	var s statusReport
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &s))
+	assert.True(s.Ready)
+	assert.Equal(map[string]check{
+		"config":        {OK: true},
+		"relationships": {OK: true},
+		"storage":       {OK: true},
+		"serving":       {OK: true},
+	}, s.Checks)
+	assert.Equal("memory", s.Engine)
+	assert.Equal(Version, s.Version)
+	assert.Equal(startTime.UTC().Unix(), s.Started.Unix())
+	assert.NotEmpty(s.Uptime)
+	assert.Equal([]string{"blocks", "followers", "friends", "influencers"}, s.Relationships)
+
+	// The failing check is reported, with the status still served
+	ac.DB = unreachable{ac.DB.(storage.Engine)}
+	w = serve(h, "GET", "/status", "")
+	assert.Equal(http.StatusServiceUnavailable, w.Code)
+	s = statusReport{}
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &s))
+	assert.False(s.Ready)
+	assert.Equal(check{Error: "connection refused"}, s.Checks["storage"])
+	assert.True(s.Checks["config"].OK)
+	assert.Equal("memory", s.Engine)
+}
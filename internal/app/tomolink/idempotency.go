@@ -0,0 +1,81 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// idempotency.go:
+// Idempotency keys for writes.  Clients that retry writes after a timeout send
+// the same key with every attempt, and the storage engine only applies the
+// writes once per key, so retried updates don't add the delta twice.
+
+package tomolink
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/joeholley/tomolink/internal/config"
+	"github.com/joeholley/tomolink/internal/storage"
+)
+
+// maxIdempotencyKeyLength is the longest idempotency key accepted.
+const maxIdempotencyKeyLength = 255
+
+// errIdempotencyKeyTooLong is returned for keys longer than
+// maxIdempotencyKeyLength.
+var errIdempotencyKeyTooLong = fmt.Errorf("idempotency key is longer than %d characters", maxIdempotencyKeyLength)
+
+// ReplayedHeader is set to "true" on responses to requests that weren't
+// applied because an earlier request with the same idempotency key was.
+const ReplayedHeader = "Idempotent-Replayed"
+
+// idempotencyHeader returns the name of the header carrying idempotency keys,
+// or "" if idempotency keys are turned off.
+func idempotencyHeader(ac *config.AppConfig) string {
+	if enabled, _ := ac.Cfg.BoolOr("idempotency.enabled", true); enabled == false {
+		return ""
+	}
+	header, _ := ac.Cfg.StringOr("idempotency.header", "Idempotency-Key")
+	return header
+}
+
+// idempotencyScope returns whose idempotency keys a request's key is one of,
+// so clients can't replay or block each other's writes by sending the same
+// key.  Requests with the admin key come from trusted services, which share a
+// scope.  Other requests act for their source user, who is the authenticated
+// caller when end-user tokens are checked, or the user in the path when not.
+func idempotencyScope(ac *config.AppConfig, adminKey, user string) string {
+	if ac.IsAdminKey(adminKey) {
+		return "admin"
+	}
+	return "user:" + user
+}
+
+// applyWrites makes the writes.  If key isn't empty, the writes are only
+// applied the first time they are made with that key in the scope, and later
+// calls return a replayed result without applying them again.
+func applyWrites(ctx context.Context, ac *config.AppConfig, scope, key string, writes []storage.Write) (storage.WriteResult, error) {
+	db := ac.DB.(storage.Engine)
+	if key == "" {
+		return db.Write(ctx, writes...)
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return storage.WriteResult{}, errIdempotencyKeyTooLong
+	}
+	window, _ := ac.Cfg.IntOr("idempotency.window", 86400)
+	// The scope's length is included, so no scope and key can be mistaken
+	// for another
+	scoped := strconv.Itoa(len(scope)) + ":" + scope + ":" + key
+	return db.WriteOnce(ctx, scoped, time.Duration(window)*time.Second, writes...)
+}
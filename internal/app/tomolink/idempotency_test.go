@@ -0,0 +1,108 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tomolink
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/joeholley/tomolink/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const testUpdate = `{"uuidsource": "a", "uuidtarget": "b", "relationship": "friends", "delta": 2}`
+
+func TestIdempotencyReplay(t *testing.T) {
+	assert := assert.New(t)
+	router := Router(newTestConfig(t, nil))
+
+	w := serve(router, "POST", "/updateRelationship", testUpdate, "Idempotency-Key", "k1")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Empty(w.Header().Get(ReplayedHeader))
+	first := w.Body.String()
+
+	// The retry gets the original response, and isn't applied again
+	w = serve(router, "POST", "/updateRelationship", testUpdate, "Idempotency-Key", "k1")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal("true", w.Header().Get(ReplayedHeader))
+	assert.Equal(first, w.Body.String())
+	w = serve(router, "GET", "/users/a/friends/b", "")
+	assert.Equal("2", w.Body.String())
+
+	// The same write through the v2 routes is the same request
+	w = serve(router, "PATCH", "/v2/users/a/friends/b?delta=2", "", "Idempotency-Key", "k1")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal("true", w.Header().Get(ReplayedHeader))
+
+	// Without a key, every request is applied
+	serve(router, "POST", "/updateRelationship", testUpdate)
+	w = serve(router, "GET", "/users/a/friends/b", "")
+	assert.Equal("4", w.Body.String())
+}
+
+func TestIdempotencyKeyReuse(t *testing.T) {
+	assert := assert.New(t)
+	router := Router(newTestConfig(t, nil))
+
+	w := serve(router, "POST", "/updateRelationship", testUpdate, "Idempotency-Key", "k1")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	// A different write with the same key is refused, and not applied
+	w = serve(router, "POST", "/updateRelationship",
+		`{"uuidsource": "a", "uuidtarget": "b", "relationship": "friends", "delta": 5}`, "Idempotency-Key", "k1")
+	assert.Equal(http.StatusUnprocessableEntity, w.Code)
+	w = serve(router, "GET", "/users/a/friends/b", "")
+	assert.Equal("2", w.Body.String())
+
+	w = serve(router, "POST", "/updateRelationship", testUpdate,
+		"Idempotency-Key", strings.Repeat("k", maxIdempotencyKeyLength+1))
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
+
+func TestIdempotencyDisabled(t *testing.T) {
+	assert := assert.New(t)
+	router := Router(newTestConfig(t, map[string]string{"IDEMPOTENCY_ENABLED": "false"}))
+
+	for i := 0; i < 2; i++ {
+		w := serve(router, "POST", "/updateRelationship", testUpdate, "Idempotency-Key", "k1")
+		assert.Equal(http.StatusOK, w.Code, w.Body.String())
+		assert.Empty(w.Header().Get(ReplayedHeader))
+	}
+	w := serve(router, "GET", "/users/a/friends/b", "")
+	assert.Equal("4", w.Body.String())
+}
+
+func TestIdempotencyKeyScope(t *testing.T) {
+	assert := assert.New(t)
+	router := Router(newTestConfig(t, map[string]string{"AUTH_ADMIN_KEY": testAdminKey}))
+
+	w := serve(router, "PATCH", "/v2/users/a/friends/b?delta=2", "", "Idempotency-Key", "k1")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	// Another user's key is theirs, even if it's the same key
+	w = serve(router, "PATCH", "/v2/users/c/friends/b?delta=3", "", "Idempotency-Key", "k1")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Empty(w.Header().Get(ReplayedHeader))
+	assert.Equal("3", serve(router, "GET", "/v2/users/c/friends/b", "").Body.String())
+
+	// Trusted services have their own keys
+	w = serve(router, "PATCH", "/v2/users/a/friends/b?delta=2", "", "Idempotency-Key", "k1", config.AdminKeyHeader, testAdminKey)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Empty(w.Header().Get(ReplayedHeader))
+	assert.Equal("4", serve(router, "GET", "/v2/users/a/friends/b", "").Body.String())
+	w = serve(router, "PATCH", "/v2/users/a/friends/b?delta=2", "", "Idempotency-Key", "k1", config.AdminKeyHeader, testAdminKey)
+	assert.Equal("true", w.Header().Get(ReplayedHeader))
+}
@@ -0,0 +1,229 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// jobs.go:
+// Background jobs started by requests, such as deletions and merges, whose
+// status is kept by the storage engine so every instance can report on them.
+
+package tomolink
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/joeholley/tomolink/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// Statuses of a job.
+const (
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+const (
+	// jobRetention is how long the status of a job is kept after it last
+	// changed.
+	jobRetention = 24 * time.Hour
+	// jobHeartbeat is how often a running job records that it's still
+	// running.
+	jobHeartbeat = 10 * time.Second
+	// jobLease is how long a running job can go without recording a
+	// heartbeat before it's treated as stopped, and can be started again.
+	jobLease = 3 * jobHeartbeat
+	// jobSaveTimeout bounds recording a job's final status, which happens
+	// after the server has started shutting down if the job was stopped.
+	jobSaveTimeout = 5 * time.Second
+)
+
+// jobInterrupted is the error recorded for a job stopped by its instance
+// stopping.
+const jobInterrupted = "interrupted, as the instance running it stopped"
+
+// jobState is the part of a job's status common to every kind of job.
+type jobState struct {
+	Status   string     `json:"status"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	// Identifies the run of the job, so a run that has been replaced stops
+	Runner string `json:"runner,omitempty"`
+	// When the run last recorded it was still running
+	Heartbeat time.Time `json:"heartbeat"`
+}
+
+// job is the status of a kind of job, which embeds jobState.
+type job interface {
+	state() *jobState
+}
+
+func (s *jobState) state() *jobState {
+	return s
+}
+
+// jobs holds the context every job runs with, which StopJobs cancels, and
+// tracks the running jobs so StopJobs can wait for them.
+var jobs struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func init() {
+	jobs.ctx, jobs.cancel = context.WithCancel(context.Background())
+}
+
+// StopJobs stops the jobs running in this instance, and waits until they have
+// recorded their status, or ctx is done.  Stopped jobs are recorded as
+// failed; sending the request that started one again resumes it.
+func StopJobs(ctx context.Context) error {
+	jobs.cancel()
+	stopped := make(chan struct{})
+	go func() {
+		jobs.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// loadJob reads the status of the job stored under id into j, and returns its
+// version.  A running job that has stopped recording heartbeats, because the
+// instance running it stopped, is reported as failed.
+func loadJob(ctx context.Context, db storage.Engine, id string, j job) (string, error) {
+	stored, version, err := db.GetJob(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(stored.Status, j); err != nil {
+		return "", err
+	}
+	s := j.state()
+	if s.Status == jobRunning && time.Since(s.Heartbeat) > jobLease {
+		finished := s.Heartbeat
+		s.Status, s.Finished = jobFailed, &finished
+		s.Error = jobInterrupted
+	}
+	return version, nil
+}
+
+// saveJob stores the status of the job under id, as long as its version is
+// still ifVersion.
+func saveJob(ctx context.Context, db storage.Engine, id string, j job, ifVersion string) error {
+	status, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return db.SetJob(ctx, id, storage.Job{Status: status, Expires: time.Now().Add(jobRetention)}, ifVersion)
+}
+
+// claimJob records j as a new run of the job stored under id, as long as the
+// stored job's version is still ifVersion.
+func claimJob(ctx context.Context, db storage.Engine, id string, j job, ifVersion string) error {
+	runner := make([]byte, 8)
+	if _, err := rand.Read(runner); err != nil {
+		return err
+	}
+	s := j.state()
+	s.Status, s.Started, s.Heartbeat = jobRunning, time.Now(), time.Now()
+	s.Runner = hex.EncodeToString(runner)
+	return saveJob(ctx, db, id, j, ifVersion)
+}
+
+// runJob runs work in the background for the job claimed as j, which it owns
+// from then on.  Once work returns, record is called to copy its results into
+// j, and j's final status is stored.  work's context is cancelled if the
+// server stops (see StopJobs), or another run of the job replaces this one.
+func runJob(db storage.Engine, id string, j job, work func(ctx context.Context) error, record func(), log *logrus.Entry) {
+	// The job outlives the request, but not the server
+	ctx, cancel := context.WithCancel(jobs.ctx)
+	done := make(chan error, 1)
+	jobs.wg.Add(1)
+	go func() {
+		done <- work(ctx)
+	}()
+	go func() {
+		defer jobs.wg.Done()
+		defer cancel()
+		ticker := time.NewTicker(jobHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.state().Heartbeat = time.Now()
+				if err := saveRun(ctx, db, id, j); err != nil {
+					log.WithFields(logrus.Fields{"error": err.Error()}).Warn("Cannot record job heartbeat")
+					if err == errReplaced {
+						cancel()
+					}
+				}
+			case err := <-done:
+				finishJob(db, id, j, err, record, log)
+				return
+			}
+		}
+	}()
+}
+
+// errReplaced is returned by saveRun when another run of the job has replaced
+// this one.
+var errReplaced = errors.New("job was started again elsewhere")
+
+// saveRun stores the status of the job under id, as long as the stored job is
+// still the same run.
+func saveRun(ctx context.Context, db storage.Engine, id string, j job) error {
+	var stored jobState
+	version, err := loadJob(ctx, db, id, &stored)
+	if err != nil {
+		return err
+	}
+	if stored.Runner != j.state().Runner {
+		return errReplaced
+	}
+	return saveJob(ctx, db, id, j, version)
+}
+
+// finishJob records the final status of the job, given the error work
+// returned.
+func finishJob(db storage.Engine, id string, j job, err error, record func(), log *logrus.Entry) {
+	record()
+	s := j.state()
+	now := time.Now()
+	s.Finished, s.Heartbeat = &now, now
+	s.Status = jobDone
+	if err != nil {
+		s.Status, s.Error = jobFailed, err.Error()
+		if jobs.ctx.Err() != nil {
+			s.Error = jobInterrupted
+		}
+		log.WithFields(logrus.Fields{"error": err.Error()}).Error("Job failed")
+	}
+
+	// The server may be stopping, so the final status can't wait on jobs.ctx
+	ctx, cancel := context.WithTimeout(context.Background(), jobSaveTimeout)
+	defer cancel()
+	if err := saveRun(ctx, db, id, j); err != nil {
+		log.WithFields(logrus.Fields{"error": err.Error()}).Warn("Cannot record job status")
+	}
+}
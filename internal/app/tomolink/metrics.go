@@ -0,0 +1,124 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// metrics.go:
+// Middleware and a gRPC interceptor that record the Prometheus request metrics
+// for every request handled by the router or the gRPC server.
+
+package tomolink
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joeholley/tomolink/internal/config"
+	"github.com/joeholley/tomolink/internal/metrics"
+	"github.com/joeholley/tomolink/internal/models"
+	"github.com/joeholley/tomolink/pkg/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// statusRecorder wraps a ResponseWriter to remember the status code sent to
+// the client.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.code = code
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+// requestMetrics returns a middleware function that records the count and
+// latency of every request, labelled with the name of the route, the status
+// code, and the relationship type and direction of the request.  It goes
+// before normalizeRequestParams, so requests refused while their parameters
+// are parsed are counted too; normalizeRequestParams hands the parameters back
+// through the "metricsParams" context value.
+func requestMetrics(ac *config.AppConfig) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sr := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+			var params *models.Relationship
+			next.ServeHTTP(sr, r.WithContext(context.WithValue(r.Context(), "metricsParams", &params)))
+
+			route := routeName(r)
+			relationship, direction := "none", "none"
+			if params != nil {
+				relationship, direction = relationshipLabels(ac, r.Method != http.MethodGet && r.Method != http.MethodHead, params)
+			}
+			metrics.ObserveRequest(route, r.Method, sr.code, relationship, direction, time.Since(start))
+		})
+	}
+}
+
+// relationshipLabels returns the relationship and direction metric labels for
+// the request.  Relationship types that aren't defined are all recorded
+// as 'other', so clients can't create an unbounded number of label values.
+func relationshipLabels(ac *config.AppConfig, write bool, params *models.Relationship) (string, string) {
+	relationship := "none"
+	if params.Relationship != "" {
+		relationship = "other"
+		if _, ok := ac.Definitions().Relationships[params.Relationship]; ok {
+			relationship = params.Relationship
+		}
+	}
+
+	// Reads don't have a direction
+	direction := "none"
+	if write {
+		direction = "single"
+		if params.IsMultipleDirection() {
+			direction = "mutual"
+		}
+	}
+	return relationship, direction
+}
+
+// grpcMetrics returns a gRPC interceptor that records the count and latency of
+// every gRPC request, labelled with the gRPC method, the status code, and the
+// relationship type and direction of the request.  Batch requests have a
+// relationship type and direction of 'none'.
+func grpcMetrics(ac *config.AppConfig) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		params, write := &models.Relationship{}, false
+		switch r := req.(type) {
+		case *pb.Relationship:
+			params.Relationship, params.Direction, write = r.Relationship, direction(r.Direction), true
+		case interface{ GetRelationship() string }:
+			params.Relationship = r.GetRelationship()
+		}
+		params.Relationship = ac.RelationshipName(params.Relationship)
+		relationship, direction := relationshipLabels(ac, write, params)
+		metrics.ObserveGRPCRequest(path.Base(info.FullMethod), status.Code(err).String(), relationship, direction, time.Since(start))
+		return resp, err
+	}
+}
+
+// routeName returns the name of the mux route matching the request.
+func routeName(r *http.Request) string {
+	if cr := mux.CurrentRoute(r); cr != nil && cr.GetName() != "" {
+		return cr.GetName()
+	}
+	return "unknown"
+}
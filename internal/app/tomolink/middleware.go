@@ -20,11 +20,15 @@ package tomolink
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/joeholley/tomolink/internal/config"
 	"github.com/joeholley/tomolink/internal/json"
 	"github.com/joeholley/tomolink/internal/models"
+	"github.com/joeholley/tomolink/internal/tracing"
 	"github.com/sirupsen/logrus"
 )
 
@@ -35,11 +39,12 @@ import (
 //     the request context object, multiple middleware functions and the HTTP
 //     handlers can all access these parameters, instead of only the first
 //     function that reads the request body.
-//  2) This allows us to take input parameters both through the URI and the
-//     request body.
+//  2) This allows us to take input parameters both through the URI (path and
+//     query string) and the request body.
 func normalizeRequestParams(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var urlParams, jsonBodyParams models.Relationship
+		_, span := tracing.Start(r.Context(), "normalizeRequestParams")
 
 		//  parse the mux.vars into params
 		if len(mux.Vars(r)) > 0 {
@@ -54,12 +59,44 @@ func normalizeRequestParams(next http.Handler) http.Handler {
 			tlLog.Debug("parsed request URI into request context")
 		}
 
+		// Parse the query parameters into params.  These are mostly used by
+		// the v2 routes, which don't send a request body for deletes.
+		query := r.URL.Query()
+		urlParams.Direction = query.Get("direction")
+		urlParams.Operation = query.Get("operation")
+		urlParams.DeleteIfNotPositive = query.Get("deleteIfNotPositive") == "true"
+		for _, q := range []struct {
+			name string
+			set  func(int64)
+		}{
+			{"delta", func(v int64) { urlParams.Delta = int(v) }},
+			{"expected", func(v int64) { urlParams.Expected = &v }},
+			{"ceiling", func(v int64) { urlParams.Ceiling = &v }},
+		} {
+			value := query.Get(q.name)
+			if value == "" {
+				continue
+			}
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				tlLog.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Warn("unable to parse " + q.name + " query parameter")
+				span.RecordError(err)
+				span.End()
+				http.Error(w, fmt.Sprintf("invalid %s '%s'", q.name, value), http.StatusBadRequest)
+				return
+			}
+			q.set(v)
+		}
+
 		// Decode the JSON body
 		err := json.DecodeJSONBody(w, r, &jsonBodyParams)
 		if err != nil && err.Error() != "Request body is empty" {
 			tlLog.WithFields(logrus.Fields{
 				"error": err.Error(),
 			}).Error("unable to parse request JSON body into params")
+			span.RecordError(err)
 		}
 
 		// Merge the parameters specified in the JSON body and the URL.
@@ -72,9 +109,14 @@ func normalizeRequestParams(next http.Handler) http.Handler {
 			tlLog.WithFields(logrus.Fields{
 				"error": err.Error(),
 			}).Error("unable to parse params, do you have conflicting values?")
+			span.RecordError(err)
+		}
+		span.End()
+		if mp, ok := r.Context().Value("metricsParams").(**models.Relationship); ok {
+			*mp = params
 		}
 		ctx := context.WithValue(r.Context(), "params", params)
-		tlLog.WithFields(logrus.Fields{
+		tracing.Logger(ctx, tlLog).WithFields(logrus.Fields{
 			"url":   urlParams,
 			"json":  jsonBodyParams,
 			"rel":   params.Relationship,
@@ -85,3 +127,28 @@ func normalizeRequestParams(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// relationshipNames is a middleware function that replaces the old name of a
+// renamed relationship type in the request parameters with its current name,
+// so the rest of the request only deals with current names, and refuses
+// writes to deprecated relationship types.
+func relationshipNames(ac *config.AppConfig) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			params, ok := r.Context().Value("params").(*models.Relationship)
+			if !ok || params == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			params.Relationship = ac.RelationshipName(params.Relationship)
+			if r.Method != http.MethodGet && r.Method != http.MethodHead && ac.Definitions().DeprecatedRelationships[params.Relationship] {
+				tracing.Logger(r.Context(), tlLog).WithFields(logrus.Fields{
+					"relationship": params.Relationship,
+				}).Warn("refused write to deprecated relationship type")
+				http.Error(w, fmt.Sprintf("relationship type '%s' is deprecated, and can't be written", params.Relationship), http.StatusBadRequest)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,528 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// openapi.go:
+// OpenAPI 3 description of the HTTP API.  It is generated from the routes
+// registered on the router (see router.go), so it always matches the API
+// actually being served, including the relationship names allowed in strict
+// mode.
+
+package tomolink
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/joeholley/tomolink/internal/config"
+	"github.com/joeholley/tomolink/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// openAPIVersion is the version of the OpenAPI specification the document
+// follows.
+const openAPIVersion = "3.0.3"
+
+type openAPIDoc struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       openAPIInfo                     `json:"info"`
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components components                      `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+type operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary"`
+	Parameters  []parameter         `json:"parameters,omitempty"`
+	RequestBody *requestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]response `json:"responses"`
+}
+
+type parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Headers     map[string]header    `json:"headers,omitempty"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type header struct {
+	Description string  `json:"description"`
+	Schema      *schema `json:"schema"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type components struct {
+	Schemas map[string]*schema `json:"schemas"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+}
+
+// operationInfo describes what a named route does, as it can't be worked out
+// from the route itself.
+type operationInfo struct {
+	summary string
+	// Whether the request has a Relationship JSON body
+	body bool
+	// Whether the write can be refused by a relationship cap
+	capped bool
+	// Whether the write is an update that can be a compare-and-set
+	conditional bool
+	// The names of the query parameters the route takes
+	query []string
+	// The schema of a successful response, or nil if it has no body
+	result *schema
+	// The status code of a successful response, if not 200
+	status int
+	// Whether a successful response has no ETag, as it isn't about the
+	// user's relationships
+	unversioned bool
+	// Why the request can get a 409, other than caps and compare-and-set
+	conflict string
+}
+
+// operations holds the description of each named route.  Routes without an
+// entry here are left out of the OpenAPI document.
+var operations = map[string]operationInfo{
+	"retrieveSingleRelationship": {
+		summary: "Retrieve the score of one relationship from the source user to the target user",
+		result:  &schema{Type: "integer", Format: "int64"},
+	},
+	"retrieveUserRelationshipsByType": {
+		summary: "Retrieve all of the source user's relationships of one type",
+		result:  ref("Scores"),
+	},
+	"retrieveUserRelationships": {
+		summary: "Retrieve all of the source user's relationships",
+		result:  ref("Relationships"),
+	},
+	"createRelationship": {
+		summary: "Create a relationship, or reset the score of an existing relationship",
+		body:    true,
+		capped:  true,
+	},
+	"updateRelationship": {
+		summary:     "Add the delta to the score of a relationship, or make a conditional update",
+		body:        true,
+		capped:      true,
+		conditional: true,
+	},
+	"deleteRelationship": {
+		summary: "Delete a relationship",
+		body:    true,
+	},
+	"v2SetRelationship": {
+		summary: "Set the score of a relationship, creating it if it doesn't exist",
+		capped:  true,
+		query:   []string{"direction", "delta"},
+	},
+	"v2IncrementRelationship": {
+		summary:     "Add the delta to the score of a relationship, or make a conditional update",
+		capped:      true,
+		conditional: true,
+		query:       []string{"direction", "delta", "operation", "expected", "ceiling", "deleteIfNotPositive"},
+	},
+	"v2DeleteRelationship": {
+		summary: "Delete a relationship",
+		query:   []string{"direction"},
+	},
+	"v2RetrieveSingleRelationship": {
+		summary: "Retrieve the score of one relationship from the source user to the target user",
+		result:  &schema{Type: "integer", Format: "int64"},
+	},
+	"v2RetrieveUserRelationshipsByType": {
+		summary: "Retrieve all of the source user's relationships of one type",
+		result:  ref("Scores"),
+	},
+	"v2RetrieveUserRelationships": {
+		summary: "Retrieve all of the source user's relationships",
+		result:  ref("Relationships"),
+	},
+	"deleteUser":               deleteUserInfo,
+	"v2DeleteUser":             deleteUserInfo,
+	"retrieveDeletionStatus":   deletionStatusInfo,
+	"v2RetrieveDeletionStatus": deletionStatusInfo,
+	"exportUser":               exportUserInfo,
+	"v2ExportUser":             exportUserInfo,
+	"mergeUser":                mergeUserInfo,
+	"v2MergeUser":              mergeUserInfo,
+	"retrieveMergeStatus":      mergeStatusInfo,
+	"v2RetrieveMergeStatus":    mergeStatusInfo,
+}
+
+// The user-level routes are the same under /users and /v2/users.
+var (
+	deleteUserInfo = operationInfo{
+		summary:     "Delete the user and all their relationships, and start removing every other user's relationships with them",
+		result:      ref("Deletion"),
+		status:      http.StatusAccepted,
+		unversioned: true,
+	}
+	deletionStatusInfo = operationInfo{
+		summary:     "Retrieve the status of the user's deletion, if it was started by the instance handling the request",
+		result:      ref("Deletion"),
+		unversioned: true,
+	}
+	exportUserInfo = operationInfo{
+		summary: "Retrieve everything stored about the user",
+		result:  ref("UserExport"),
+	}
+	mergeUserInfo = operationInfo{
+		summary:     "Start moving all of the source user's relationships, and every other user's relationships with them, to the target user, and then delete the source user",
+		result:      ref("Merge"),
+		status:      http.StatusAccepted,
+		unversioned: true,
+		conflict:    "The source user is already being merged into a different user",
+	}
+	mergeStatusInfo = operationInfo{
+		summary:     "Retrieve the status of merging the source user into the target user, if it was started by the instance handling the request",
+		result:      ref("Merge"),
+		unversioned: true,
+	}
+)
+
+// OpenAPIHandler returns the handler for the /openapi.json endpoint,
+// describing the routes registered on router.  The document is built for
+// each request, as relationship types can be defined while the server is
+// running.
+func OpenAPIHandler(ac *config.AppConfig, router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, err := json.Marshal(openAPISpec(ac, router))
+		if err != nil {
+			tlLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot marshal OpenAPI document")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	})
+}
+
+// openAPISpec builds the OpenAPI document for the routes registered on
+// router.
+func openAPISpec(ac *config.AppConfig, router *mux.Router) openAPIDoc {
+	doc := openAPIDoc{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title:       "Tomolink",
+			Description: "Stores and retrieves scored relationships between users.",
+			Version:     Version,
+		},
+		Paths: map[string]map[string]operation{},
+		Components: components{Schemas: map[string]*schema{
+			"Relationship":  relationshipSchema(ac),
+			"Scores":        {Type: "object", Description: "Relationship scores, keyed by target user ID", AdditionalProperties: &schema{Type: "integer", Format: "int64"}},
+			"Relationships": {Type: "object", Description: "Relationship scores, keyed by relationship type", AdditionalProperties: ref("Scores")},
+			"Deletion": {Type: "object", Properties: map[string]*schema{
+				"user":      {Type: "string"},
+				"status":    {Type: "string", Enum: []string{jobRunning, jobDone, jobFailed}},
+				"started":   {Type: "string", Format: "date-time"},
+				"finished":  {Type: "string", Format: "date-time"},
+				"heartbeat": {Type: "string", Format: "date-time", Description: "When the instance running the deletion last recorded it was still running"},
+				"removed":   {Type: "integer", Description: "How many of other users' relationships with the user were removed"},
+				"error":     {Type: "string", Description: "Why the deletion failed; send the delete again to retry it"},
+			}},
+			"Merge": {Type: "object", Properties: map[string]*schema{
+				"user":       {Type: "string"},
+				"into":       {Type: "string"},
+				"status":     {Type: "string", Enum: []string{jobRunning, jobDone, jobFailed}},
+				"started":    {Type: "string", Format: "date-time"},
+				"finished":   {Type: "string", Format: "date-time"},
+				"heartbeat":  {Type: "string", Format: "date-time", Description: "When the instance running the merge last recorded it was still running"},
+				"moved":      {Type: "integer", Description: "How many of the user's relationships were moved to the other user"},
+				"redirected": {Type: "integer", Description: "How many of other users' relationships with the user were moved to the other user"},
+				"dropped":    {Type: "integer", Description: "How many relationships between the two users were dropped"},
+				"error":      {Type: "string", Description: "Why the merge failed; send the merge again to retry it"},
+			}},
+			"UserExport": {Type: "object", Properties: map[string]*schema{
+				"user":          {Type: "string"},
+				"version":       {Type: "string", Description: "The version of the user's relationships"},
+				"relationships": ref("Relationships"),
+			}},
+			"WriteResult": {Type: "object", Properties: map[string]*schema{
+				"writeTime": {Type: "string", Format: "date-time", Description: "When the storage engine committed the write; for replayed writes, when it was first committed. Left out if the storage engine can't tell"},
+			}},
+		}},
+	}
+
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		info, ok := operations[route.GetName()]
+		if !ok {
+			return nil
+		}
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		path, vars := openAPIPath(tpl)
+		op := operation{
+			OperationID: route.GetName(),
+			Summary:     info.summary,
+			Responses:   errorResponses(ac, info),
+		}
+		for _, v := range vars {
+			op.Parameters = append(op.Parameters, parameter{
+				Name:     v,
+				In:       "path",
+				Required: true,
+				Schema:   varSchema(ac, v),
+			})
+		}
+		for _, q := range info.query {
+			op.Parameters = append(op.Parameters, parameter{
+				Name:   q,
+				In:     "query",
+				Schema: relationshipSchema(ac).Properties[q],
+			})
+		}
+		if info.result == nil {
+			op.Parameters = append(op.Parameters, parameter{
+				Name:   ifMatchHeader,
+				In:     "header",
+				Schema: &schema{Type: "string", Description: "Only make the write if the source user's relationships are still at this version (ETag)"},
+			})
+		}
+		if name := idempotencyHeader(ac); name != "" && info.result == nil {
+			op.Parameters = append(op.Parameters, parameter{
+				Name:   name,
+				In:     "header",
+				Schema: &schema{Type: "string", Description: "Apply the write only once per key, even if the request is retried"},
+			})
+		}
+		if info.body {
+			op.RequestBody = &requestBody{
+				Required: true,
+				Content:  map[string]mediaType{"application/json": {Schema: ref("Relationship")}},
+			}
+		}
+		op.Responses["200"] = response{
+			Description: "OK",
+			Content:     map[string]mediaType{"application/json": {Schema: ref("WriteResult")}},
+		}
+		if info.result != nil {
+			ok := response{
+				Description: "OK",
+				Headers: map[string]header{etagHeader: {
+					Description: "The version of the source user's relationships, to send in the If-Match header of writes",
+					Schema:      &schema{Type: "string"},
+				}},
+				Content: map[string]mediaType{"application/json": {Schema: info.result}},
+			}
+			if info.unversioned {
+				ok.Headers = nil
+			}
+			if info.status != 0 {
+				delete(op.Responses, "200")
+				ok.Description = http.StatusText(info.status)
+				op.Responses[strconv.Itoa(info.status)] = ok
+			} else {
+				op.Responses["200"] = ok
+			}
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]operation{}
+		}
+		for _, m := range methods {
+			doc.Paths[path][strings.ToLower(m)] = op
+		}
+		return nil
+	})
+
+	return doc
+}
+
+// openAPIPath converts a gorilla mux path template to an OpenAPI path by
+// removing any regular expressions from the variables, so for example
+// '/users/{UUIDSource}/{relationship:(?:friends|blocks)}' becomes
+// '/users/{UUIDSource}/{relationship}'.  It also returns the names of the
+// variables, in order.
+func openAPIPath(tpl string) (string, []string) {
+	var path strings.Builder
+	var vars []string
+	for {
+		start := strings.Index(tpl, "{")
+		if start < 0 {
+			path.WriteString(tpl)
+			return path.String(), vars
+		}
+		path.WriteString(tpl[:start])
+
+		// Find the matching closing brace, as the regex can contain braces
+		depth, end := 0, start
+		for ; end < len(tpl); end++ {
+			if tpl[end] == '{' {
+				depth++
+			} else if tpl[end] == '}' {
+				if depth--; depth == 0 {
+					break
+				}
+			}
+		}
+		name := strings.SplitN(tpl[start+1:end], ":", 2)[0]
+		vars = append(vars, name)
+		path.WriteString("{" + name + "}")
+		if end >= len(tpl) {
+			return path.String(), vars
+		}
+		tpl = tpl[end+1:]
+	}
+}
+
+// varSchema returns the schema of a path variable.
+func varSchema(ac *config.AppConfig, name string) *schema {
+	if name == "relationship" {
+		return &schema{Type: "string", Enum: strictRelationships(ac)}
+	}
+	return &schema{Type: "string"}
+}
+
+// relationshipSchema builds the schema of the relationship JSON body from the
+// fields of models.Relationship.
+func relationshipSchema(ac *config.AppConfig) *schema {
+	s := &schema{Type: "object", Properties: map[string]*schema{}}
+	t := reflect.TypeOf(models.Relationship{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		p := &schema{Type: "string"}
+		kind := f.Type.Kind()
+		if kind == reflect.Ptr {
+			kind = f.Type.Elem().Kind()
+		}
+		switch kind {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			p = &schema{Type: "integer"}
+		case reflect.Bool:
+			p = &schema{Type: "boolean"}
+		}
+		switch name {
+		case "direction":
+			p.Enum = []string{"single", "mutual"}
+			p.Description = "Defaults to 'single'; 'mutual' also writes the reciprocal relationship from the target to the source user"
+		case "relationship":
+			p.Enum = strictRelationships(ac)
+		case "delta":
+			p.Description = "The score to set on create, or the amount to add on update"
+		case "operation":
+			p.Enum = []string{models.Increment, models.CompareAndSet, models.Max}
+			p.Description = "How an update changes the score; defaults to 'increment'"
+		case "expected":
+			p.Description = "The score a compareAndSet update requires the relationship to have"
+		case "ceiling":
+			p.Description = "The highest score an increment update can leave"
+		case "deleteIfNotPositive":
+			p.Description = "Delete the relationship if an update leaves its score at or below 0"
+		}
+		s.Properties[name] = p
+	}
+	return s
+}
+
+// strictRelationships returns the allowed relationship names when strict
+// relationships are on, or nil when any name is allowed.
+func strictRelationships(ac *config.AppConfig) []string {
+	if strict, _ := ac.Cfg.BoolOr("relationships.strict", true); strict == false {
+		return nil
+	}
+	names := []string{}
+	for rel := range ac.Definitions().Relationships {
+		names = append(names, rel)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// errorResponses returns the error responses a route can return, depending
+// on the features turned on in the config.
+func errorResponses(ac *config.AppConfig, info operationInfo) map[string]response {
+	responses := map[string]response{
+		"400": {Description: "Invalid parameters, or a relationship type that isn't allowed"},
+		"500": {Description: "Internal error"},
+		"503": {Description: "The database is failing, and Tomolink has stopped calling it for a while; try again later"},
+	}
+	if info.result != nil && info.status == 0 {
+		responses["404"] = response{Description: "The user or relationship doesn't exist"}
+	}
+	if info.result == nil {
+		responses["412"] = response{Description: "The source user's relationships aren't at the version in the If-Match header"}
+	}
+	if info.result == nil && idempotencyHeader(ac) != "" {
+		responses["422"] = response{Description: "The idempotency key was already used for a different request"}
+	}
+	conflicts := []string{}
+	if info.capped && len(ac.Definitions().RelationshipCaps) > 0 {
+		conflicts = append(conflicts, "The write would take a user past a relationship cap")
+	}
+	if info.conditional {
+		conflicts = append(conflicts, "The score isn't the one a compareAndSet update expected")
+	}
+	if info.conflict != "" {
+		conflicts = append(conflicts, info.conflict)
+	}
+	if len(conflicts) > 0 {
+		responses["409"] = response{Description: strings.Join(conflicts, "; or ")}
+	}
+	if enduser, _ := ac.Cfg.BoolOr("auth.enduser.enabled", false); enduser == true {
+		responses["401"] = response{Description: "The end-user token is missing or invalid"}
+		responses["403"] = response{Description: "The end-user token was issued to a different user"}
+	}
+	if limit, _ := ac.Cfg.BoolOr("ratelimit.enabled", false); limit == true {
+		responses["429"] = response{Description: "Over a rate limit; retry after the number of seconds in the Retry-After header"}
+	}
+	return responses
+}
+
+func ref(name string) *schema {
+	return &schema{Ref: "#/components/schemas/" + name}
+}
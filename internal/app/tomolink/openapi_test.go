@@ -0,0 +1,70 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tomolink
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// Every named route on the router must be in the OpenAPI document, so a new
+// route without an entry in operations fails here.
+func TestOpenAPICoversRoutes(t *testing.T) {
+	assert := assert.New(t)
+	ac := newTestConfig(t, nil)
+	router := Router(ac)
+
+	w := serve(OpenAPIHandler(ac, router), "GET", "/openapi.json", "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	var doc openAPIDoc
+	if !assert.Nil(json.Unmarshal(w.Body.Bytes(), &doc)) {
+		return
+	}
+	assert.Equal(openAPIVersion, doc.OpenAPI)
+
+	named := 0
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		name := route.GetName()
+		if name == "" {
+			return nil
+		}
+		named++
+		tpl, err := route.GetPathTemplate()
+		if !assert.Nil(err, name) {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if !assert.Nil(err, name) {
+			return nil
+		}
+		path, _ := openAPIPath(tpl)
+		for _, m := range methods {
+			op, ok := doc.Paths[path][strings.ToLower(m)]
+			if assert.True(ok, "route %s (%s %s) is missing from the OpenAPI document", name, m, path) {
+				assert.Equal(name, op.OperationID)
+				assert.NotEmpty(op.Summary, name)
+			}
+		}
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal(len(operations), named, "every operation is served by a named route")
+}
@@ -0,0 +1,271 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// ratelimit.go:
+// Reads the rate limits out of the application config, and provides the
+// middleware that enforces them.
+
+package tomolink
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joeholley/tomolink/internal/auth"
+	"github.com/joeholley/tomolink/internal/config"
+	"github.com/joeholley/tomolink/internal/models"
+	"github.com/joeholley/tomolink/internal/ratelimit"
+	"github.com/sirupsen/logrus"
+)
+
+const relLimitsPrefix = "ratelimit.relationships."
+
+// rateLimits holds the limits configured in the 'ratelimit' section of the
+// config, and the limiter used to enforce them.
+type rateLimits struct {
+	ac           *config.AppConfig
+	limiter      ratelimit.Limiter
+	callerHeader string
+	// Number of proxies in front of Tomolink that append to X-Forwarded-For
+	trustedProxies int
+	// End-user token header and secret, if end-user tokens are on
+	tokenHeader string
+	tokenSecret string
+	caller      ratelimit.Limit
+	user        ratelimit.Limit
+	// Limits on writes, per source user, for each relationship type
+	relationships map[string]ratelimit.Limit
+}
+
+var (
+	sharedMu     sync.Mutex
+	sharedLimits = map[*config.AppConfig]*rateLimits{}
+)
+
+// sharedRateLimits returns the rate limits for the config, setting them up the
+// first time.  The HTTP and gRPC APIs share them, so requests to either API
+// count against the same limits.
+func sharedRateLimits(ac *config.AppConfig) (*rateLimits, error) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if rl, ok := sharedLimits[ac]; ok {
+		return rl, nil
+	}
+	rl, err := newRateLimits(ac)
+	if err != nil {
+		return nil, err
+	}
+	sharedLimits[ac] = rl
+	return rl, nil
+}
+
+// newRateLimits reads the rate limiting config and sets up the configured
+// limiter backend.
+func newRateLimits(ac *config.AppConfig) (*rateLimits, error) {
+	var err error
+	rl := &rateLimits{ac: ac, relationships: map[string]ratelimit.Limit{}}
+
+	rl.callerHeader, err = ac.Cfg.StringOr("ratelimit.caller.header", "")
+	if err != nil {
+		return nil, err
+	}
+	if rl.trustedProxies, err = ac.Cfg.IntOr("ratelimit.caller.trustedProxies", 0); err != nil {
+		return nil, err
+	}
+	if enduser, _ := ac.Cfg.BoolOr("auth.enduser.enabled", false); enduser {
+		rl.tokenHeader, _ = ac.Cfg.StringOr("auth.enduser.header", "Authorization")
+		rl.tokenSecret, _ = ac.Cfg.StringOr("auth.enduser.secret", "")
+	}
+	if rl.caller, err = readLimit(ac, "ratelimit.caller"); err != nil {
+		return nil, err
+	}
+	if rl.user, err = readLimit(ac, "ratelimit.user"); err != nil {
+		return nil, err
+	}
+
+	// Relationship types with limits are found by looking for their 'rate' key
+	settings, err := ac.Cfg.Settings()
+	if err != nil {
+		return nil, err
+	}
+	for key := range settings {
+		if !strings.HasPrefix(key, relLimitsPrefix) || !strings.HasSuffix(key, ".rate") {
+			continue
+		}
+		rel := strings.TrimSuffix(strings.TrimPrefix(key, relLimitsPrefix), ".rate")
+		if rl.relationships[rel], err = readLimit(ac, relLimitsPrefix+rel); err != nil {
+			return nil, err
+		}
+	}
+
+	backend, err := ac.Cfg.StringOr("ratelimit.backend", "memory")
+	if err != nil {
+		return nil, err
+	}
+	switch backend {
+	case "memory":
+		rl.limiter = ratelimit.NewMemory()
+	case "redis":
+		address, err := ac.Cfg.StringOr("ratelimit.redis.address", "localhost:6379")
+		if err != nil {
+			return nil, err
+		}
+		password, err := ac.Cfg.StringOr("ratelimit.redis.password", "")
+		if err != nil {
+			return nil, err
+		}
+		prefix, err := ac.Cfg.StringOr("ratelimit.redis.prefix", "tomolink:ratelimit:")
+		if err != nil {
+			return nil, err
+		}
+		rl.limiter = ratelimit.NewRedis(address, password, prefix)
+	default:
+		return nil, fmt.Errorf("unknown rate limiting backend '%s'", backend)
+	}
+
+	return rl, nil
+}
+
+// readLimit reads the rate, burst, and period (in seconds) values under the
+// provided config key.
+func readLimit(ac *config.AppConfig, key string) (ratelimit.Limit, error) {
+	rate, err := ac.Cfg.IntOr(key+".rate", 0)
+	if err != nil {
+		return ratelimit.Limit{}, err
+	}
+	burst, err := ac.Cfg.IntOr(key+".burst", 0)
+	if err != nil {
+		return ratelimit.Limit{}, err
+	}
+	period, err := ac.Cfg.IntOr(key+".period", 1)
+	if err != nil {
+		return ratelimit.Limit{}, err
+	}
+	return ratelimit.Limit{
+		Rate:   rate,
+		Burst:  burst,
+		Period: time.Duration(period) * time.Second,
+	}, nil
+}
+
+// middleware takes a token from every bucket that applies to the request,
+// and refuses the request with HTTP 429, taking no tokens, if any of them are
+// empty.  If the
+// limiter backend is unavailable, requests are let through.
+func (rl *rateLimits) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.Context().Value("params").(*models.Relationship)
+		caller := rl.callerID(r.Header.Get, r.Header.Values("X-Forwarded-For"), r.RemoteAddr)
+		write := r.Method != http.MethodGet && r.Method != http.MethodHead
+		if retry, ok := rl.allow(r.Context(), caller, params, write); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow takes a token from every bucket that applies to a request from the
+// caller, and reports if the request can go ahead.  If not, no tokens are
+// taken, and it also returns how many seconds to wait before trying again.
+func (rl *rateLimits) allow(ctx context.Context, caller string, params *models.Relationship, write bool) (int, bool) {
+	var buckets []ratelimit.Bucket
+	if rl.caller.Enabled() {
+		buckets = append(buckets, ratelimit.Bucket{Key: "caller:" + caller, Limit: rl.caller})
+	}
+	if rl.user.Enabled() && params.UUIDSource != "" {
+		buckets = append(buckets, ratelimit.Bucket{Key: "user:" + params.UUIDSource, Limit: rl.user})
+	}
+	if write {
+		if limit, ok := rl.relationships[params.Relationship]; ok && limit.Enabled() {
+			buckets = append(buckets, ratelimit.Bucket{Key: "rel:" + params.Relationship + ":" + params.UUIDSource, Limit: limit})
+		}
+	}
+	if len(buckets) == 0 {
+		return 0, true
+	}
+
+	res, err := rl.limiter.AllowAll(ctx, buckets...)
+	if err != nil {
+		tlLog.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"caller": caller,
+		}).Warn("rate limiter unavailable, letting request through")
+		return 0, true
+	}
+	if !res.Allowed {
+		retry := int(math.Ceil(res.RetryAfter.Seconds()))
+		if retry < 1 {
+			retry = 1
+		}
+		tlLog.WithFields(logrus.Fields{
+			"caller":     caller,
+			"user":       params.UUIDSource,
+			"retryAfter": retry,
+		}).Info("request rate limited")
+		return retry, false
+	}
+	return 0, true
+}
+
+// callerID identifies the client calling Tomolink.  Anything a client sends
+// can be made up, so only authenticated identities are used: the subject of a
+// valid end-user token, or the configured caller header on requests that carry
+// the admin key.  Other clients are identified by their IP address, which is
+// taken from the X-Forwarded-For entry added by the furthest trusted proxy, or
+// the address of the connection if there are no trusted proxies.
+func (rl *rateLimits) callerID(header func(string) string, forwarded []string, remoteAddr string) string {
+	if rl.tokenSecret != "" {
+		if token := auth.FromHeader(header(rl.tokenHeader)); token != "" {
+			if claims, err := auth.Verify(token, []byte(rl.tokenSecret), time.Now()); err == nil {
+				return "sub:" + claims.Subject
+			}
+		}
+	}
+	if rl.callerHeader != "" && rl.ac.IsAdminKey(header(config.AdminKeyHeader)) {
+		if id := header(rl.callerHeader); id != "" {
+			return "header:" + id
+		}
+	}
+
+	// Each proxy appends the address it received the request from, so the
+	// entries left of the ones added by the trusted proxies could be anything.
+	if rl.trustedProxies > 0 {
+		var hops []string
+		for _, f := range forwarded {
+			for _, hop := range strings.Split(f, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		if len(hops) >= rl.trustedProxies {
+			if ip := net.ParseIP(hops[len(hops)-rl.trustedProxies]); ip != nil {
+				return "ip:" + ip.String()
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return "ip:" + remoteAddr
+	}
+	return "ip:" + host
+}
@@ -18,6 +18,8 @@ package tomolink
 
 import (
 	"fmt"
+	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/joeholley/tomolink/internal/config"
@@ -33,8 +35,7 @@ const relEnd = "}"
 
 var (
 	// Logrus structured logging setup
-	tlLog    = logrus.WithFields(logrus.Fields{})
-	relRegex = ""
+	tlLog = logrus.WithFields(logrus.Fields{})
 )
 
 // Router instantiates a new gorilla mux router and adds the various routes and
@@ -44,71 +45,99 @@ var (
 // You can find the code for the handlers in handlers.go
 func Router(ac *config.AppConfig) *mux.Router {
 	r := mux.NewRouter()
+	// Tracing has to come first, so the span for the request is the parent of
+	// the spans created by the other middleware.
+	if trace, _ := ac.Cfg.BoolOr("tracing.enabled", false); trace == true {
+		tlLog.Info("Tracing turned ON")
+		r.Use(traceRequests)
+	}
+	// Record request metrics before any of the other middleware, so requests
+	// they refuse are counted too.
+	r.Use(requestMetrics(ac))
 	r.Use(normalizeRequestParams)
+	// Requests using the old names of renamed relationship types are handled
+	// as requests for the current names from here on.
+	r.Use(relationshipNames(ac))
+
+	// Check if end-user tokens are required, in which case a request can only
+	// act on relationships where the token subject is the source user.
+	if enduser, _ := ac.Cfg.BoolOr("auth.enduser.enabled", false); enduser == true {
+		tlLog.Info("End-user tokens turned ON, requests must carry a token for the source user")
+		if secret, _ := ac.Cfg.StringOr("auth.enduser.secret", ""); secret == "" {
+			tlLog.Warn("'auth.enduser.secret' is empty; all requests requiring a token will be refused")
+		}
+		r.Use(ac.EndUserMW)
+	}
+
+	// Check if rate limiting is enabled.  This goes after the end-user token
+	// check so unauthenticated requests can't use up a user's limits.
+	if limit, _ := ac.Cfg.BoolOr("ratelimit.enabled", false); limit == true {
+		rl, err := sharedRateLimits(ac)
+		if err != nil {
+			tlLog.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatal("Cannot configure rate limiting")
+		}
+		tlLog.Info("Rate limiting turned ON")
+		r.Use(rl.middleware)
+	}
+	// The user-level routes have to come before the relationship subrouters,
+	// so '/users/<uuidsource>/export' isn't taken for a relationship type.
+	addUserRoutes(ac, r)
+
 	users := r.PathPrefix("/users").Subrouter()
+	v2Users := r.PathPrefix("/v2/users").Subrouter()
 	// This subrouter looks useless since there's not a path prefix, but it is
 	// necessary to allow us to put middleware only on routes that need
 	// relationship checking, not all routes.
 	relationships := r.PathPrefix("").Subrouter()
 
 	// Check if strict relationships are enabled, in which case we will only
-	// process a relationship request if this relationship is defined in the
-	// application config
+	// process a relationship request if this relationship is defined, either
+	// in the application config or at runtime (see DefinitionsHandler).  As
+	// relationship types can be defined while the server is running, the
+	// routes match any name, and the middleware checks it against the
+	// definitions in effect.
 	if strict, _ := ac.Cfg.BoolOr("relationships.strict", true); strict == true {
-		tlLog.Info("Strict relationships turned ON, parsing config to generate routes")
-		// Start a regex unnamed group
-		relRegex = ":(?:"
-		for rel := range ac.Relationships {
-			relRegex = relRegex + rel + "|"
-		}
-		// Remove last trailing vertical pipe and finish the group
-		relRegex = relRegex[:len(relRegex)-1] + ")"
+		tlLog.Info("Strict relationships turned ON, only defined relationship types are accepted")
 
 		// Middleware for strict relationship validation.  It only goes on the
 		// subrouters, so that routes attached directly to the router 'r'
 		// doen't get strict relationship checking
 		users.Use(ac.StrictMW)
+		v2Users.Use(ac.StrictMW)
 		relationships.Use(ac.StrictMW)
 
 	}
-	// This is accomplished by creating a regex that matches using an unnamed
-	// group.  In practice, this concatinates three values: relStart + relRegex
-	// + relEnd.  relStart and relEnd provide us with a gorilla mux URL
-	// variable named "relationship", and relRegex can contain the regex to
-	// match only the defined relationship names. For example, if the config sets
-	// strict relationships on and then defines four relationships called 'a',
-	// 'b', 'c', and 'd', the final concatinated value should look something
-	// like this:
-	//   {relationship:(?:a|b|c|d)}
-	// for more information on the format supported by gorilla mux, see the
-	// section starting with "Groups can be used inside patterns, as long
-	// as they are non-capturing" on
-	// https://godoc.org/github.com/gorilla/mux
-	relationship := relStart + relRegex + relEnd
+	// relStart and relEnd provide us with a gorilla mux URL variable named
+	// "relationship".
+	relationship := relStart + relEnd
 
 	// Relationship types that support retreiving a single relationship 'score'
 	// GET endpoint for one score of this relationship type
-	name := "todo"
+	name := "retrieveSingleRelationship"
 	route := "/" + source + "/" + relationship + "/" + target
 	users.Handle(route, Handler{ac, RetrieveSingleRelationship}).
 		Methods("GET").
-		Name("TODO")
+		Name(name)
 	tlLog.WithFields(logrus.Fields{
 		"route": fmt.Sprintf("/users%s", route),
 		"name":  name,
 	}).Info("Added route")
 
 	// GET endpoint for all of one relationship type of a given user
+	name = "retrieveUserRelationshipsByType"
 	route = "/" + source + "/" + relationship
 	users.Handle(route, Handler{ac, RetrieveUserRelationshipsByType}).
 		Methods("GET").
-		Name("TODO2")
+		Name(name)
 	tlLog.WithFields(logrus.Fields{
 		"route": fmt.Sprintf("/users%s", route),
 		"name":  name,
 	}).Info("Added route")
 
 	// GET endpoint for all relationships of a given user
+	name = "retrieveUserRelationships"
 	route = "/" + usersPath + "/" + source
 	// This one goes on the main router rather than a subrouter, as the subrouters
 	// use middleware to check for the validity of the relationship type passed
@@ -116,46 +145,160 @@ func Router(ac *config.AppConfig) *mux.Router {
 	// type at all!
 	r.Handle(route, Handler{ac, RetrieveUserRelationships}).
 		Methods("GET").
-		Name("TODO3")
+		Name(name)
 	tlLog.WithFields(logrus.Fields{
-		"route": fmt.Sprintf("/users%s", route),
+		"route": route,
 		"name":  name,
 	}).Info("Added route")
 
 	tlLog.Info("All configured relationship endpoints created")
 
 	// POST endpoint to create relationship (or multiple mutual relationships)
+	name = "createRelationship"
 	route = "/createRelationship"
 	relationships.Handle(route, Handler{ac, CreateRelationship}).
 		Headers("Content-Type", "application/json").
 		Methods("POST").
-		Name("TODO4")
+		Name(name)
 	tlLog.WithFields(logrus.Fields{
 		"route": route,
 		"name":  name,
 	}).Info("Added route")
 
 	// POST endpoint to update relationship (or multiple mutual relationships)
+	name = "updateRelationship"
 	route = "/updateRelationship"
 	relationships.Handle(route, Handler{ac, UpdateRelationship}).
 		Headers("Content-Type", "application/json").
 		Methods("POST").
-		Name("TODO5")
+		Name(name)
 	tlLog.WithFields(logrus.Fields{
 		"route": route,
 		"name":  name,
 	}).Info("Added route")
 
 	// DELETE endpoint to delete relationship (or multiple mutual relationships)
+	name = "deleteRelationship"
 	route = "/deleteRelationship"
 	relationships.Handle(route, Handler{ac, DeleteRelationship}).
 		Headers("Content-Type", "application/json").
 		Methods("DELETE").
-		Name("TODO6")
+		Name(name)
 	tlLog.WithFields(logrus.Fields{
 		"route": route,
 		"name":  name,
 	}).Info("Added route")
 
+	addV2Routes(ac, r, v2Users, relationship)
+
 	return r
 }
+
+// addV2Routes adds the v2 API routes, where each relationship is a resource
+// at /v2/users/<uuidsource>/<relationship>/<uuidtarget>, and the HTTP method
+// says what to do with it.  The direction (and optionally the delta) are
+// passed as query parameters, so DELETE requests don't need a body.  The v2
+// routes use the same handlers as the v1 routes; only the way the parameters
+// are passed is different.
+func addV2Routes(ac *config.AppConfig, r, v2Users *mux.Router, relationship string) {
+	// PUT endpoint to set the score of a relationship, creating it if needed
+	name := "v2SetRelationship"
+	route := "/" + source + "/" + relationship + "/" + target
+	v2Users.Handle(route, Handler{ac, CreateRelationship}).
+		Methods("PUT").
+		Name(name)
+	tlLog.WithFields(logrus.Fields{
+		"route": fmt.Sprintf("/v2/users%s", route),
+		"name":  name,
+	}).Info("Added route")
+
+	// PATCH endpoint to add the delta to the score of a relationship
+	name = "v2IncrementRelationship"
+	v2Users.Handle(route, Handler{ac, UpdateRelationship}).
+		Methods("PATCH").
+		Name(name)
+	tlLog.WithFields(logrus.Fields{
+		"route": fmt.Sprintf("/v2/users%s", route),
+		"name":  name,
+	}).Info("Added route")
+
+	// DELETE endpoint to delete a relationship
+	name = "v2DeleteRelationship"
+	v2Users.Handle(route, Handler{ac, DeleteRelationship}).
+		Methods("DELETE").
+		Name(name)
+	tlLog.WithFields(logrus.Fields{
+		"route": fmt.Sprintf("/v2/users%s", route),
+		"name":  name,
+	}).Info("Added route")
+
+	// GET endpoint for one score of this relationship type
+	name = "v2RetrieveSingleRelationship"
+	v2Users.Handle(route, Handler{ac, RetrieveSingleRelationship}).
+		Methods("GET").
+		Name(name)
+	tlLog.WithFields(logrus.Fields{
+		"route": fmt.Sprintf("/v2/users%s", route),
+		"name":  name,
+	}).Info("Added route")
+
+	// GET endpoint for all of one relationship type of a given user
+	name = "v2RetrieveUserRelationshipsByType"
+	route = "/" + source + "/" + relationship
+	v2Users.Handle(route, Handler{ac, RetrieveUserRelationshipsByType}).
+		Methods("GET").
+		Name(name)
+	tlLog.WithFields(logrus.Fields{
+		"route": fmt.Sprintf("/v2/users%s", route),
+		"name":  name,
+	}).Info("Added route")
+
+	// GET endpoint for all relationships of a given user.  As in v1, this
+	// goes on the main router so it doesn't get the strict relationship check.
+	name = "v2RetrieveUserRelationships"
+	route = "/v2/" + usersPath + "/" + source
+	r.Handle(route, Handler{ac, RetrieveUserRelationships}).
+		Methods("GET").
+		Name(name)
+	tlLog.WithFields(logrus.Fields{
+		"route": route,
+		"name":  name,
+	}).Info("Added route")
+}
+
+// addUserRoutes adds the routes acting on everything stored about a user, for
+// account deletion, data export and account merge requests, under both
+// /users and /v2/users.  They go on the main router, as they don't act on a
+// relationship type.
+func addUserRoutes(ac *config.AppConfig, r *mux.Router) {
+	for _, prefix := range []string{"", "/v2"} {
+		base := prefix + "/" + usersPath + "/" + source
+		for _, route := range []struct {
+			name, path, method string
+			h                  func(*config.AppConfig, http.ResponseWriter, *http.Request) error
+		}{
+			// DELETE endpoint to delete a user and every relationship with them
+			{"deleteUser", base, "DELETE", DeleteUser},
+			// GET endpoint for the status of a user's deletion
+			{"retrieveDeletionStatus", base + "/deletion", "GET", DeletionStatus},
+			// GET endpoint for everything stored about a user
+			{"exportUser", base + "/export", "GET", ExportUser},
+			// POST endpoint to merge a user into another
+			{"mergeUser", base + "/mergeInto/" + target, "POST", MergeUser},
+			// GET endpoint for the status of a merge
+			{"retrieveMergeStatus", base + "/mergeInto/" + target, "GET", MergeStatus},
+		} {
+			name := route.name
+			if prefix != "" {
+				name = "v2" + strings.ToUpper(name[:1]) + name[1:]
+			}
+			r.Handle(route.path, Handler{ac, route.h}).
+				Methods(route.method).
+				Name(name)
+			tlLog.WithFields(logrus.Fields{
+				"route": route.path,
+				"name":  name,
+			}).Info("Added route")
+		}
+	}
+}
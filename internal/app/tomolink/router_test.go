@@ -0,0 +1,72 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tomolink
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestV2Routes(t *testing.T) {
+	assert := assert.New(t)
+	router := Router(newTestConfig(t, nil))
+
+	w := serve(router, "PUT", "/v2/users/a/friends/b?direction=mutual&delta=3", "")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(w.Body.String(), "writeTime")
+	w = serve(router, "PATCH", "/v2/users/a/friends/b?delta=2", "")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	w = serve(router, "GET", "/v2/users/a/friends/b", "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("5", w.Body.String())
+	assert.NotEmpty(w.Header().Get(etagHeader))
+	w = serve(router, "GET", "/v2/users/b/friends", "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"a": 3}`, w.Body.String())
+	w = serve(router, "GET", "/v2/users/a", "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"friends": {"b": 5}}`, w.Body.String())
+
+	// Deletes don't need a body
+	w = serve(router, "DELETE", "/v2/users/a/friends/b?direction=mutual", "")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	w = serve(router, "GET", "/v2/users/b/friends/a", "")
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	w = serve(router, "PATCH", "/v2/users/a/friends/b?delta=lots", "")
+	assert.Equal(http.StatusBadRequest, w.Code)
+	w = serve(router, "PUT", "/v2/users/a/friends/b?direction=sideways", "")
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
+
+func TestV2RoutesShareV1Data(t *testing.T) {
+	assert := assert.New(t)
+	router := Router(newTestConfig(t, nil))
+
+	w := serve(router, "POST", "/createRelationship",
+		`{"uuidsource": "a", "uuidtarget": "b", "relationship": "blocks", "delta": 1}`)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	w = serve(router, "GET", "/v2/users/a/blocks/b", "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("1", w.Body.String())
+
+	w = serve(router, "DELETE", "/v2/users/a/blocks/b", "")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	w = serve(router, "GET", "/users/a/blocks/b", "")
+	assert.Equal(http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tomolink
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joeholley/tomolink/internal/auth"
+	"github.com/joeholley/tomolink/internal/config"
+)
+
+const (
+	testSecret   = "test-secret"
+	testAdminKey = "test-admin-key"
+)
+
+// newTestConfig loads the default config from tomolink_defaults.yaml, with the
+// overrides in env (as env vars, e.g. AUTH_ENDUSER_ENABLED), and stores
+// relationships in memory.
+func newTestConfig(t *testing.T, env map[string]string) *config.AppConfig {
+	t.Helper()
+	t.Setenv("DEV", "false")
+	t.Setenv("DATABASE_ENGINE", "memory")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+
+	ac := &config.AppConfig{}
+	if err := ac.Load("tomolink"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.Connect("memory"); err != nil {
+		t.Fatal(err)
+	}
+	return ac
+}
+
+// testToken returns an end-user token for the user, signed with testSecret.
+func testToken(t *testing.T, user string) string {
+	t.Helper()
+	token, err := auth.Sign(auth.Claims{Subject: user, ExpiresAt: time.Now().Add(time.Hour).Unix()}, []byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// serve sends a request with the body and headers (in name, value pairs) to
+// the handler, and returns the response.
+func serve(h http.Handler, method, url, body string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
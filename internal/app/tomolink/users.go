@@ -0,0 +1,274 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// users.go:
+// Handlers acting on everything stored about a user, for account deletion,
+// data export and account merge requests.
+
+package tomolink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/joeholley/tomolink/internal/admin"
+	"github.com/joeholley/tomolink/internal/config"
+	"github.com/joeholley/tomolink/internal/models"
+	"github.com/joeholley/tomolink/internal/storage"
+	"github.com/joeholley/tomolink/internal/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+// deletion is the status of removing a deleted user's relationships from
+// every other user, which runs in the background as it reads every user.
+type deletion struct {
+	User string `json:"user"`
+	jobState
+	// How many of other users' relationships with the user were removed
+	Removed int `json:"removed"`
+}
+
+// deletionJob is the ID the status of the user's deletion is stored under.
+func deletionJob(user string) string {
+	return "deletion/" + user
+}
+
+// userParam returns the user a user-level request acts on.
+func userParam(r *http.Request) (string, error) {
+	params := r.Context().Value("params").(*models.Relationship)
+	if params.UUIDSource == "" {
+		return "", StatusError{Code: http.StatusBadRequest, Err: errors.New("missing uuidsource")}
+	}
+	return params.UUIDSource, nil
+}
+
+// DeleteUser deletes the user and all their relationships, and then removes
+// every other user's relationships with them in the background.  The status
+// of the background removal is returned, with a 202, and can be checked with
+// DeletionStatus.  If the user is already being deleted, the running
+// deletion's status is returned instead of starting another.
+func DeleteUser(ac *config.AppConfig, w http.ResponseWriter, r *http.Request) error {
+	duLog := tracing.Logger(r.Context(), hnLog)
+	user, err := userParam(r)
+	if err != nil {
+		return err
+	}
+	duLog = duLog.WithFields(logrus.Fields{"uuidsource": user})
+
+	db := ac.DB.(storage.Engine)
+	if err := db.DeleteUser(r.Context(), user); err != nil {
+		duLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot delete user")
+		return storageError(err)
+	}
+
+	id := deletionJob(user)
+	var d deletion
+	version, err := loadJob(r.Context(), db, id, &d)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		duLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot read deletion status")
+		return storageError(err)
+	}
+	if err != nil || d.Status != jobRunning {
+		d = deletion{User: user}
+		err = claimJob(r.Context(), db, id, &d, version)
+		switch {
+		case err == nil:
+			run := d
+			var removed int
+			runJob(db, id, &run, func(ctx context.Context) (err error) {
+				removed, err = admin.DeleteInbound(ctx, db, user)
+				return err
+			}, func() { run.Removed = removed }, duLog)
+		case errors.Is(err, storage.ErrVersionMismatch):
+			// Another request started the removal first
+			_, err = loadJob(r.Context(), db, id, &d)
+		}
+		if err != nil {
+			duLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot start removing other users' relationships with deleted user")
+			return storageError(err)
+		}
+	}
+	d.Runner = ""
+
+	duLog.Info("User deleted, removing other users' relationships with them")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", r.URL.Path+"/deletion")
+	w.WriteHeader(http.StatusAccepted)
+	return json.NewEncoder(w).Encode(d)
+}
+
+// DeletionStatus returns the status of the latest deletion of the user.
+func DeletionStatus(ac *config.AppConfig, w http.ResponseWriter, r *http.Request) error {
+	user, err := userParam(r)
+	if err != nil {
+		return err
+	}
+	var status deletion
+	_, err = loadJob(r.Context(), ac.DB.(storage.Engine), deletionJob(user), &status)
+	if errors.Is(err, storage.ErrNotFound) {
+		return StatusError{Code: http.StatusNotFound, Err: errors.New("no deletion of this user has been started")}
+	}
+	if err != nil {
+		return storageError(err)
+	}
+	status.Runner = ""
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(status)
+}
+
+// userExport is everything stored about a user.
+type userExport struct {
+	User          string                    `json:"user"`
+	Version       string                    `json:"version"`
+	Relationships map[string]storage.Scores `json:"relationships"`
+}
+
+// ExportUser returns everything stored about the user: all their
+// relationships, as stored in their own data.  Other users' relationships
+// with them are part of the other users' data, so they aren't included.
+func ExportUser(ac *config.AppConfig, w http.ResponseWriter, r *http.Request) error {
+	euLog := tracing.Logger(r.Context(), hnLog)
+	user, err := userParam(r)
+	if err != nil {
+		return err
+	}
+	relationships, version, err := ac.DB.(storage.Engine).GetUser(r.Context(), user)
+	if err != nil {
+		euLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot export user")
+		return storageError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(etagHeader, etag(version))
+	return json.NewEncoder(w).Encode(userExport{User: user, Version: version, Relationships: relationships})
+}
+
+// merge is the status of merging a user into another, which runs in the
+// background as it reads every user.
+type merge struct {
+	User string `json:"user"`
+	Into string `json:"into"`
+	jobState
+	// How many of the user's relationships were moved to the other user
+	Moved int `json:"moved"`
+	// How many of other users' relationships with the user were moved to the
+	// other user
+	Redirected int `json:"redirected"`
+	// How many relationships between the two users were dropped
+	Dropped int `json:"dropped"`
+}
+
+// mergeJob is the ID the status of the user's merge is stored under.
+func mergeJob(user string) string {
+	return "merge/" + user
+}
+
+// mergeParams returns the users a merge request acts on.
+func mergeParams(r *http.Request) (string, string, error) {
+	params := r.Context().Value("params").(*models.Relationship)
+	if params.UUIDSource == "" || params.UUIDTarget == "" {
+		return "", "", StatusError{Code: http.StatusBadRequest, Err: errors.New("missing uuidsource or uuidtarget")}
+	}
+	if params.UUIDSource == params.UUIDTarget {
+		return "", "", StatusError{Code: http.StatusBadRequest, Err: errors.New("cannot merge a user into themselves")}
+	}
+	return params.UUIDSource, params.UUIDTarget, nil
+}
+
+// MergeUser merges the source user into the target user in the background,
+// moving all of the source user's relationships, and every other user's
+// relationships with them, to the target user, and then deleting the source
+// user.  The status of the merge is returned, with a 202, and can be checked
+// with MergeStatus.  If the user is already being merged into the same user,
+// the running merge's status is returned instead of starting another.  As a
+// merge changes the target user's relationships, it needs the admin key when
+// end-user tokens are on.
+func MergeUser(ac *config.AppConfig, w http.ResponseWriter, r *http.Request) error {
+	muLog := tracing.Logger(r.Context(), hnLog)
+	from, to, err := mergeParams(r)
+	if err != nil {
+		return err
+	}
+	if enduser, _ := ac.Cfg.BoolOr("auth.enduser.enabled", false); enduser == true && !ac.IsAdmin(r) {
+		return StatusError{Code: http.StatusForbidden, Err: errors.New("merging users requires the admin key")}
+	}
+	muLog = muLog.WithFields(logrus.Fields{"uuidsource": from, "uuidtarget": to})
+
+	db := ac.DB.(storage.Engine)
+	id := mergeJob(from)
+	var m merge
+	version, err := loadJob(r.Context(), db, id, &m)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		muLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot read merge status")
+		return storageError(err)
+	}
+	if err == nil && m.Status == jobRunning && m.Into != to {
+		return StatusError{Code: http.StatusConflict, Err: fmt.Errorf("user is already being merged into '%s'", m.Into)}
+	}
+	if err != nil || m.Status != jobRunning {
+		m = merge{User: from, Into: to}
+		err = claimJob(r.Context(), db, id, &m, version)
+		switch {
+		case err == nil:
+			run := m
+			policies := ac.Definitions().MergePolicies
+			var stats admin.MergeStats
+			runJob(db, id, &run, func(ctx context.Context) (err error) {
+				stats, err = admin.MergeUser(ctx, db, from, to, policies)
+				return err
+			}, func() {
+				run.Moved, run.Redirected, run.Dropped = stats.Moved, stats.Redirected, stats.Dropped
+			}, muLog)
+		case errors.Is(err, storage.ErrVersionMismatch):
+			// Another request started a merge first
+			_, err = loadJob(r.Context(), db, id, &m)
+		}
+		if err != nil {
+			muLog.WithFields(logrus.Fields{"error": err.Error()}).Error("Cannot start merging user")
+			return storageError(err)
+		}
+	}
+	m.Runner = ""
+
+	muLog.Info("Merging user")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", r.URL.Path)
+	w.WriteHeader(http.StatusAccepted)
+	return json.NewEncoder(w).Encode(m)
+}
+
+// MergeStatus returns the status of the latest merge of the source user into
+// the target user.
+func MergeStatus(ac *config.AppConfig, w http.ResponseWriter, r *http.Request) error {
+	from, to, err := mergeParams(r)
+	if err != nil {
+		return err
+	}
+	var status merge
+	_, err = loadJob(r.Context(), ac.DB.(storage.Engine), mergeJob(from), &status)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && status.Into != to) {
+		return StatusError{Code: http.StatusNotFound, Err: errors.New("no merge of these users has been started")}
+	}
+	if err != nil {
+		return storageError(err)
+	}
+	status.Runner = ""
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(status)
+}
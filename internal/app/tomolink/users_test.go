@@ -0,0 +1,116 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tomolink
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/joeholley/tomolink/internal/config"
+	"github.com/joeholley/tomolink/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForJob polls the status route until the job isn't running, and returns
+// its status.
+func waitForJob(t *testing.T, h http.Handler, url string) map[string]interface{} {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		w := serve(h, "GET", url, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", url, w.Code, w.Body.String())
+		}
+		var status map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+		if status["status"] != jobRunning {
+			return status
+		}
+	}
+	t.Fatalf("job at %s still running", url)
+	return nil
+}
+
+func TestDeleteUser(t *testing.T) {
+	assert := assert.New(t)
+	router := Router(newTestConfig(t, nil))
+
+	w := serve(router, "GET", "/users/a/deletion", "")
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	w = serve(router, "PUT", "/v2/users/a/friends/b?direction=mutual&delta=1", "")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	w = serve(router, "DELETE", "/users/a", "")
+	assert.Equal(http.StatusAccepted, w.Code, w.Body.String())
+	assert.Equal("/users/a/deletion", w.Header().Get("Location"))
+	assert.NotContains(w.Body.String(), "runner")
+
+	status := waitForJob(t, router, "/users/a/deletion")
+	assert.Equal(jobDone, status["status"])
+	assert.Equal(float64(1), status["removed"])
+	w = serve(router, "GET", "/users/b/friends/a", "")
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestInterruptedJob(t *testing.T) {
+	assert := assert.New(t)
+	ac := newTestConfig(t, nil)
+	router := Router(ac)
+	db := ac.DB.(storage.Engine)
+
+	// A deletion whose instance stopped without recording its status
+	stale := time.Now().Add(-time.Hour)
+	d := deletion{User: "a", jobState: jobState{Status: jobRunning, Started: stale, Runner: "gone", Heartbeat: stale}}
+	assert.Nil(saveJob(context.Background(), db, deletionJob("a"), &d, ""))
+
+	status := waitForJob(t, router, "/users/a/deletion")
+	assert.Equal(jobFailed, status["status"])
+	assert.Equal(jobInterrupted, status["error"])
+
+	// Sending the delete again starts it again
+	w := serve(router, "DELETE", "/users/a", "")
+	assert.Equal(http.StatusAccepted, w.Code, w.Body.String())
+	status = waitForJob(t, router, "/users/a/deletion")
+	assert.Equal(jobDone, status["status"])
+}
+
+func TestMergeUserNeedsAdminKey(t *testing.T) {
+	assert := assert.New(t)
+	router := Router(newTestConfig(t, map[string]string{
+		"AUTH_ENDUSER_ENABLED": "true",
+		"AUTH_ENDUSER_SECRET":  testSecret,
+		"AUTH_ADMIN_KEY":       testAdminKey,
+	}))
+
+	w := serve(router, "PUT", "/v2/users/a/friends/c?delta=2", "", "Authorization", "Bearer "+testToken(t, "a"))
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	// The source user's token isn't enough, and the admin key is
+	w = serve(router, "POST", "/users/a/mergeInto/b", "", "Authorization", "Bearer "+testToken(t, "a"))
+	assert.Equal(http.StatusForbidden, w.Code, w.Body.String())
+	w = serve(router, "POST", "/users/a/mergeInto/b", "", config.AdminKeyHeader, testAdminKey)
+	assert.Equal(http.StatusAccepted, w.Code, w.Body.String())
+
+	status := waitForJob(t, router, "/users/a/mergeInto/b")
+	assert.Equal(jobDone, status["status"])
+	assert.Equal(float64(1), status["moved"])
+	w = serve(router, "GET", "/v2/users/b/friends/c", "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("2", w.Body.String())
+}
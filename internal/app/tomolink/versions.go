@@ -0,0 +1,58 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// versions.go:
+// Optimistic concurrency control.  Retrievals return the version of the
+// source user's relationships in the ETag header, and writes sent with that
+// version in the If-Match header are only applied if the user's relationships
+// haven't changed since.
+
+package tomolink
+
+import (
+	"strings"
+
+	"github.com/joeholley/tomolink/internal/storage"
+)
+
+// Names of the headers (or gRPC metadata keys) carrying versions.
+const (
+	etagHeader    = "ETag"
+	ifMatchHeader = "If-Match"
+)
+
+// etag formats a storage version as an ETag header value.
+func etag(version string) string {
+	return `"` + version + `"`
+}
+
+// ifMatch returns the storage version in an If-Match header value, or "" if
+// the value is empty.
+func ifMatch(value string) string {
+	v := strings.TrimSpace(value)
+	if v == "*" {
+		return storage.AnyVersion
+	}
+	return strings.Trim(strings.TrimPrefix(v, "W/"), `"`)
+}
+
+// withVersion makes the writes conditional on the source user's relationships
+// being at version.  Only the source user is checked, so for mutual writes
+// the reciprocal relationship is written whatever the target user's version.
+func withVersion(writes []storage.Write, version string) []storage.Write {
+	if len(writes) > 0 {
+		writes[0].IfVersion = version
+	}
+	return writes
+}
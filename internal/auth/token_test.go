@@ -0,0 +1,89 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+	now := time.Unix(1577836800, 0)
+
+	mustSign := func(c Claims, key []byte) string {
+		tok, err := Sign(c, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	valid := mustSign(Claims{Subject: "dee", ExpiresAt: now.Unix() + 60}, secret)
+	parts := strings.Split(valid, ".")
+	noneAlg := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) +
+		"." + parts[1] + "."
+
+	testCases := []struct {
+		name     string
+		in       string
+		expected error
+	}{
+		{"valid", valid, nil},
+		{"empty", "", ErrMalformed},
+		{"two segments", parts[0] + "." + parts[1], ErrMalformed},
+		{"alg none", noneAlg, ErrAlgorithm},
+		{"wrong secret", mustSign(Claims{Subject: "dee"}, []byte("nope")), ErrSignature},
+		{"tampered payload", parts[0] + "." +
+			base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"eff"}`)) + "." + parts[2], ErrSignature},
+		{"expired", mustSign(Claims{Subject: "dee", ExpiresAt: now.Unix()}, secret), ErrExpired},
+		{"not yet valid", mustSign(Claims{Subject: "dee", NotBefore: now.Unix() + 1}, secret), ErrNotYetValid},
+		{"no subject", mustSign(Claims{ExpiresAt: now.Unix() + 60}, secret), ErrNoSubject},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("Verify(%s) => %v", tc.name, tc.expected), func(t *testing.T) {
+			assert := assert.New(t)
+			c, err := Verify(tc.in, secret, now)
+			assert.Equal(tc.expected, err)
+			if tc.expected == nil {
+				assert.Equal("dee", c.Subject)
+			}
+		})
+	}
+}
+
+func TestFromHeader(t *testing.T) {
+	testCases := []struct {
+		in       string
+		expected string
+	}{
+		{"", ""},
+		{"abc.def.ghi", "abc.def.ghi"},
+		{"Bearer abc.def.ghi", "abc.def.ghi"},
+		{"bearer  abc.def.ghi ", "abc.def.ghi"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("FromHeader(%s) => %s", tc.in, tc.expected), func(t *testing.T) {
+			assert := assert.New(t)
+			assert.Equal(tc.expected, FromHeader(tc.in))
+		})
+	}
+}
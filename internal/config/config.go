@@ -19,7 +19,9 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 
+	"github.com/joeholley/tomolink/internal/storage"
 	"github.com/sirupsen/logrus"
 	goconfig "github.com/zpatrick/go-config"
 )
@@ -31,12 +33,6 @@ var (
 	})
 )
 
-// MaxRelationships is the maximum number of types of relationships tracked.
-// Out-of-the-box, Tomolink supports tracking of up to 10 different kinds of relationships.
-// This can be changed and Tomolink recompiled, but note that raising this
-// value indiscriminantly may have an impact on startup times!
-const MaxRelationships = 10
-
 // AppConfig holds the loaded static config, env overrides, and any runtime
 // configuration for the app (shared database connections, etc)
 type AppConfig struct {
@@ -44,6 +40,32 @@ type AppConfig struct {
 	Cfg           *goconfig.Config
 	Overrides     map[string]string
 	Relationships map[string]string
+	// Maximum number of relationships of each type a single user can have.
+	// Relationship types without a cap aren't in the map.
+	RelationshipCaps map[string]int
+	// Relationship types whose reads are never cached, so they always
+	// reflect the latest writes.
+	UncachedRelationships map[string]bool
+	// How relationships of each type are combined when merging two users.
+	MergePolicies map[string]storage.MergePolicy
+	// The current name of each renamed relationship type, by old name.
+	RelationshipAliases map[string]string
+	// Relationship types that can be read, but not written.
+	DeprecatedRelationships map[string]bool
+
+	// The relationship type definitions in effect, including the ones
+	// managed at runtime; see Definitions.
+	defsMu sync.RWMutex
+	defs   *Definitions
+}
+
+// RelationshipName returns the current name of a relationship type, which is
+// the name itself unless it is the old name of a renamed type.
+func (ac *AppConfig) RelationshipName(relationship string) string {
+	if name, ok := ac.RelationshipAliases[relationship]; ok {
+		return name
+	}
+	return relationship
 }
 
 // Load the application goconfig into a goconfig.Config object
@@ -57,8 +79,13 @@ func (ac *AppConfig) Load(appName string) error {
 	d := goconfig.NewYAMLFile(defaultsFileName)
 	defaults := goconfig.NewOnceLoader(d)
 
+	// Relationship definitions written as a YAML list are read separately,
+	// as goconfig only reads YAML maps
+	defs := definitionList{path: defaultsFileName}
+	list := goconfig.NewOnceLoader(defs)
+
 	// Set up environment mappings
-	mappings, err := getMappings(goconfig.NewOnceLoader(d))
+	mappings, err := getMappings(goconfig.NewOnceLoader(d), goconfig.NewOnceLoader(defs))
 	if err != nil {
 		return err
 	}
@@ -81,7 +108,7 @@ func (ac *AppConfig) Load(appName string) error {
 	}
 
 	// build goconfig out of all sources, last source wins in conflicts
-	sources := []goconfig.Provider{defaults, envLoader}
+	sources := []goconfig.Provider{defaults, list, envLoader}
 	ac.Cfg = goconfig.NewConfig(sources)
 	if err := ac.Cfg.Load(); err != nil {
 		return err
@@ -125,12 +152,12 @@ func (ac *AppConfig) Load(appName string) error {
 //Example:
 // YAML file goconfig key			env var name
 // relationships.strict				RELATIONSHIPS_STRICT
-func getMappings(defaults *goconfig.OnceLoader) (map[string]string, error) {
+// relationships.definitions.friends.max	RELATIONSHIPS_DEFINITIONS_FRIENDS_MAX
+func getMappings(defaults ...goconfig.Provider) (map[string]string, error) {
 	mappings := make(map[string]string)
 
 	// Load defaults from YAML file
-	sources := []goconfig.Provider{defaults}
-	cfg := goconfig.NewConfig(sources)
+	cfg := goconfig.NewConfig(defaults)
 	if err := cfg.Load(); err != nil {
 		return nil, err
 		//cfgLog.Fatal(err)
@@ -204,22 +231,44 @@ func (ac *AppConfig) populateOverrides(overrides *goconfig.OnceLoader) error {
 func (ac *AppConfig) populateRelationships() error {
 
 	ac.Relationships = map[string]string{}
+	ac.RelationshipCaps = map[string]int{}
+	ac.UncachedRelationships = map[string]bool{}
+	ac.MergePolicies = map[string]storage.MergePolicy{}
+	ac.RelationshipAliases = map[string]string{}
+	ac.DeprecatedRelationships = map[string]bool{}
+
+	settings, err := ac.Cfg.Settings()
+	if err != nil {
+		cfgLog.Error(err)
+		return err
+	}
 
-	// Loop through all possibley defined relationships, looking for config data
-	for i := 0; i < MaxRelationships; i++ {
+	// Loop through all the defined relationships, whether they're keyed by
+	// name or by position as in older config files
+	for _, index := range definitionKeys(settings) {
 		// Figure out config keys for this relationship
-		index := fmt.Sprintf("relationships.definitions.%d", i)
+		index = definitionsPrefix + index
 		nameKey := index + ".name"
 		kindKey := index + ".type"
-
-		// Initialize routes and handlers for this relationship
-		relationship, err := ac.Cfg.String(nameKey)
-
+		maxKey := index + ".max"
+		cacheKey := index + ".cache"
+		mergeKey := index + ".merge"
+		aliasesKey := index + ".aliases"
+		deprecatedKey := index + ".deprecated"
+
+		// Definitions in a map are named by their key, unless they set a name
+		relationship, err := ac.Cfg.StringOr(nameKey, strings.TrimPrefix(index, definitionsPrefix))
 		if err != nil {
-			if err.Error() == fmt.Sprintf("Required setting '%s' not set", nameKey) {
-				cfgLog.Info(fmt.Sprintf("No '%s' configured. Done processing relationships from the config", nameKey))
-				break
-			}
+			cfgLog.Error(err)
+			return err
+		}
+		if err := storage.ValidRelationshipName(relationship); err != nil {
+			err := fmt.Errorf("'%s': %v", nameKey, err)
+			cfgLog.Error(err)
+			return err
+		}
+		if _, ok := ac.Relationships[relationship]; ok {
+			err := fmt.Errorf("relationship type '%s' is defined twice", relationship)
 			cfgLog.Error(err)
 			return err
 		}
@@ -227,15 +276,90 @@ func (ac *AppConfig) populateRelationships() error {
 		// 'type' is a keyword, so we use var name 'kind' to hold the type
 		kind, err := ac.Cfg.String(kindKey)
 		if err != nil {
-			if err.Error() == fmt.Sprintf("Required setting '%s' not set", kindKey) {
-				cfgLog.Info(fmt.Sprintf("No '%s' configured. Done processing relationships from the config", kindKey))
-				break
-			}
 			cfgLog.Error(err)
 			return err
 		}
 		ac.Relationships[relationship] = kind
+
+		// A cap of 0 means this relationship type is uncapped
+		max, err := ac.Cfg.IntOr(maxKey, 0)
+		if err != nil {
+			cfgLog.Error(err)
+			return err
+		}
+		if max > 0 {
+			ac.RelationshipCaps[relationship] = max
+		}
+
+		cache, err := ac.Cfg.BoolOr(cacheKey, true)
+		if err != nil {
+			cfgLog.Error(err)
+			return err
+		}
+		if cache == false {
+			ac.UncachedRelationships[relationship] = true
+		}
+
+		merge, err := ac.Cfg.StringOr(mergeKey, string(storage.MergeMax))
+		if err != nil {
+			cfgLog.Error(err)
+			return err
+		}
+		policy := storage.MergePolicy(merge)
+		if !policy.Valid() {
+			err := fmt.Errorf("'%s' must be 'sum', 'max' or 'keep', not '%s'", mergeKey, merge)
+			cfgLog.Error(err)
+			return err
+		}
+		ac.MergePolicies[relationship] = policy
+
+		// Old names are comma-separated, so they can be overridden with a
+		// single environment variable
+		aliases, err := ac.Cfg.StringOr(aliasesKey, "")
+		if err != nil {
+			cfgLog.Error(err)
+			return err
+		}
+		for _, alias := range strings.Split(aliases, ",") {
+			alias = strings.TrimSpace(alias)
+			if alias == "" {
+				continue
+			}
+			if err := storage.ValidRelationshipName(alias); err != nil {
+				err := fmt.Errorf("'%s': %v", aliasesKey, err)
+				cfgLog.Error(err)
+				return err
+			}
+			if other, ok := ac.RelationshipAliases[alias]; ok {
+				err := fmt.Errorf("'%s' is an old name of both '%s' and '%s'", alias, other, relationship)
+				cfgLog.Error(err)
+				return err
+			}
+			ac.RelationshipAliases[alias] = relationship
+		}
+
+		deprecated, err := ac.Cfg.BoolOr(deprecatedKey, false)
+		if err != nil {
+			cfgLog.Error(err)
+			return err
+		}
+		if deprecated == true {
+			ac.DeprecatedRelationships[relationship] = true
+		}
 	}
 
+	// An old name that is still defined would hide the definition
+	for alias, relationship := range ac.RelationshipAliases {
+		if _, ok := ac.Relationships[alias]; ok {
+			err := fmt.Errorf("'%s' is defined, so it can't also be an old name of '%s'", alias, relationship)
+			cfgLog.Error(err)
+			return err
+		}
+	}
+
+	// Until the definitions managed at runtime are read from the database,
+	// only the config file's are in effect
+	ac.setDefinitions(ac.combine(nil, ""))
+
 	return nil
 }
@@ -18,10 +18,12 @@ package config
 
 import (
 	"context"
+	"fmt"
 	"strconv"
+	"time"
 
 	"cloud.google.com/go/firestore"
-	//"github.com/joeholley/tomolink/internal/database/memory"
+	"github.com/joeholley/tomolink/internal/storage"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/api/option"
 )
@@ -32,9 +34,60 @@ func (ac *AppConfig) Connect(dbEngine string) error {
 	logFields := logrus.Fields{"database.engine": dbEngine}
 	dbLog := cfgLog.WithFields(logFields)
 
+	// Additional database engines could be added as cases in this switch statement
+	var engine storage.Engine
+	switch dbEngine {
+	case "firestore":
+		layout, err := ac.Cfg.StringOr("database.layout", storage.DocumentLayout)
+		if err != nil {
+			return err
+		}
+		fs, err := ac.ConnectFirestore(layout)
+		if err != nil {
+			return err
+		}
+		dbLog.WithFields(logrus.Fields{"database.layout": layout}).Info("Firestore layout set")
+		engine = fs
+	case "memory":
+		dbLog.Warn("Using the in-memory database engine; relationships are lost when the server stops")
+		engine = storage.NewMemory()
+	default:
+		return fmt.Errorf("unsupported database engine '%s'", dbEngine)
+	}
+
+	// Wrap the engine so every database operation is recorded in the storage
+	// metrics, and transient errors are retried
+	engine = storage.Resilient(storage.Instrument(engine), ac.resilienceOptions())
+	tiers, err := ac.cacheTiers()
+	if err != nil {
+		return err
+	}
+	if len(tiers) > 0 {
+		dbLog.WithFields(logrus.Fields{"cache.tiers": len(tiers)}).Info("caching reads")
+		engine = storage.Cached(engine, ac.UncachedRelationships, tiers...)
+	}
+	// Serve relationships stored under the old names of renamed types as
+	// relationships of the current type, until they are moved
+	if len(ac.RelationshipAliases) > 0 {
+		dbLog.WithFields(logrus.Fields{"aliases": len(ac.RelationshipAliases)}).Info("serving renamed relationship types")
+		engine = storage.Aliased(engine, ac.RelationshipAliases)
+	}
+	ac.DB = engine
+
+	return nil
+}
+
+// ConnectFirestore makes a Firestore client from the database settings, and
+// returns an engine storing relationships in the layout.
+func (ac *AppConfig) ConnectFirestore(layout string) (*storage.Firestore, error) {
+	dbLog := cfgLog.WithFields(logrus.Fields{"database.engine": "firestore"})
+
 	// Read DB settings and put them in the options array
 	var options []option.ClientOption
 	settings, err := ac.Cfg.Settings()
+	if err != nil {
+		return nil, err
+	}
 	if val, ok := settings["database.options.grpc.pool"]; ok {
 		optLog := dbLog.WithFields(logrus.Fields{"grpc.pool": val})
 
@@ -52,20 +105,71 @@ func (ac *AppConfig) Connect(dbEngine string) error {
 		}
 	}
 
-	// Additional database engines could be added as cases in this switch statement
-	switch dbEngine {
-	case "firestore":
-		ac.DB, err = firestore.NewClient(context.Background(),
-			settings["database.id"],
-			options...)
+	client, err := firestore.NewClient(context.Background(),
+		settings["database.id"],
+		options...)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := storage.NewFirestore(client, layout)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+// cacheTiers returns the read caches turned on in the config, in the order
+// they are checked.
+func (ac *AppConfig) cacheTiers() ([]storage.Cache, error) {
+	var tiers []storage.Cache
+	if enabled, _ := ac.Cfg.BoolOr("cache.enabled", false); enabled == true {
+		size, err := ac.Cfg.IntOr("cache.size", 10000)
 		if err != nil {
-			return err
+			return nil, err
 		}
-	case "memory":
-		// TODO: NYI, this is for local testing in the future
-		//ac.DB = memory.NewClient()
-
+		ttl, err := ac.Cfg.IntOr("cache.ttl", 5)
+		if err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, storage.NewLRU(size, time.Duration(ttl)*time.Second))
+	}
+	if enabled, _ := ac.Cfg.BoolOr("cache.redis.enabled", false); enabled == true {
+		address, err := ac.Cfg.StringOr("cache.redis.address", "localhost:6379")
+		if err != nil {
+			return nil, err
+		}
+		password, err := ac.Cfg.StringOr("cache.redis.password", "")
+		if err != nil {
+			return nil, err
+		}
+		prefix, err := ac.Cfg.StringOr("cache.redis.prefix", "tomolink:cache:")
+		if err != nil {
+			return nil, err
+		}
+		ttl, err := ac.Cfg.IntOr("cache.redis.ttl", 60)
+		if err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, storage.NewRedisCache(address, password, prefix, time.Duration(ttl)*time.Second))
 	}
+	return tiers, nil
+}
 
-	return nil
+// resilienceOptions reads the storage retry and circuit breaker settings.
+func (ac *AppConfig) resilienceOptions() storage.ResilienceOptions {
+	attempts, _ := ac.Cfg.IntOr("database.retries.attempts", 3)
+	backoff, _ := ac.Cfg.IntOr("database.retries.backoff", 50)
+	maxBackoff, _ := ac.Cfg.IntOr("database.retries.maxBackoff", 1000)
+	attemptTimeout, _ := ac.Cfg.IntOr("database.retries.attemptTimeout", 5000)
+	failures, _ := ac.Cfg.IntOr("database.breaker.failures", 5)
+	cooldown, _ := ac.Cfg.IntOr("database.breaker.cooldown", 10)
+	return storage.ResilienceOptions{
+		Attempts:        attempts,
+		Backoff:         time.Duration(backoff) * time.Millisecond,
+		MaxBackoff:      time.Duration(maxBackoff) * time.Millisecond,
+		AttemptTimeout:  time.Duration(attemptTimeout) * time.Millisecond,
+		BreakerFailures: failures,
+		BreakerCooldown: time.Duration(cooldown) * time.Second,
+	}
 }
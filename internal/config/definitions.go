@@ -0,0 +1,101 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file contains functions related to reading the relationship
+// definitions, which can be written as a YAML map or list.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/joeholley/tomolink/internal/storage"
+	"gopkg.in/yaml.v2"
+)
+
+// definitionsPrefix starts the config keys of the relationship definitions.
+const definitionsPrefix = "relationships.definitions."
+
+// definitionList provides the settings of relationship definitions written
+// as a YAML list.  goconfig only flattens YAML maps into settings, so each
+// definition in the list is provided as if it was in a map keyed by its name,
+// which also lets environment variables override its settings the same way.
+type definitionList struct {
+	path string
+}
+
+// Load returns the settings of the definitions, if they are a list.
+func (d definitionList) Load() (map[string]string, error) {
+	data, err := ioutil.ReadFile(d.path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Relationships struct {
+			Definitions interface{} `yaml:"definitions"`
+		} `yaml:"relationships"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	settings := map[string]string{}
+	list, ok := file.Relationships.Definitions.([]interface{})
+	if !ok {
+		return settings, nil
+	}
+	for i, item := range list {
+		def, ok := item.(map[interface{}]interface{})
+		if !ok || def["name"] == nil {
+			return nil, fmt.Errorf("relationship definition %d in %s has no name", i, d.path)
+		}
+		// Names are part of the keys, so they're checked before they're used
+		name := fmt.Sprint(def["name"])
+		if err := storage.ValidRelationshipName(name); err != nil {
+			return nil, fmt.Errorf("relationship definition %d in %s: %v", i, d.path, err)
+		}
+		if _, ok := settings[definitionsPrefix+name+".name"]; ok {
+			return nil, fmt.Errorf("relationship type '%s' is defined twice in %s", name, d.path)
+		}
+		for k, v := range def {
+			settings[definitionsPrefix+name+"."+fmt.Sprint(k)] = fmt.Sprint(v)
+		}
+	}
+	return settings, nil
+}
+
+// definitionKeys returns the keys the relationship definitions are under in
+// the settings, in order.  Definitions without any settings, such as the
+// 'Null' placeholders of older configs, are left out.
+func definitionKeys(settings map[string]string) []string {
+	found := map[string]bool{}
+	for key := range settings {
+		if !strings.HasPrefix(key, definitionsPrefix) {
+			continue
+		}
+		rest := key[len(definitionsPrefix):]
+		if i := strings.Index(rest, "."); i > 0 {
+			found[rest[:i]] = true
+		}
+	}
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
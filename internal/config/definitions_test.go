@@ -0,0 +1,240 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joeholley/tomolink/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// loadTestConfig loads a config file with the contents, with the environment
+// variables set.
+func loadTestConfig(t *testing.T, contents string, env map[string]string) (*AppConfig, error) {
+	t.Helper()
+	t.Setenv("DEV", "false")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "test_defaults.yaml"), []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	ac := &AppConfig{}
+	return ac, ac.Load("test")
+}
+
+func TestDefinitionShapes(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"map", `
+relationships:
+  definitions:
+    friends:
+      type: score
+      max: 5
+      merge: sum
+      aliases: "pals, mates"
+    blocks:
+      type: score
+      cache: false
+      deprecated: true
+`},
+		{"list", `
+relationships:
+  definitions:
+    - name: friends
+      type: score
+      max: 5
+      merge: sum
+      aliases: "pals, mates"
+    - name: blocks
+      type: score
+      cache: false
+      deprecated: true
+`},
+		// Older configs keyed definitions by position, with placeholders
+		{"positions", `
+relationships:
+  definitions:
+    0:
+      name: friends
+      type: score
+      max: 5
+      merge: sum
+      aliases: "pals, mates"
+    1:
+      name: blocks
+      type: score
+      cache: false
+      deprecated: true
+    2: Null
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			ac, err := loadTestConfig(t, tt.contents, nil)
+			if !assert.Nil(err) {
+				return
+			}
+			assert.Equal(map[string]string{"friends": "score", "blocks": "score"}, ac.Relationships)
+			assert.Equal(map[string]int{"friends": 5}, ac.RelationshipCaps)
+			assert.Equal(map[string]bool{"blocks": true}, ac.UncachedRelationships)
+			assert.Equal(map[string]storage.MergePolicy{"friends": storage.MergeSum, "blocks": storage.MergeMax}, ac.MergePolicies)
+			assert.Equal(map[string]string{"pals": "friends", "mates": "friends"}, ac.RelationshipAliases)
+			assert.Equal(map[string]bool{"blocks": true}, ac.DeprecatedRelationships)
+		})
+	}
+}
+
+func TestDefinitionEnvOverrides(t *testing.T) {
+	list := `
+relationships:
+  definitions:
+    - name: friends
+      type: score
+      max: 5
+    - name: blocks
+      type: score
+      max: 0
+`
+	tests := []struct {
+		name string
+		env  map[string]string
+		caps map[string]int
+	}{
+		{"none", nil, map[string]int{"friends": 5}},
+		{"list entry", map[string]string{"RELATIONSHIPS_DEFINITIONS_FRIENDS_MAX": "3"}, map[string]int{"friends": 3}},
+		{"uncapped", map[string]string{"RELATIONSHIPS_DEFINITIONS_FRIENDS_MAX": "0"}, map[string]int{}},
+		{"uncapped entry", map[string]string{"RELATIONSHIPS_DEFINITIONS_BLOCKS_MAX": "2"}, map[string]int{"friends": 5, "blocks": 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ac, err := loadTestConfig(t, list, tt.env)
+			if assert.Nil(t, err) {
+				assert.Equal(t, tt.caps, ac.RelationshipCaps)
+			}
+		})
+	}
+}
+
+func TestInvalidDefinitions(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"duplicate in list", `
+relationships:
+  definitions:
+    - name: friends
+      type: score
+    - name: friends
+      type: score
+`},
+		{"duplicate in map", `
+relationships:
+  definitions:
+    friends:
+      type: score
+    pals:
+      name: friends
+      type: score
+`},
+		{"list entry without a name", `
+relationships:
+  definitions:
+    - type: score
+`},
+		{"slash in list", `
+relationships:
+  definitions:
+    - name: friends/of
+      type: score
+`},
+		{"period in list", `
+relationships:
+  definitions:
+    - name: friends.of
+      type: score
+`},
+		{"backtick in map", "relationships:\n  definitions:\n    friends:\n      name: \"friends`\"\n      type: score\n"},
+		{"reserved by Firestore", `
+relationships:
+  definitions:
+    __friends__:
+      type: score
+`},
+		{"used by Tomolink", `
+relationships:
+  definitions:
+    - name: _updated
+      type: score
+`},
+		{"user route", `
+relationships:
+  definitions:
+    deletion:
+      type: score
+`},
+		{"invalid alias", `
+relationships:
+  definitions:
+    friends:
+      type: score
+      aliases: "pals, export"
+`},
+		{"invalid merge policy", `
+relationships:
+  definitions:
+    friends:
+      type: score
+      merge: average
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, tt.contents, nil)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestDefinitionKeys(t *testing.T) {
+	settings := map[string]string{
+		"relationships.strict":                   "false",
+		"relationships.definitions.friends.type": "score",
+		"relationships.definitions.friends.max":  "5",
+		"relationships.definitions.0.name":       "blocks",
+		// Placeholders have no settings of their own
+		"relationships.definitions.1": "",
+	}
+	assert.Equal(t, []string{"0", "friends"}, definitionKeys(settings))
+}
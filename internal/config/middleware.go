@@ -19,11 +19,17 @@
 package config
 
 import (
+	"crypto/subtle"
+	"errors"
 	"net/http"
 	"reflect"
+	"time"
 
+	"github.com/joeholley/tomolink/internal/auth"
 	"github.com/joeholley/tomolink/internal/models"
+	"github.com/joeholley/tomolink/internal/tracing"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // StrictMW is a middleware function that checkes the 'relationships.strict'
@@ -43,11 +49,16 @@ func (ac *AppConfig) StrictMW(next http.Handler) http.Handler {
 		sLog = sLog.WithFields(logrus.Fields{
 			"relationship": params.Relationship,
 		})
-		valid := params.RelationshipInArray(keys(ac.Relationships))
+		valid := ac.ValidRelationship(params)
 		sLog = sLog.WithFields(logrus.Fields{
 			"valid": valid,
 		})
 		sLog.Debug("relationship validity check")
+		_, span := tracing.Start(r.Context(), "StrictMW",
+			attribute.String("tomolink.relationship", params.Relationship),
+			attribute.Bool("tomolink.relationship.valid", valid),
+		)
+		span.End()
 
 		// If it was valid, continue processing the request
 		if valid {
@@ -60,6 +71,143 @@ func (ac *AppConfig) StrictMW(next http.Handler) http.Handler {
 	})
 }
 
+// EndUserMW is a middleware function that requires every request to carry a
+// signed end-user token (see the auth package), and refuses requests where the
+// source user of the relationship isn't the user the token was issued to.
+// Writes are always checked; reads are only checked if
+// 'auth.enduser.enforceReads' is true.  Mutual writes also change the target
+// user's relationships, so they need a second token, issued to the target
+// user.  Requests carrying the admin key don't need any tokens.
+func (ac *AppConfig) EndUserMW(next http.Handler) http.Handler {
+	headerName, _ := ac.Cfg.StringOr("auth.enduser.header", "Authorization")
+	targetHeaderName, _ := ac.Cfg.StringOr("auth.enduser.targetHeader", "X-Tomolink-Target-Token")
+	enforceReads, _ := ac.Cfg.BoolOr("auth.enduser.enforceReads", false)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Reads are let through without a token unless configured otherwise,
+		// and trusted services act for any user
+		if (!enforceReads && (r.Method == http.MethodGet || r.Method == http.MethodHead)) || ac.IsAdmin(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Get the request parameters
+		params := r.Context().Value("params").(*models.Relationship)
+		err := ac.CheckEndUserToken(auth.FromHeader(r.Header.Get(headerName)), params.UUIDSource)
+		switch {
+		case err == ErrNoToken:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case err == ErrWrongUser:
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			w.Header().Set("WWW-Authenticate", "Bearer error=\"invalid_token\"")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		// The target user has to agree to mutual writes as well
+		if params.IsMultipleDirection() {
+			err := ac.CheckTargetToken(auth.FromHeader(r.Header.Get(targetHeaderName)), params.UUIDTarget)
+			switch {
+			case err == ErrWrongTarget:
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			case err != nil:
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Errors returned by CheckEndUserToken, in addition to the auth package token
+// verification errors.
+var (
+	ErrNoToken       = errors.New("end-user token required")
+	ErrWrongUser     = errors.New("token subject does not match uuidsource")
+	ErrNoTargetToken = errors.New("end-user token for uuidtarget required for mutual writes")
+	ErrWrongTarget   = errors.New("target token subject does not match uuidtarget")
+)
+
+// CheckEndUserToken verifies an end-user token, and checks it was issued to
+// the source user of the relationship being acted on.
+func (ac *AppConfig) CheckEndUserToken(token, uuidSource string) error {
+	return ac.checkToken(token, "uuidsource", uuidSource, ErrNoToken, ErrWrongUser)
+}
+
+// CheckTargetToken verifies the end-user token sent for the target user of a
+// mutual write, and checks it was issued to that user.
+func (ac *AppConfig) CheckTargetToken(token, uuidTarget string) error {
+	return ac.checkToken(token, "uuidtarget", uuidTarget, ErrNoTargetToken, ErrWrongTarget)
+}
+
+// checkToken verifies an end-user token, and checks it was issued to user,
+// returning errNoToken or errWrongUser if not.
+func (ac *AppConfig) checkToken(token, field, user string, errNoToken, errWrongUser error) error {
+	secret, _ := ac.Cfg.StringOr("auth.enduser.secret", "")
+	aLog := cfgLog.WithFields(logrus.Fields{
+		"auth.enduser": true,
+		field:          user,
+	})
+
+	if token == "" || secret == "" {
+		aLog.Warn("request missing end-user token")
+		return errNoToken
+	}
+	claims, err := auth.Verify(token, []byte(secret), time.Now())
+	if err != nil {
+		aLog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("end-user token rejected")
+		return err
+	}
+
+	// The token subject has to own the relationship being acted on
+	if claims.Subject != user {
+		aLog.WithFields(logrus.Fields{
+			"subject": claims.Subject,
+		}).Warn("end-user token subject doesn't match " + field)
+		return errWrongUser
+	}
+
+	aLog.Debug("end-user token accepted")
+	return nil
+}
+
+// AdminKeyHeader is the request header used to present the admin key
+// configured in 'auth.admin.key'.
+const AdminKeyHeader = "X-Tomolink-Admin-Key"
+
+// IsAdmin reports if the request carries the configured admin key. If no admin
+// key is configured, no request is an admin request.
+func (ac *AppConfig) IsAdmin(r *http.Request) bool {
+	return ac.IsAdminKey(r.Header.Get(AdminKeyHeader))
+}
+
+// IsAdminKey reports if key is the configured admin key.
+func (ac *AppConfig) IsAdminKey(key string) bool {
+	adminKey, _ := ac.Cfg.StringOr("auth.admin.key", "")
+	if adminKey == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) == 1
+}
+
+// ValidRelationship reports if requests can use the relationship type.  With
+// 'relationships.strict' on, only the defined relationships (see Definitions)
+// are valid.
+func (ac *AppConfig) ValidRelationship(params *models.Relationship) bool {
+	if strict, _ := ac.Cfg.BoolOr("relationships.strict", true); strict == false {
+		return true
+	}
+	return params.RelationshipInArray(keys(ac.Definitions().Relationships))
+}
+
 func keys(a map[string]string) []string {
 	j := reflect.ValueOf(a).MapKeys()
 	k := make([]string, len(j))
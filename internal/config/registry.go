@@ -0,0 +1,254 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file contains functions related to the relationship type definitions
+// managed at runtime, which are stored in the database so every instance
+// uses the same ones.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/joeholley/tomolink/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// Errors returned when managing relationship type definitions.
+var (
+	ErrInvalidDefinition   = errors.New("invalid relationship type definition")
+	ErrUnknownRelationship = errors.New("relationship type not defined")
+)
+
+// definitionAttempts is how many times a change to the stored definitions is
+// tried, when another instance changes them at the same time.
+const definitionAttempts = 5
+
+// Definitions are the relationship type definitions in effect: the ones in
+// the config file, and the ones managed at runtime, which replace the config
+// file's definition of the same type.  They mustn't be changed once built.
+type Definitions struct {
+	Relationships           map[string]string
+	RelationshipCaps        map[string]int
+	MergePolicies           map[string]storage.MergePolicy
+	DeprecatedRelationships map[string]bool
+	// The definitions managed at runtime, and their version in the database
+	Managed map[string]storage.Definition
+	version string
+}
+
+// Definitions returns the relationship type definitions in effect.
+func (ac *AppConfig) Definitions() *Definitions {
+	ac.defsMu.RLock()
+	defs := ac.defs
+	ac.defsMu.RUnlock()
+	if defs == nil {
+		return ac.combine(nil, "")
+	}
+	return defs
+}
+
+// setDefinitions puts defs in effect.
+func (ac *AppConfig) setDefinitions(defs *Definitions) {
+	ac.defsMu.Lock()
+	ac.defs = defs
+	ac.defsMu.Unlock()
+}
+
+// combine returns the config file's definitions, replaced by the managed
+// definitions.  Managed definitions that aren't valid with this config file,
+// such as ones using an old name of a renamed type, are left out.
+func (ac *AppConfig) combine(managed map[string]storage.Definition, version string) *Definitions {
+	defs := &Definitions{
+		Relationships:           map[string]string{},
+		RelationshipCaps:        map[string]int{},
+		MergePolicies:           map[string]storage.MergePolicy{},
+		DeprecatedRelationships: map[string]bool{},
+		Managed:                 map[string]storage.Definition{},
+		version:                 version,
+	}
+	for name, kind := range ac.Relationships {
+		defs.Relationships[name] = kind
+	}
+	for name, max := range ac.RelationshipCaps {
+		defs.RelationshipCaps[name] = max
+	}
+	for name, policy := range ac.MergePolicies {
+		defs.MergePolicies[name] = policy
+	}
+	for name := range ac.DeprecatedRelationships {
+		defs.DeprecatedRelationships[name] = true
+	}
+
+	for name, d := range managed {
+		if err := ac.validDefinition(name, d); err != nil {
+			cfgLog.WithFields(logrus.Fields{
+				"relationship": name,
+				"error":        err.Error(),
+			}).Error("Ignoring stored relationship type definition")
+			continue
+		}
+		defs.Managed[name] = d
+		defs.Relationships[name] = d.Type
+		delete(defs.RelationshipCaps, name)
+		if d.Max > 0 {
+			defs.RelationshipCaps[name] = d.Max
+		}
+		defs.MergePolicies[name] = storage.MergePolicy(d.Merge)
+		delete(defs.DeprecatedRelationships, name)
+		if d.Deprecated {
+			defs.DeprecatedRelationships[name] = true
+		}
+	}
+	return defs
+}
+
+// validDefinition checks a managed definition can be used.
+func (ac *AppConfig) validDefinition(name string, d storage.Definition) error {
+	if err := storage.ValidRelationshipName(name); err != nil {
+		return err
+	}
+	if current, ok := ac.RelationshipAliases[name]; ok {
+		return fmt.Errorf("'%s' is an old name of '%s'", name, current)
+	}
+	if d.Type != "score" {
+		return fmt.Errorf("unsupported relationship type '%s'; only 'score' is supported", d.Type)
+	}
+	if d.Max < 0 {
+		return errors.New("'max' can't be negative")
+	}
+	if !storage.MergePolicy(d.Merge).Valid() {
+		return fmt.Errorf("'merge' must be 'sum', 'max' or 'keep', not '%s'", d.Merge)
+	}
+	return nil
+}
+
+// Definition returns the definition of a relationship type in effect, in the
+// form it is managed in, and whether the type is defined.
+func (defs *Definitions) Definition(name string) (storage.Definition, bool) {
+	kind, ok := defs.Relationships[name]
+	if !ok {
+		return storage.Definition{}, false
+	}
+	return storage.Definition{
+		Type:       kind,
+		Max:        defs.RelationshipCaps[name],
+		Merge:      string(defs.MergePolicies[name]),
+		Deprecated: defs.DeprecatedRelationships[name],
+	}, true
+}
+
+// RefreshDefinitions reads the managed definitions from the database, and
+// puts them in effect if they have changed.
+func (ac *AppConfig) RefreshDefinitions(ctx context.Context, db storage.Engine) error {
+	managed, version, err := db.GetDefinitions(ctx)
+	if err != nil {
+		return err
+	}
+	if ac.Definitions().version == version {
+		return nil
+	}
+	ac.setDefinitions(ac.combine(managed, version))
+	cfgLog.WithFields(logrus.Fields{
+		"managed": len(managed),
+		"version": version,
+	}).Info("Relationship type definitions loaded from the database")
+	return nil
+}
+
+// WatchDefinitions refreshes the definitions every interval until ctx is
+// done, so definitions changed by other instances are put in effect here.
+func (ac *AppConfig) WatchDefinitions(ctx context.Context, db storage.Engine, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// Keep the last definitions read if they can't be read now
+		if err := ac.RefreshDefinitions(ctx, db); err != nil {
+			cfgLog.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Warn("Cannot refresh relationship type definitions")
+		}
+	}
+}
+
+// PutDefinition creates or replaces the managed definition of a relationship
+// type, reporting if the type wasn't defined before.  Empty 'type' and
+// 'merge' settings are 'score' and 'max'.  The definition is in effect on
+// this instance when it returns, and on the others after their next refresh.
+func (ac *AppConfig) PutDefinition(ctx context.Context, db storage.Engine, name string, d storage.Definition) (bool, error) {
+	if d.Type == "" {
+		d.Type = "score"
+	}
+	if d.Merge == "" {
+		d.Merge = string(storage.MergeMax)
+	}
+	if err := ac.validDefinition(name, d); err != nil {
+		return false, fmt.Errorf("%w: %v", ErrInvalidDefinition, err)
+	}
+	created := false
+	err := ac.changeDefinitions(ctx, db, func(current *Definitions, managed map[string]storage.Definition) error {
+		_, defined := current.Relationships[name]
+		created = !defined
+		managed[name] = d
+		return nil
+	})
+	return created, err
+}
+
+// RetireDefinition deprecates a relationship type, so its relationships can
+// still be read, but not written.  Types defined in the config file are
+// retired by adding a managed definition for them.
+func (ac *AppConfig) RetireDefinition(ctx context.Context, db storage.Engine, name string) error {
+	return ac.changeDefinitions(ctx, db, func(current *Definitions, managed map[string]storage.Definition) error {
+		d, ok := current.Definition(name)
+		if !ok {
+			return ErrUnknownRelationship
+		}
+		d.Deprecated = true
+		managed[name] = d
+		return nil
+	})
+}
+
+// changeDefinitions applies change to the stored definitions, retrying if
+// another instance changes them at the same time, and puts the result in
+// effect.  change is given the definitions in effect with the stored ones.
+func (ac *AppConfig) changeDefinitions(ctx context.Context, db storage.Engine, change func(current *Definitions, managed map[string]storage.Definition) error) error {
+	for attempt := 0; attempt < definitionAttempts; attempt++ {
+		managed, version, err := db.GetDefinitions(ctx)
+		if err != nil {
+			return err
+		}
+		if err := change(ac.combine(managed, version), managed); err != nil {
+			return err
+		}
+		err = db.SetDefinitions(ctx, managed, version)
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return ac.RefreshDefinitions(ctx, db)
+	}
+	return errors.New("relationship type definitions kept changing")
+}
@@ -0,0 +1,164 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics defines the Prometheus metrics exported by Tomolink, and
+// the functions the rest of the application uses to record them.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tomolink"
+
+var (
+	requestLabels = []string{"route", "method", "code", "relationship", "direction"}
+	storageLabels = []string{"engine", "operation"}
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests handled, by route name, method, status code, relationship type and direction.",
+	}, requestLabels)
+	httpLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency, by route name, method, status code, relationship type and direction.",
+		Buckets:   prometheus.DefBuckets,
+	}, requestLabels)
+
+	grpcLabels   = []string{"method", "code", "relationship", "direction"}
+	grpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "requests_total",
+		Help:      "gRPC requests handled, by method, status code, relationship type and direction.",
+	}, grpcLabels)
+	grpcLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "request_duration_seconds",
+		Help:      "gRPC request latency, by method, status code, relationship type and direction.",
+		Buckets:   prometheus.DefBuckets,
+	}, grpcLabels)
+
+	storageLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "operation_duration_seconds",
+		Help:      "Storage engine operation latency, by engine and operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, storageLabels)
+	storageErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "errors_total",
+		Help:      "Storage engine operations that returned an error, by engine and operation.",
+	}, storageLabels)
+	storageRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "retries_total",
+		Help:      "Storage engine operations retried after a transient error, by engine and operation.",
+	}, storageLabels)
+	storageRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "rejected_total",
+		Help:      "Storage engine operations refused because the circuit breaker was open, by engine and operation.",
+	}, storageLabels)
+	storageBreakerOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "circuit_breaker_open",
+		Help:      "1 if the storage engine's circuit breaker is open, and 0 if it is closed.",
+	}, []string{"engine"})
+	cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "Read cache lookups and updates, by cache tier, storage operation and result (hit, miss or error).",
+	}, []string{"tier", "operation", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpLatency, grpcRequests, grpcLatency, storageLatency, storageErrors,
+		storageRetries, storageRejected, storageBreakerOpen, cacheRequests)
+}
+
+// Handler returns the HTTP handler that serves the metrics to Prometheus.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveRequest records a completed HTTP request.
+func ObserveRequest(route, method string, code int, relationship, direction string, d time.Duration) {
+	labels := prometheus.Labels{
+		"route":        route,
+		"method":       method,
+		"code":         strconv.Itoa(code),
+		"relationship": relationship,
+		"direction":    direction,
+	}
+	httpRequests.With(labels).Inc()
+	httpLatency.With(labels).Observe(d.Seconds())
+}
+
+// ObserveGRPCRequest records a completed gRPC request.
+func ObserveGRPCRequest(method, code, relationship, direction string, d time.Duration) {
+	grpcRequests.WithLabelValues(method, code, relationship, direction).Inc()
+	grpcLatency.WithLabelValues(method, code, relationship, direction).Observe(d.Seconds())
+}
+
+// ObserveStorage records a storage engine operation that started at start.
+func ObserveStorage(engine, operation string, start time.Time, err error) {
+	storageLatency.WithLabelValues(engine, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		storageErrors.WithLabelValues(engine, operation).Inc()
+	}
+}
+
+// ObserveStorageRetry records a storage engine operation being retried.
+func ObserveStorageRetry(engine, operation string) {
+	storageRetries.WithLabelValues(engine, operation).Inc()
+}
+
+// ObserveStorageRejected records a storage engine operation refused by the
+// circuit breaker.
+func ObserveStorageRejected(engine, operation string) {
+	storageRejected.WithLabelValues(engine, operation).Inc()
+}
+
+// SetStorageBreakerOpen records whether the storage engine's circuit breaker
+// is open.
+func SetStorageBreakerOpen(engine string, open bool) {
+	v := 0.0
+	if open {
+		v = 1
+	}
+	storageBreakerOpen.WithLabelValues(engine).Set(v)
+}
+
+// ObserveCache records a read cache lookup or update, with the result "hit",
+// "miss" or "error".
+func ObserveCache(tier, operation, result string) {
+	cacheRequests.WithLabelValues(tier, operation, result).Inc()
+}
@@ -22,6 +22,19 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// Update operations, set in Relationship.Operation.  They only apply to
+// updates.
+const (
+	// Increment adds the delta to the score.  This is the default.
+	Increment = "increment"
+	// CompareAndSet sets the score to the delta, only if it is currently
+	// Expected.
+	CompareAndSet = "compareAndSet"
+	// Max sets the score to the delta, if that is higher than the current
+	// score.
+	Max = "max"
+)
+
 //Relationship ...
 type Relationship struct {
 	Direction    string `json:"direction"`
@@ -29,15 +42,49 @@ type Relationship struct {
 	Delta        int    `json:"delta"`
 	UUIDSource   string `json:"uuidsource"`
 	UUIDTarget   string `json:"uuidtarget"`
+	// How an update changes the score; see the operation constants above.
+	Operation string `json:"operation,omitempty"`
+	// The score a CompareAndSet update requires the relationship to have.
+	Expected *int64 `json:"expected,omitempty"`
+	// If set, an Increment update never takes the score above Ceiling.
+	Ceiling *int64 `json:"ceiling,omitempty"`
+	// If true, an update that leaves the score at or below 0 deletes the
+	// relationship instead.
+	DeleteIfNotPositive bool `json:"deleteIfNotPositive,omitempty"`
 }
 
 //Validate ...
 func (rel *Relationship) Validate() error {
-	if rel.IsSingleDirection() == true ||
-		rel.IsMultipleDirection() == true {
-		return nil
+	if rel.IsSingleDirection() == false &&
+		rel.IsMultipleDirection() == false {
+		return fmt.Errorf("invalid relationship direction '%v'", rel.Direction)
+	}
+	switch rel.Operation {
+	case "", Increment, Max:
+	case CompareAndSet:
+		if rel.Expected == nil {
+			return errors.New("the compareAndSet operation requires the expected score")
+		}
+	default:
+		return fmt.Errorf("invalid operation '%v'", rel.Operation)
 	}
-	return fmt.Errorf("invalid relationship direction '%v'", rel.Direction)
+	return nil
+}
+
+// ValidateNotUpdate checks that a create or delete doesn't set any of the
+// fields that only apply to updates.
+func (rel *Relationship) ValidateNotUpdate() error {
+	switch {
+	case rel.Operation != "":
+		return errors.New("the operation only applies to updates")
+	case rel.Expected != nil:
+		return errors.New("the expected score only applies to updates")
+	case rel.Ceiling != nil:
+		return errors.New("the ceiling only applies to updates")
+	case rel.DeleteIfNotPositive:
+		return errors.New("deleteIfNotPositive only applies to updates")
+	}
+	return nil
 }
 
 // Merge uses reflection to iterate over the values in both input relationships
@@ -101,6 +148,20 @@ func (rel *Relationship) Merge(rel2 *Relationship) (*Relationship, error) {
 					return &mergedRel, errors.New("relationship field conflict - the same field has two different, non-empty values")
 				}
 
+			case reflect.Bool:
+				// There's no way to tell an unset bool from false, so true
+				// wins
+				m.Field(i).SetBool(sourceField.Bool() || otherField.Bool())
+
+			case reflect.Ptr:
+				m.Field(i).Set(sourceField)
+				if sourceField.IsNil() {
+					m.Field(i).Set(otherField)
+				} else if !otherField.IsNil() {
+					// Both structs point to (different) values
+					return &mergedRel, errors.New("relationship field conflict - the same field has two different, non-empty values")
+				}
+
 			default:
 				// This should never happen unless someone adds a new field to
 				// the Relationships struct without understanding the code!!
@@ -139,6 +200,9 @@ func (rel *Relationship) VerboseLogger() *logrus.Entry {
 		"uuidsource":   rel.UUIDSource,
 		"uuidtarget":   rel.UUIDTarget,
 	})
+	if rel.Operation != "" {
+		logger = logger.WithFields(logrus.Fields{"operation": rel.Operation})
+	}
 
 	return logger
 }
@@ -0,0 +1,173 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit provides token bucket rate limiters, either kept
+// in-process (for single instance deployments) or shared by all instances
+// through Redis.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit describes a token bucket: it holds up to Burst tokens, and Rate
+// tokens are added back every Period.  Each request takes one token.
+type Limit struct {
+	Rate   int
+	Burst  int
+	Period time.Duration
+}
+
+// Enabled reports if the limit is configured to limit anything at all.
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Period > 0
+}
+
+// capacity is the size of the bucket. If no burst size is set, the bucket
+// holds one period's worth of tokens.
+func (l Limit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Rate)
+}
+
+// perSecond is the rate at which tokens are added back to the bucket.
+func (l Limit) perSecond() float64 {
+	return float64(l.Rate) / l.Period.Seconds()
+}
+
+// Result is the outcome of asking a Limiter for a token.
+type Result struct {
+	Allowed bool
+	// RetryAfter is how long the client should wait before a token will be
+	// available.  Only set if Allowed is false.
+	RetryAfter time.Duration
+}
+
+// Bucket identifies a token bucket by its key, and gives its limit.
+type Bucket struct {
+	Key   string
+	Limit Limit
+}
+
+// Limiter hands out tokens from buckets identified by a key.  The first
+// request for a key starts with a full bucket.
+type Limiter interface {
+	// Allow takes a token from the bucket for key, if one is available.
+	Allow(ctx context.Context, key string, l Limit) (Result, error)
+	// AllowAll takes a token from each of the buckets, which have distinct
+	// keys, if every one of them has a token available, and takes none
+	// otherwise, so a request refused by one bucket doesn't use up the
+	// others.  If refused, RetryAfter is the longest wait for a token.
+	AllowAll(ctx context.Context, buckets ...Bucket) (Result, error)
+}
+
+// refill returns the tokens in a bucket that held 'tokens' tokens as of
+// 'elapsed' ago.
+func refill(tokens float64, elapsed time.Duration, l Limit) float64 {
+	if elapsed <= 0 {
+		return tokens
+	}
+	return math.Min(l.capacity(), tokens+elapsed.Seconds()*l.perSecond())
+}
+
+// wait returns how long until a bucket holding 'tokens' tokens has one.
+func wait(tokens float64, l Limit) time.Duration {
+	return time.Duration((1 - tokens) / l.perSecond() * float64(time.Second))
+}
+
+// Memory is a Limiter that keeps its buckets in the memory of this process.
+// Limits are therefore enforced per Tomolink instance.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+	// Buckets are pruned every time the map grows to this size.
+	sweepAt int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// NewMemory returns an in-process Limiter.
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+		sweepAt: 10000,
+	}
+}
+
+// Allow takes a token from the bucket for key, if one is available.
+func (m *Memory) Allow(ctx context.Context, key string, l Limit) (Result, error) {
+	return m.AllowAll(ctx, Bucket{Key: key, Limit: l})
+}
+
+// AllowAll takes a token from each of the buckets if they all have one.
+func (m *Memory) AllowAll(ctx context.Context, buckets ...Bucket) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	res := Result{Allowed: true}
+	tokens := make([]float64, len(buckets))
+	for i, bk := range buckets {
+		b, ok := m.buckets[bk.Key]
+		if !ok {
+			if len(m.buckets) >= m.sweepAt {
+				m.sweep(now)
+			}
+			b = &bucket{tokens: bk.Limit.capacity(), last: now}
+			m.buckets[bk.Key] = b
+		}
+		b.limit = bk.Limit
+		tokens[i] = refill(b.tokens, now.Sub(b.last), bk.Limit)
+		if tokens[i] < 1 {
+			res.Allowed = false
+			if w := wait(tokens[i], bk.Limit); w > res.RetryAfter {
+				res.RetryAfter = w
+			}
+		}
+	}
+
+	for i, bk := range buckets {
+		b := m.buckets[bk.Key]
+		b.tokens, b.last = tokens[i], now
+		if res.Allowed {
+			b.tokens--
+		}
+	}
+	return res, nil
+}
+
+// sweep removes buckets that have refilled completely, as they are no
+// different from a bucket that doesn't exist yet.
+func (m *Memory) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if refill(b.tokens, now.Sub(b.last), b.limit) >= b.limit.capacity() {
+			delete(m.buckets, key)
+		}
+	}
+	// Don't sweep on every new key if most buckets are in use.
+	if len(m.buckets)*2 > m.sweepAt {
+		m.sweepAt = len(m.buckets) * 2
+	}
+}
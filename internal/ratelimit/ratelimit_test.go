@@ -0,0 +1,113 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryAllow(t *testing.T) {
+	// 2 tokens per 10 seconds, bursts of up to 3
+	limit := Limit{Rate: 2, Burst: 3, Period: 10 * time.Second}
+	start := time.Unix(1577836800, 0)
+
+	testCases := []struct {
+		at       time.Duration
+		key      string
+		expected Result
+	}{
+		{0, "a", Result{Allowed: true}},
+		{0, "a", Result{Allowed: true}},
+		{0, "a", Result{Allowed: true}},
+		{0, "a", Result{RetryAfter: 5 * time.Second}},
+		{0, "b", Result{Allowed: true}},
+		{time.Second, "a", Result{RetryAfter: 4 * time.Second}},
+		{5 * time.Second, "a", Result{Allowed: true}},
+		{5 * time.Second, "a", Result{RetryAfter: 5 * time.Second}},
+		{time.Hour, "a", Result{Allowed: true}},
+		{time.Hour, "a", Result{Allowed: true}},
+		{time.Hour, "a", Result{Allowed: true}},
+		{time.Hour, "a", Result{RetryAfter: 5 * time.Second}},
+	}
+
+	m := NewMemory()
+	for i, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("%d: Allow(%s) at +%s => %v", i, tc.key, tc.at, tc.expected), func(t *testing.T) {
+			assert := assert.New(t)
+			m.now = func() time.Time { return start.Add(tc.at) }
+			actual, err := m.Allow(context.Background(), tc.key, limit)
+			assert.Nil(err)
+			assert.Equal(tc.expected, actual)
+		})
+	}
+}
+
+func TestMemorySweep(t *testing.T) {
+	assert := assert.New(t)
+	limit := Limit{Rate: 1, Period: time.Second}
+	now := time.Unix(1577836800, 0)
+
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	m.sweepAt = 2
+	m.Allow(context.Background(), "a", limit)
+	m.Allow(context.Background(), "b", limit)
+	assert.Len(m.buckets, 2)
+
+	// Both buckets are full again after a second, so adding a third bucket
+	// sweeps the first two away.
+	now = now.Add(time.Second)
+	m.Allow(context.Background(), "c", limit)
+	assert.Len(m.buckets, 1)
+}
+
+func TestMemoryAllowAll(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	now := time.Unix(1577836800, 0)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	wide := Limit{Rate: 10, Period: 10 * time.Second}
+	narrow := Limit{Rate: 1, Period: 10 * time.Second}
+
+	res, err := m.AllowAll(ctx, Bucket{"caller", wide}, Bucket{"user", narrow})
+	assert.Nil(err)
+	assert.True(res.Allowed)
+
+	// The second bucket is empty, so no token is taken from the first
+	for i := 0; i < 20; i++ {
+		res, err = m.AllowAll(ctx, Bucket{"caller", wide}, Bucket{"user", narrow})
+		assert.Nil(err)
+		assert.Equal(Result{RetryAfter: 10 * time.Second}, res)
+	}
+	for i := 0; i < 9; i++ {
+		res, _ = m.Allow(ctx, "caller", wide)
+		assert.True(res.Allowed, "token %d", i)
+	}
+	res, _ = m.Allow(ctx, "caller", wide)
+	assert.False(res.Allowed)
+
+	// The longest wait is reported
+	now = now.Add(5 * time.Second)
+	res, _ = m.AllowAll(ctx, Bucket{"caller", wide}, Bucket{"user", narrow})
+	assert.False(res.Allowed)
+	assert.Equal(5*time.Second, res.RetryAfter)
+}
@@ -0,0 +1,144 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// takeScript is the Redis equivalent of Memory.AllowAll.  Doing this in a
+// script makes the read-refill-write of the buckets atomic across all Tomolink
+// instances, so tokens are only taken if every bucket has one, and using the
+// Redis server clock means instances don't need synchronized clocks.
+//   KEYS: bucket keys
+//   ARGV: for each bucket in turn, its capacity, the tokens added per second,
+//         and the seconds until an untouched bucket is full again (key TTL)
+var takeScript = redis.NewScript(-1, `
+redis.replicate_commands()
+local now = redis.call('TIME')
+local t = tonumber(now[1]) + tonumber(now[2]) / 1000000
+local tokens = {}
+local allowed = 1
+local wait = 0
+for i, key in ipairs(KEYS) do
+	local capacity = tonumber(ARGV[3*i-2])
+	local rate = tonumber(ARGV[3*i-1])
+	local b = redis.call('HMGET', key, 'tokens', 'ts')
+	local n = tonumber(b[1])
+	local ts = tonumber(b[2])
+	if n == nil or ts == nil then
+		n = capacity
+		ts = t
+	end
+	if t > ts then
+		n = math.min(capacity, n + (t - ts) * rate)
+	end
+	if n < 1 then
+		allowed = 0
+		wait = math.max(wait, (1 - n) / rate)
+	end
+	tokens[i] = n
+end
+for i, key in ipairs(KEYS) do
+	if allowed == 1 then
+		tokens[i] = tokens[i] - 1
+	end
+	redis.call('HMSET', key, 'tokens', tostring(tokens[i]), 'ts', tostring(t))
+	redis.call('EXPIRE', key, ARGV[3*i])
+end
+return {allowed, tostring(wait)}
+`)
+
+// Redis is a Limiter that keeps its buckets in Redis, so that limits are
+// enforced across all Tomolink instances sharing the same Redis.
+type Redis struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+// NewRedis returns a Limiter using the Redis server at address. Connections
+// are made lazily, so an unreachable server shows up as errors from Allow.
+// Bucket keys are prefixed with prefix.
+func NewRedis(address, password, prefix string) *Redis {
+	return &Redis{
+		pool: &redis.Pool{
+			MaxIdle:     16,
+			IdleTimeout: 240 * time.Second,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", address,
+					redis.DialPassword(password),
+					redis.DialConnectTimeout(time.Second),
+					redis.DialReadTimeout(time.Second),
+					redis.DialWriteTimeout(time.Second))
+			},
+		},
+		prefix: prefix,
+	}
+}
+
+// Allow takes a token from the bucket for key, if one is available.
+func (rl *Redis) Allow(ctx context.Context, key string, l Limit) (Result, error) {
+	return rl.AllowAll(ctx, Bucket{Key: key, Limit: l})
+}
+
+// AllowAll takes a token from each of the buckets if they all have one, in a
+// single script call.
+func (rl *Redis) AllowAll(ctx context.Context, buckets ...Bucket) (Result, error) {
+	if len(buckets) == 0 {
+		return Result{Allowed: true}, nil
+	}
+	conn, err := rl.pool.GetContext(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	args := []interface{}{len(buckets)}
+	for _, b := range buckets {
+		args = append(args, rl.prefix+b.Key)
+	}
+	for _, b := range buckets {
+		ttl := int(math.Ceil(b.Limit.capacity()/b.Limit.perSecond())) + 1
+		args = append(args, b.Limit.capacity(), b.Limit.perSecond(), ttl)
+	}
+	reply, err := redis.Values(takeScript.Do(conn, args...))
+	if err != nil {
+		return Result{}, err
+	}
+
+	var allowed int
+	var wait string
+	if _, err := redis.Scan(reply, &allowed, &wait); err != nil {
+		return Result{}, err
+	}
+	if allowed == 1 {
+		return Result{Allowed: true}, nil
+	}
+	secs, err := strconv.ParseFloat(wait, 64)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{RetryAfter: time.Duration(secs * float64(time.Second))}, nil
+}
+
+// Close releases the connections to Redis.
+func (rl *Redis) Close() error {
+	return rl.pool.Close()
+}
@@ -0,0 +1,222 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// aliased wraps an Engine, serving relationships stored under the old names
+// of renamed relationship types as relationships of the current type, until
+// they have been moved.
+type aliased struct {
+	Engine
+	// The old names of each renamed type, by current name
+	oldNames map[string][]string
+	// The current name of each old name
+	current map[string]string
+	// The most old names any type has
+	mostOld int
+}
+
+// Aliased returns an Engine that treats relationships stored under the old
+// names in aliases (a map of old names to current names) as relationships of
+// the current type.  Reads of a current type include the relationships stored
+// under its old names, taking the higher score where both have one, and
+// GetUser only returns current names.  Writes to a current type also delete
+// the relationship under the old names, and writes whose result depends on
+// the current score are resolved against the higher of the scores under the
+// current and old names, in the same transaction.  Old names are passed
+// through unchanged, so the relationships stored under them can still be read
+// and moved directly.
+func Aliased(e Engine, aliases map[string]string) Engine {
+	a := &aliased{Engine: e, oldNames: map[string][]string{}, current: aliases}
+	for old, name := range aliases {
+		a.oldNames[name] = append(a.oldNames[name], old)
+	}
+	for _, olds := range a.oldNames {
+		sort.Strings(olds)
+		if len(olds) > a.mostOld {
+			a.mostOld = len(olds)
+		}
+	}
+	return a
+}
+
+// MaxWrites leaves room for the deletes of the relationships under the old
+// names that each write can add.
+func (a *aliased) MaxWrites() int {
+	return a.Engine.MaxWrites() / (1 + a.mostOld)
+}
+
+// fold moves the relationships stored under old names into their current
+// types, in place.
+func (a *aliased) fold(relationships map[string]Scores) {
+	for old, name := range a.current {
+		scores, ok := relationships[old]
+		if !ok {
+			continue
+		}
+		delete(relationships, old)
+		folded := relationships[name]
+		if folded == nil {
+			folded = Scores{}
+			relationships[name] = folded
+		}
+		for target, score := range scores {
+			if existing, ok := folded[target]; !ok || score > existing {
+				folded[target] = score
+			}
+		}
+	}
+}
+
+func (a *aliased) GetUser(ctx context.Context, user string) (map[string]Scores, string, error) {
+	relationships, version, err := a.Engine.GetUser(ctx, user)
+	if err != nil {
+		return relationships, version, err
+	}
+	a.fold(relationships)
+	return relationships, version, nil
+}
+
+func (a *aliased) GetRelationships(ctx context.Context, user, relationship string) (Scores, string, error) {
+	if len(a.oldNames[relationship]) == 0 {
+		return a.Engine.GetRelationships(ctx, user, relationship)
+	}
+	// The whole user is read, so all the types are read at the same version
+	relationships, version, err := a.GetUser(ctx, user)
+	if err != nil {
+		return nil, "", err
+	}
+	scores, ok := relationships[relationship]
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+	return scores, version, nil
+}
+
+func (a *aliased) GetScore(ctx context.Context, user, relationship, target string) (int64, string, error) {
+	if len(a.oldNames[relationship]) == 0 {
+		return a.Engine.GetScore(ctx, user, relationship, target)
+	}
+	relationships, version, err := a.GetUser(ctx, user)
+	if err != nil {
+		return 0, "", err
+	}
+	score, ok := relationships[relationship][target]
+	if !ok {
+		return 0, "", ErrNotFound
+	}
+	return score, version, nil
+}
+
+func (a *aliased) Write(ctx context.Context, writes ...Write) (WriteResult, error) {
+	return a.Engine.Write(ctx, a.prepare(writes)...)
+}
+
+func (a *aliased) WriteOnce(ctx context.Context, key string, ttl time.Duration, writes ...Write) (WriteResult, error) {
+	return a.Engine.WriteOnce(ctx, key, ttl, a.prepare(writes)...)
+}
+
+// edgeKey identifies a relationship within a batch of writes.
+type edgeKey struct {
+	user, relationship, target string
+}
+
+// prepare returns the writes with the old names of their relationship types
+// added, and deletes of the relationships under the old names.  Writes in a
+// batch that already deletes the relationship under an old name, such as the
+// batches of MoveRelationships, are left as they are.
+func (a *aliased) prepare(writes []Write) []Write {
+	deleted := map[edgeKey]bool{}
+	for _, w := range writes {
+		if w.Op == Delete {
+			deleted[edgeKey{w.User, w.Relationship, w.Target}] = true
+		}
+	}
+
+	prepared := make([]Write, 0, len(writes))
+	var extra []Write
+	for _, w := range writes {
+		olds := a.oldNames[w.Relationship]
+		moving := false
+		for _, old := range olds {
+			moving = moving || deleted[edgeKey{w.User, old, w.Target}]
+		}
+		if len(olds) == 0 || moving {
+			prepared = append(prepared, w)
+			continue
+		}
+		w.oldNames = olds
+		prepared = append(prepared, w)
+		for _, old := range olds {
+			extra = append(extra, Write{User: w.User, Relationship: old, Target: w.Target, Op: Delete})
+		}
+	}
+	return append(prepared, extra...)
+}
+
+// moveAttempts is how many times MoveRelationships retries because the
+// user's relationships changed while it ran.
+const moveAttempts = 5
+
+// MoveRelationships moves a user's relationships of type from to type to,
+// a batch at a time, reporting if there were any to move.  Where the user
+// already has a relationship of type to with the same target, the higher
+// score is kept.  Each batch is only written if the user's relationships
+// haven't changed since they were read, so concurrent updates aren't lost,
+// and no relationship is lost or duplicated if it fails part way.
+func MoveRelationships(ctx context.Context, e Engine, user, from, to string) (bool, error) {
+	moved := false
+	for attempt := 0; attempt < moveAttempts; {
+		scores, version, err := e.GetRelationships(ctx, user, from)
+		if errors.Is(err, ErrNotFound) || (err == nil && len(scores) == 0) {
+			return moved, nil
+		}
+		if err != nil {
+			return moved, err
+		}
+
+		targets := make([]string, 0, len(scores))
+		for target := range scores {
+			targets = append(targets, target)
+		}
+		sort.Strings(targets)
+		var writes []Write
+		for _, target := range targets {
+			if len(writes)+2 > e.MaxWrites() {
+				break
+			}
+			writes = append(writes,
+				Write{User: user, Relationship: to, Target: target, Op: Max, Value: scores[target], IfVersion: version},
+				Write{User: user, Relationship: from, Target: target, Op: Delete},
+			)
+		}
+		_, err = e.Write(ctx, writes...)
+		if errors.Is(err, ErrVersionMismatch) {
+			attempt++
+			continue
+		}
+		if err != nil {
+			return moved, err
+		}
+		moved = true
+	}
+	return moved, errors.New("relationships kept changing")
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAliased(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	m := NewMemory()
+	_, err := m.Write(ctx,
+		Write{User: "a", Relationship: "influencers", Target: "b", Op: Set, Value: 2},
+		Write{User: "a", Relationship: "influencers", Target: "c", Op: Set, Value: 5},
+		Write{User: "a", Relationship: "influencers", Target: "d", Op: Set, Value: 1},
+		Write{User: "a", Relationship: "following", Target: "c", Op: Set, Value: 3},
+	)
+	assert.Nil(err)
+	db := Aliased(m, map[string]string{"influencers": "following"})
+
+	// Reads of the current type include the old type, with the higher score
+	relationships, _, err := db.GetUser(ctx, "a")
+	assert.Nil(err)
+	assert.Equal(map[string]Scores{"following": {"b": 2, "c": 5, "d": 1}}, relationships)
+	score, _, err := db.GetScore(ctx, "a", "following", "b")
+	assert.Nil(err)
+	assert.Equal(int64(2), score)
+
+	// Deletes remove the relationship under the old name too
+	_, err = db.Write(ctx, Write{User: "a", Relationship: "following", Target: "d", Op: Delete})
+	assert.Nil(err)
+	_, _, err = db.GetScore(ctx, "a", "following", "d")
+	assert.Equal(ErrNotFound, err)
+
+	// Increments start from the old score, and move just that relationship
+	_, version, err := db.GetUser(ctx, "a")
+	assert.Nil(err)
+	_, err = db.Write(ctx, Write{User: "a", Relationship: "following", Target: "b", Op: Increment, Value: 1, IfVersion: version})
+	assert.Nil(err)
+	scores, _, err := m.GetRelationships(ctx, "a", "following")
+	assert.Nil(err)
+	assert.Equal(Scores{"b": 3, "c": 3}, scores)
+	scores, _, err = m.GetRelationships(ctx, "a", "influencers")
+	assert.Nil(err)
+	assert.Equal(Scores{"c": 5}, scores)
+
+	// Conditional writes compare against the higher score, and change
+	// nothing if they fail
+	_, err = db.Write(ctx, Write{User: "a", Relationship: "following", Target: "c", Op: CompareAndSet, Expected: 3, Value: 7})
+	assert.Equal(ErrConditionFailed, err)
+	scores, _, err = m.GetRelationships(ctx, "a", "influencers")
+	assert.Nil(err)
+	assert.Equal(Scores{"c": 5}, scores)
+	_, err = db.Write(ctx, Write{User: "a", Relationship: "following", Target: "c", Op: CompareAndSet, Expected: 5, Value: 7})
+	assert.Nil(err)
+	relationships, _, err = m.GetUser(ctx, "a")
+	assert.Nil(err)
+	assert.Equal(map[string]Scores{"following": {"b": 3, "c": 7}, "influencers": {}}, relationships)
+}
+
+func TestAliasedMaxWrites(t *testing.T) {
+	assert := assert.New(t)
+	m := NewMemory()
+	assert.Equal(m.MaxWrites(), Aliased(m, nil).MaxWrites())
+
+	db := Aliased(m, map[string]string{"influencers": "following", "idols": "following", "pals": "friends"})
+	assert.Equal(m.MaxWrites()/3, db.MaxWrites())
+
+	// A full batch of writes to the type with the most old names still fits
+	// once the deletes under the old names are added
+	writes := make([]Write, db.MaxWrites())
+	for i := range writes {
+		writes[i] = Write{User: "a", Relationship: "following", Target: fmt.Sprint(i), Op: Set, Value: 1}
+	}
+	prepared := db.(*aliased).prepare(writes)
+	assert.Len(prepared, 3*len(writes))
+	assert.True(len(prepared) <= m.MaxWrites())
+}
@@ -0,0 +1,374 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/joeholley/tomolink/internal/metrics"
+)
+
+// Cache stores the results of reads for Cached, grouped by user so that all
+// of a user's entries can be invalidated together.  Entries expire after a
+// time set by each Cache, which bounds how stale they can be when another
+// Tomolink instance changes the user's relationships.
+type Cache interface {
+	// Name identifies the cache in metrics.
+	Name() string
+	// Get returns the entry stored under key for user, if there is one.
+	Get(ctx context.Context, user, key string) (value []byte, ok bool, err error)
+	// Set stores an entry under key for user.
+	Set(ctx context.Context, user, key string, value []byte) error
+	// Invalidate removes all the entries for user.
+	Invalidate(ctx context.Context, user string) error
+	// Close releases any resources held by the cache.
+	Close() error
+}
+
+// cached wraps an Engine, serving reads from a series of caches (tiers) when
+// possible.  Each tier is checked in order, and an entry found in a later
+// tier is copied to the earlier ones.
+type cached struct {
+	Engine
+	tiers []Cache
+	// Relationship types that are never cached
+	uncached map[string]bool
+
+	// The reads of each user that are under way, so that invalidating the
+	// user stops them storing what they read before the write.
+	mu    sync.Mutex
+	reads map[string]*userReads
+}
+
+// userReads counts the reads of a user that are under way, and the
+// invalidations of the user since the first of them started.
+type userReads struct {
+	n          int
+	generation uint64
+}
+
+// Cached returns an Engine that caches the results of e's reads in the
+// tiers, except for reads of the uncached relationship types.  Every write
+// through the returned Engine invalidates the written users' entries in all
+// the tiers.  GetUser returns every relationship type, so it is only cached
+// if no types are uncached.
+func Cached(e Engine, uncached map[string]bool, tiers ...Cache) Engine {
+	return &cached{Engine: e, tiers: tiers, uncached: uncached, reads: map[string]*userReads{}}
+}
+
+// cacheEntry is the result of a read, as stored in the caches.
+type cacheEntry struct {
+	Relationships map[string]Scores `json:"r,omitempty"`
+	Scores        Scores            `json:"s,omitempty"`
+	Score         int64             `json:"n,omitempty"`
+	Version       string            `json:"v,omitempty"`
+	// Lookups of data that doesn't exist are cached too
+	NotFound bool `json:"nf,omitempty"`
+}
+
+func (c *cached) GetUser(ctx context.Context, user string) (map[string]Scores, string, error) {
+	if len(c.uncached) > 0 {
+		return c.Engine.GetUser(ctx, user)
+	}
+	var e cacheEntry
+	err := c.read(ctx, "GetUser", user, "user", &e, func() error {
+		var err error
+		e.Relationships, e.Version, err = c.Engine.GetUser(ctx, user)
+		return err
+	})
+	return e.Relationships, e.Version, err
+}
+
+func (c *cached) GetRelationships(ctx context.Context, user, relationship string) (Scores, string, error) {
+	if c.uncached[relationship] {
+		return c.Engine.GetRelationships(ctx, user, relationship)
+	}
+	var e cacheEntry
+	err := c.read(ctx, "GetRelationships", user, "r/"+relationship, &e, func() error {
+		var err error
+		e.Scores, e.Version, err = c.Engine.GetRelationships(ctx, user, relationship)
+		return err
+	})
+	return e.Scores, e.Version, err
+}
+
+func (c *cached) GetScore(ctx context.Context, user, relationship, target string) (int64, string, error) {
+	if c.uncached[relationship] {
+		return c.Engine.GetScore(ctx, user, relationship, target)
+	}
+	var e cacheEntry
+	err := c.read(ctx, "GetScore", user, "s/"+relationship+"/"+target, &e, func() error {
+		var err error
+		e.Score, e.Version, err = c.Engine.GetScore(ctx, user, relationship, target)
+		return err
+	})
+	return e.Score, e.Version, err
+}
+
+func (c *cached) Write(ctx context.Context, writes ...Write) (WriteResult, error) {
+	// The writes may have been applied even if there's an error
+	defer c.invalidate(ctx, writes)
+	return c.Engine.Write(ctx, writes...)
+}
+
+func (c *cached) WriteOnce(ctx context.Context, key string, ttl time.Duration, writes ...Write) (WriteResult, error) {
+	defer c.invalidate(ctx, writes)
+	return c.Engine.WriteOnce(ctx, key, ttl, writes...)
+}
+
+func (c *cached) DeleteUser(ctx context.Context, user string) error {
+	defer c.invalidate(ctx, []Write{{User: user}})
+	return c.Engine.DeleteUser(ctx, user)
+}
+
+// Close closes the caches, and then the engine.
+func (c *cached) Close() error {
+	for _, tier := range c.tiers {
+		tier.Close()
+	}
+	return c.Engine.Close()
+}
+
+// read fills e from the first tier that has an entry for key, or else by
+// calling load and storing the result in every tier.  Errors from the caches
+// are counted in the metrics, and otherwise treated as misses.
+func (c *cached) read(ctx context.Context, operation, user, key string, e *cacheEntry, load func() error) error {
+	generation := c.startRead(user)
+	defer c.endRead(user)
+
+	for i, tier := range c.tiers {
+		value, ok, err := tier.Get(ctx, user, key)
+		if err != nil || !ok {
+			metrics.ObserveCache(tier.Name(), operation, cacheResult(false, err))
+			continue
+		}
+		if err := json.Unmarshal(value, e); err != nil {
+			metrics.ObserveCache(tier.Name(), operation, cacheResult(false, err))
+			continue
+		}
+		metrics.ObserveCache(tier.Name(), operation, cacheResult(true, nil))
+		c.store(ctx, c.tiers[:i], user, key, value, generation)
+		if e.NotFound {
+			return ErrNotFound
+		}
+		return nil
+	}
+
+	err := load()
+	switch {
+	case errors.Is(err, ErrNotFound):
+		*e = cacheEntry{NotFound: true}
+	case err != nil:
+		return err
+	}
+	if value, jsonErr := json.Marshal(e); jsonErr == nil {
+		c.store(ctx, c.tiers, user, key, value, generation)
+	}
+	return err
+}
+
+// startRead records a read of the user starting, and returns the user's
+// generation, which each invalidation of the user bumps.
+func (c *cached) startRead(user string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.reads[user]
+	if !ok {
+		r = &userReads{}
+		c.reads[user] = r
+	}
+	r.n++
+	return r.generation
+}
+
+// endRead records a read of the user finishing.  Users are only tracked while
+// they have reads under way.
+func (c *cached) endRead(user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.reads[user]
+	r.n--
+	if r.n == 0 {
+		delete(c.reads, user)
+	}
+}
+
+// generation returns the user's generation, during a read of the user.
+func (c *cached) generation(user string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reads[user].generation
+}
+
+// store sets the entry in each of the tiers, unless the user has been
+// invalidated since the read started, at generation, in which case the value
+// may be from before the write.  If the user is invalidated while the entry
+// is being set, the tiers are invalidated again, as that invalidation may
+// have come before the entry was set.
+func (c *cached) store(ctx context.Context, tiers []Cache, user, key string, value []byte, generation uint64) {
+	if c.generation(user) != generation {
+		return
+	}
+	for _, tier := range tiers {
+		if err := tier.Set(ctx, user, key, value); err != nil {
+			metrics.ObserveCache(tier.Name(), "Set", "error")
+		}
+	}
+	if c.generation(user) != generation {
+		c.invalidateTiers(ctx, tiers, user)
+	}
+}
+
+// invalidate removes the entries of every user the writes change from all
+// the tiers.
+func (c *cached) invalidate(ctx context.Context, writes []Write) {
+	done := map[string]bool{}
+	for _, w := range writes {
+		if done[w.User] {
+			continue
+		}
+		done[w.User] = true
+
+		// Reads under way mustn't store what they read before the write
+		c.mu.Lock()
+		if r, ok := c.reads[w.User]; ok {
+			r.generation++
+		}
+		c.mu.Unlock()
+		c.invalidateTiers(ctx, c.tiers, w.User)
+	}
+}
+
+// invalidateTiers removes all the user's entries from the tiers.
+func (c *cached) invalidateTiers(ctx context.Context, tiers []Cache, user string) {
+	for _, tier := range tiers {
+		if err := tier.Invalidate(ctx, user); err != nil {
+			metrics.ObserveCache(tier.Name(), "Invalidate", "error")
+		}
+	}
+}
+
+func cacheResult(hit bool, err error) string {
+	switch {
+	case err != nil:
+		return "error"
+	case hit:
+		return "hit"
+	}
+	return "miss"
+}
+
+// LRU is an in-process Cache that holds the entries of up to a fixed number
+// of users, dropping the least recently used user when it is full.
+type LRU struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu sync.Mutex
+	// Most recently used users at the front
+	order *list.List
+	users map[string]*list.Element
+}
+
+// lruUser holds one user's entries in an LRU.
+type lruUser struct {
+	user    string
+	entries map[string]lruEntry
+}
+
+type lruEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewLRU returns an LRU holding up to size users, whose entries expire after
+// ttl.
+func NewLRU(size int, ttl time.Duration) *LRU {
+	return &LRU{
+		size:  size,
+		ttl:   ttl,
+		now:   time.Now,
+		order: list.New(),
+		users: map[string]*list.Element{},
+	}
+}
+
+// Name returns "memory".
+func (l *LRU) Name() string {
+	return "memory"
+}
+
+// Get returns the entry stored under key for user, if it hasn't expired.
+func (l *LRU) Get(ctx context.Context, user, key string) ([]byte, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.users[user]
+	if !ok {
+		return nil, false, nil
+	}
+	u := el.Value.(*lruUser)
+	e, ok := u.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !l.now().Before(e.expires) {
+		delete(u.entries, key)
+		return nil, false, nil
+	}
+	l.order.MoveToFront(el)
+	return e.value, true, nil
+}
+
+// Set stores an entry under key for user, dropping the least recently used
+// user if the cache is full.
+func (l *LRU) Set(ctx context.Context, user, key string, value []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.users[user]
+	if !ok {
+		el = l.order.PushFront(&lruUser{user: user, entries: map[string]lruEntry{}})
+		l.users[user] = el
+		if l.order.Len() > l.size {
+			oldest := l.order.Back()
+			l.order.Remove(oldest)
+			delete(l.users, oldest.Value.(*lruUser).user)
+		}
+	}
+	el.Value.(*lruUser).entries[key] = lruEntry{value: value, expires: l.now().Add(l.ttl)}
+	l.order.MoveToFront(el)
+	return nil
+}
+
+// Invalidate removes all of user's entries.
+func (l *LRU) Invalidate(ctx context.Context, user string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if el, ok := l.users[user]; ok {
+		l.order.Remove(el)
+		delete(l.users, user)
+	}
+	return nil
+}
+
+// Close does nothing.
+func (l *LRU) Close() error {
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// counting is a Memory engine that counts its reads.
+type counting struct {
+	*Memory
+	reads int
+}
+
+func (c *counting) GetScore(ctx context.Context, user, relationship, target string) (int64, string, error) {
+	c.reads++
+	return c.Memory.GetScore(ctx, user, relationship, target)
+}
+
+func TestCached(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	db := &counting{Memory: NewMemory()}
+	c := Cached(db, map[string]bool{"blocks": true}, NewLRU(10, time.Minute))
+
+	// Missing relationships are cached too
+	_, _, err := c.GetScore(ctx, "a", "friends", "b")
+	assert.Equal(ErrNotFound, err)
+	_, _, err = c.GetScore(ctx, "a", "friends", "b")
+	assert.Equal(ErrNotFound, err)
+	assert.Equal(1, db.reads)
+
+	// Writes invalidate the user's entries
+	_, err = c.Write(ctx, Write{User: "a", Relationship: "friends", Target: "b", Op: Set, Value: 3})
+	assert.Nil(err)
+	for i := 0; i < 2; i++ {
+		score, _, err := c.GetScore(ctx, "a", "friends", "b")
+		assert.Nil(err)
+		assert.Equal(int64(3), score)
+	}
+	assert.Equal(2, db.reads)
+
+	// Uncached relationship types always go to the database
+	_, err = c.Write(ctx, Write{User: "a", Relationship: "blocks", Target: "b", Op: Set, Value: 1})
+	assert.Nil(err)
+	for i := 0; i < 2; i++ {
+		c.GetScore(ctx, "a", "blocks", "b")
+	}
+	assert.Equal(4, db.reads)
+}
+
+// blocking is a Memory engine whose GetScore waits for a signal after reading,
+// so the read can be overtaken by a write.
+type blocking struct {
+	*Memory
+	read, resume chan struct{}
+}
+
+func (b *blocking) GetScore(ctx context.Context, user, relationship, target string) (int64, string, error) {
+	score, version, err := b.Memory.GetScore(ctx, user, relationship, target)
+	b.read <- struct{}{}
+	<-b.resume
+	return score, version, err
+}
+
+func TestCachedReadOvertakenByWrite(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	db := &blocking{Memory: NewMemory(), read: make(chan struct{}), resume: make(chan struct{})}
+	c := Cached(db, nil, NewLRU(10, time.Minute))
+	_, err := c.Write(ctx, Write{User: "a", Relationship: "friends", Target: "b", Op: Set, Value: 1})
+	assert.Nil(err)
+
+	// The first read gets the old score, but finishes after the write
+	done := make(chan int64)
+	go func() {
+		score, _, _ := c.GetScore(ctx, "a", "friends", "b")
+		done <- score
+	}()
+	<-db.read
+	_, err = c.Write(ctx, Write{User: "a", Relationship: "friends", Target: "b", Op: Set, Value: 2})
+	assert.Nil(err)
+	db.resume <- struct{}{}
+	assert.Equal(int64(1), <-done)
+
+	// The old score wasn't cached, so the next read gets the new one
+	go func() {
+		<-db.read
+		db.resume <- struct{}{}
+	}()
+	score, _, err := c.GetScore(ctx, "a", "friends", "b")
+	assert.Nil(err)
+	assert.Equal(int64(2), score)
+}
+
+func TestLRU(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	l := NewLRU(2, time.Minute)
+	now := time.Unix(1577836800, 0)
+	l.now = func() time.Time { return now }
+
+	l.Set(ctx, "a", "k", []byte("1"))
+	l.Set(ctx, "b", "k", []byte("2"))
+	l.Get(ctx, "a", "k")
+	// Adding a third user drops b, the least recently used
+	l.Set(ctx, "c", "k", []byte("3"))
+	_, ok, _ := l.Get(ctx, "b", "k")
+	assert.False(ok)
+	value, ok, _ := l.Get(ctx, "a", "k")
+	assert.True(ok)
+	assert.Equal([]byte("1"), value)
+
+	now = now.Add(time.Minute)
+	_, ok, _ = l.Get(ctx, "a", "k")
+	assert.False(ok)
+}
@@ -0,0 +1,632 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/joeholley/tomolink/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// usersCollection is the Firestore collection holding one document per
+// user.  How the relationships are stored depends on the layout.
+const usersCollection = "users"
+
+// Layouts of the relationship data in Firestore, as set in 'database.layout'.
+const (
+	// DocumentLayout keeps each user's relationships in their user document,
+	// with a map field per relationship type, which maps target user IDs to
+	// scores.  Firestore limits documents to 1 MiB, which limits how many
+	// relationships each user can have.
+	DocumentLayout = "document"
+	// SubcollectionLayout keeps each relationship in its own document,
+	// users/{user}/{relationship}/{target}, with the score in its 'score'
+	// field.  Users can have any number of relationships, but GetUser has to
+	// read every relationship document.
+	SubcollectionLayout = "subcollections"
+	// MigratingLayout makes every write in both layouts, and reads from the
+	// layout set by SetReadLayout, so a running deployment can be moved from
+	// one layout to the other (see CopyToSubcollections).
+	MigratingLayout = "migrating"
+)
+
+// idempotencyCollection is the Firestore collection holding one document per
+// idempotency key used by WriteOnce, named after the hash of the key.  Set up a
+// TTL policy on the 'expires' field to have Firestore delete expired keys.
+const idempotencyCollection = "idempotencyKeys"
+
+// jobsCollection is the Firestore collection holding one document per job
+// stored with SetJob, named after the hash of the job's ID.  Set up a TTL
+// policy on the 'expires' field to have Firestore delete expired jobs.
+const jobsCollection = "jobs"
+
+// maxTransactionWrites is the most writes Firestore allows in a single
+// transaction or batch.
+const maxTransactionWrites = 500
+
+// pingDoc is the document Ping reads.  It doesn't need to exist.
+const pingDoc = "_ping"
+
+// Firestore is the Engine for Google Cloud Firestore.
+type Firestore struct {
+	client *firestore.Client
+	layout string
+
+	// With MigratingLayout, the layout reads come from, and when it was last
+	// read from Firestore
+	mu          sync.Mutex
+	reads       string
+	readsLoaded time.Time
+}
+
+// NewFirestore returns an Engine using the provided Firestore client, and
+// storing the relationships in the layout.
+func NewFirestore(client *firestore.Client, layout string) (*Firestore, error) {
+	switch layout {
+	case DocumentLayout, SubcollectionLayout, MigratingLayout:
+	default:
+		return nil, fmt.Errorf("unknown Firestore layout '%s'", layout)
+	}
+	return &Firestore{client: client, layout: layout}, nil
+}
+
+// Name returns "firestore".
+func (fs *Firestore) Name() string {
+	return "firestore"
+}
+
+func (fs *Firestore) doc(user string) *firestore.DocumentRef {
+	return fs.client.Collection(usersCollection).Doc(user)
+}
+
+// get reads a user document.
+func (fs *Firestore) get(ctx context.Context, user string) (docsnap *firestore.DocumentSnapshot, err error) {
+	ctx, span := startSpan(ctx, "Get", user)
+	defer func() { endSpan(span, err) }()
+	return fs.doc(user).Get(ctx)
+}
+
+// GetUser returns all of a user's relationships.
+func (fs *Firestore) GetUser(ctx context.Context, user string) (map[string]Scores, string, error) {
+	if fs.readLayout(ctx) == SubcollectionLayout {
+		return fs.getUserSubcollections(ctx, user)
+	}
+	docsnap, err := fs.get(ctx, user)
+	if err != nil {
+		return nil, "", fsError(err)
+	}
+	return nestedRelationships(docsnap), version(docsnap), nil
+}
+
+// nestedRelationships returns the relationships in a user document in the
+// document layout.
+func nestedRelationships(docsnap *firestore.DocumentSnapshot) map[string]Scores {
+	relationships := map[string]Scores{}
+	for rel, v := range docsnap.Data() {
+		if rel == updatedField {
+			continue
+		}
+		relationships[rel] = toScores(v)
+	}
+	return relationships
+}
+
+// getField reads a single field of a user document.  It uses a projection
+// query, so Firestore only sends that field rather than the whole document,
+// which can be large for users with many relationships.
+func (fs *Firestore) getField(ctx context.Context, user string, path firestore.FieldPath) (_ interface{}, _ string, err error) {
+	ctx, span := startSpan(ctx, "Select", user)
+	defer func() { endSpan(span, err) }()
+	iter := fs.client.Collection(usersCollection).
+		Where(firestore.DocumentID, "==", fs.doc(user)).
+		SelectPaths(path).
+		Documents(ctx)
+	defer iter.Stop()
+	docsnap, err := iter.Next()
+	if err == iterator.Done {
+		// The user doesn't exist
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", fsError(err)
+	}
+	// Projections still return the document's update time, so the version
+	// is the same as for a full read
+	v, err := docsnap.DataAtPath(path)
+	if err != nil {
+		return nil, "", ErrNotFound
+	}
+	return v, version(docsnap), nil
+}
+
+// relationshipPath returns the path of a relationship type's field in a user
+// document, or with a target, of the target's score within it.  Each name is
+// a separate element of the path rather than being joined with periods, so
+// user IDs containing periods or backticks are read as they are.
+func relationshipPath(relationship string, target ...string) firestore.FieldPath {
+	return append(firestore.FieldPath{relationship}, target...)
+}
+
+// GetRelationships returns a user's relationships of one type.
+func (fs *Firestore) GetRelationships(ctx context.Context, user, relationship string) (Scores, string, error) {
+	if fs.readLayout(ctx) == SubcollectionLayout {
+		return fs.getRelationshipsSubcollection(ctx, user, relationship)
+	}
+	v, version, err := fs.getField(ctx, user, relationshipPath(relationship))
+	if err != nil {
+		return nil, "", err
+	}
+	return toScores(v), version, nil
+}
+
+// GetScore returns the score of a single relationship.
+func (fs *Firestore) GetScore(ctx context.Context, user, relationship, target string) (int64, string, error) {
+	if fs.readLayout(ctx) == SubcollectionLayout {
+		return fs.getScoreSubcollection(ctx, user, relationship, target)
+	}
+	v, version, err := fs.getField(ctx, user, relationshipPath(relationship, target))
+	if err != nil {
+		return 0, "", err
+	}
+	score, ok := toScore(v)
+	if !ok {
+		return 0, "", ErrNotFound
+	}
+	return score, version, nil
+}
+
+// version returns the version of a user document: the time it was last
+// updated, in microseconds (the precision Firestore keeps).
+func version(docsnap *firestore.DocumentSnapshot) string {
+	return strconv.FormatInt(docsnap.UpdateTime.UnixNano()/int64(time.Microsecond), 10)
+}
+
+// Write applies the writes in a single batch, or in a transaction if any of
+// them are capped, conditional or have an IfVersion.
+func (fs *Firestore) Write(ctx context.Context, writes ...Write) (WriteResult, error) {
+	for _, w := range writes {
+		if w.Cap > 0 || w.IfVersion != "" || w.conditional() {
+			return fs.transaction(ctx, writes, nil)
+		}
+	}
+
+	batch := fs.client.Batch()
+	if err := fs.apply(batchWriter{batch}, writes); err != nil {
+		return WriteResult{}, err
+	}
+	ctx, span := startSpan(ctx, "Batch.Commit", writes[0].User)
+	results, err := batch.Commit(ctx)
+	endSpan(span, err)
+	if err != nil {
+		return WriteResult{}, err
+	}
+	// Every write in a batch is committed at the same time
+	return WriteResult{Time: results[0].UpdateTime}, nil
+}
+
+// WriteOnce applies the writes in a transaction that also records the
+// idempotency key, unless the key has already been recorded.
+func (fs *Firestore) WriteOnce(ctx context.Context, key string, ttl time.Duration, writes ...Write) (WriteResult, error) {
+	return fs.transaction(ctx, writes, &idempotencyKey{key: key, ttl: ttl})
+}
+
+// idempotencyKey is the key a transaction checks and records.
+type idempotencyKey struct {
+	key string
+	ttl time.Duration
+}
+
+// MaxWrites counts the Firestore writes each Write takes in the layout: one in
+// the document layout, and two in the subcollection layout, where the user
+// document's '_updated' field is written too, or while migrating, where the
+// write is made in both layouts.  One more is left for the idempotency key.
+func (fs *Firestore) MaxWrites() int {
+	if fs.layout == DocumentLayout {
+		return maxTransactionWrites - 1
+	}
+	return (maxTransactionWrites - 1) / 2
+}
+
+func (fs *Firestore) keyDoc(key string) *firestore.DocumentRef {
+	sum := sha256.Sum256([]byte(key))
+	return fs.client.Collection(idempotencyCollection).Doc(hex.EncodeToString(sum[:]))
+}
+
+// transaction checks the idempotency key (if not nil), the versions, the
+// current scores of conditional writes and the caps, and makes the writes in
+// the same transaction, so concurrent writes can't apply the writes twice,
+// change the relationships between the checks and the writes, or take a user
+// past a cap.  The result is replayed if the writes had already been applied
+// using the key.
+func (fs *Firestore) transaction(ctx context.Context, writes []Write, once *idempotencyKey) (result WriteResult, err error) {
+	ctx, span := startSpan(ctx, "RunTransaction", writes[0].User)
+	defer func() { endSpan(span, err) }()
+	err = fs.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// The function is retried if the transaction conflicts with another
+		result = WriteResult{}
+
+		// Firestore transactions have to do all their reads before any writes
+		if once != nil {
+			_, getSpan := startSpan(ctx, "Transaction.Get", idempotencyCollection)
+			docsnap, err := tx.Get(fs.keyDoc(once.key))
+			endSpan(getSpan, err)
+			if err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+			if err == nil {
+				var rec keyRecord
+				if err := docsnap.DataTo(&rec); err != nil {
+					return err
+				}
+				if time.Now().Before(rec.Expires) {
+					if rec.Fingerprint != fingerprint(writes) {
+						return ErrIdempotencyKeyReused
+					}
+					result = WriteResult{Time: rec.Written, Replayed: true}
+					return nil
+				}
+			}
+		}
+
+		r := newTxReader(ctx, fs, tx, fs.readLayout(ctx))
+		resolved := make([]Write, len(writes))
+		added := addedTargets{}
+		for i, w := range writes {
+			if w.IfVersion != "" {
+				v, exists, err := r.version(w.User)
+				if err != nil {
+					return err
+				}
+				if !exists || (w.IfVersion != AnyVersion && w.IfVersion != v) {
+					return ErrVersionMismatch
+				}
+			}
+
+			resolved[i] = w
+			if !w.conditional() && (w.Cap <= 0 || w.Op == Delete) {
+				continue
+			}
+			current, exists, err := r.score(w.User, w.Relationship, w.Target)
+			if err != nil {
+				return err
+			}
+			for _, old := range w.oldNames {
+				score, ok, err := r.score(w.User, old, w.Target)
+				if err != nil {
+					return err
+				}
+				current, exists = higherScore(current, exists, score, ok)
+			}
+			if w.conditional() {
+				if resolved[i], err = w.resolve(current, exists); err != nil {
+					return err
+				}
+			}
+
+			if w.Cap <= 0 || resolved[i].Op == Delete || exists || added.has(w.User, w.Relationship, w.Target) {
+				continue
+			}
+			n, err := r.count(w.User, w.Relationship, w.Cap)
+			if err != nil {
+				return err
+			}
+			if n+added.count(w.User, w.Relationship) >= w.Cap {
+				return &CapExceededError{User: w.User, Relationship: w.Relationship, Max: w.Cap}
+			}
+			added.add(w.User, w.Relationship, w.Target)
+		}
+
+		if err := fs.apply(tx, resolved); err != nil {
+			return err
+		}
+		if once != nil {
+			rec := keyRecord{Fingerprint: fingerprint(writes), Expires: time.Now().Add(once.ttl)}
+			if err := tx.Set(fs.keyDoc(once.key), rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || result.Replayed {
+		return result, err
+	}
+
+	// The client doesn't return the commit time of transactions, but
+	// Firestore set the key record's 'written' field to it.  Without a key,
+	// the commit updated the first user's document (the '_updated' field in
+	// the subcollection layout), so its update time is the commit time,
+	// unless another write to the user has been committed since.  The writes
+	// are committed either way, so a failed read leaves the time out, rather
+	// than failing the write.
+	if once == nil {
+		docsnap, getErr := fs.get(ctx, writes[0].User)
+		if getErr == nil {
+			result.Time = docsnap.UpdateTime
+		}
+		return result, nil
+	}
+	_, getSpan := startSpan(ctx, "Get", idempotencyCollection)
+	docsnap, getErr := fs.keyDoc(once.key).Get(ctx)
+	endSpan(getSpan, getErr)
+	var rec keyRecord
+	if getErr == nil && docsnap.DataTo(&rec) == nil {
+		result.Time = rec.Written
+	}
+	return result, nil
+}
+
+// DeleteUser deletes the user's document, and in the subcollection layout,
+// each of their relationship documents first.  Large users take several
+// batches, so if it fails part way, some of the user's relationships may be
+// gone; deleting the user again finishes the job.
+func (fs *Firestore) DeleteUser(ctx context.Context, user string) (err error) {
+	if fs.layout != DocumentLayout {
+		if err := fs.deleteSubcollections(ctx, user); err != nil {
+			return err
+		}
+	}
+	ctx, span := startSpan(ctx, "Delete", user)
+	defer func() { endSpan(span, err) }()
+	_, err = fs.doc(user).Delete(ctx)
+	return err
+}
+
+// ScanUsers reads the user documents in ID order, only fetching their names.
+func (fs *Firestore) ScanUsers(ctx context.Context, after string, f func(user string) error) error {
+	q := fs.client.Collection(usersCollection).Select().OrderBy(firestore.DocumentID, firestore.Asc)
+	if after != "" {
+		q = q.StartAfter(after)
+	}
+	iter := q.Documents(ctx)
+	defer iter.Stop()
+	for {
+		docsnap, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if docsnap.Ref.ID == pingDoc {
+			continue
+		}
+		if err := f(docsnap.Ref.ID); err != nil {
+			return err
+		}
+	}
+}
+
+// definitionsRecord is the document holding the relationship type
+// definitions.
+type definitionsRecord struct {
+	Definitions map[string]Definition `firestore:"definitions"`
+}
+
+// GetDefinitions reads the definitions document.  Its version is its update
+// time, like a user's.
+func (fs *Firestore) GetDefinitions(ctx context.Context) (_ map[string]Definition, _ string, err error) {
+	ctx, span := startSpan(ctx, "Get", definitionsDoc)
+	defer func() { endSpan(span, err) }()
+	docsnap, err := fs.client.Collection(settingsCollection).Doc(definitionsDoc).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return map[string]Definition{}, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	var record definitionsRecord
+	if err := docsnap.DataTo(&record); err != nil {
+		return nil, "", err
+	}
+	if record.Definitions == nil {
+		record.Definitions = map[string]Definition{}
+	}
+	return record.Definitions, version(docsnap), nil
+}
+
+// SetDefinitions checks the version and replaces the definitions document in
+// a transaction.
+func (fs *Firestore) SetDefinitions(ctx context.Context, definitions map[string]Definition, ifVersion string) (err error) {
+	ctx, span := startSpan(ctx, "RunTransaction", definitionsDoc)
+	defer func() { endSpan(span, err) }()
+	ref := fs.client.Collection(settingsCollection).Doc(definitionsDoc)
+	return fs.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		current := ""
+		docsnap, err := tx.Get(ref)
+		switch {
+		case err == nil:
+			current = version(docsnap)
+		case status.Code(err) != codes.NotFound:
+			return err
+		}
+		if current != ifVersion {
+			return ErrVersionMismatch
+		}
+		return tx.Set(ref, definitionsRecord{Definitions: definitions})
+	})
+}
+
+func (fs *Firestore) jobDoc(id string) *firestore.DocumentRef {
+	sum := sha256.Sum256([]byte(id))
+	return fs.client.Collection(jobsCollection).Doc(hex.EncodeToString(sum[:]))
+}
+
+// GetJob reads the job's document.  Its version is its update time, like a
+// user's.
+func (fs *Firestore) GetJob(ctx context.Context, id string) (_ Job, _ string, err error) {
+	ctx, span := startSpan(ctx, "Get", jobsCollection)
+	defer func() { endSpan(span, err) }()
+	docsnap, err := fs.jobDoc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return Job{}, "", ErrNotFound
+	}
+	if err != nil {
+		return Job{}, "", err
+	}
+	var job Job
+	if err := docsnap.DataTo(&job); err != nil {
+		return Job{}, "", err
+	}
+	// Firestore's TTL deletion can lag the expiry by a day or more
+	if time.Now().After(job.Expires) {
+		return Job{}, "", ErrNotFound
+	}
+	return job, version(docsnap), nil
+}
+
+// SetJob checks the version and replaces the job's document in a
+// transaction.
+func (fs *Firestore) SetJob(ctx context.Context, id string, job Job, ifVersion string) (err error) {
+	ctx, span := startSpan(ctx, "RunTransaction", jobsCollection)
+	defer func() { endSpan(span, err) }()
+	ref := fs.jobDoc(id)
+	return fs.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		current := ""
+		docsnap, err := tx.Get(ref)
+		switch {
+		case err == nil:
+			var stored Job
+			if err := docsnap.DataTo(&stored); err != nil {
+				return err
+			}
+			if !time.Now().After(stored.Expires) {
+				current = version(docsnap)
+			}
+		case status.Code(err) != codes.NotFound:
+			return err
+		}
+		if current != ifVersion {
+			return ErrVersionMismatch
+		}
+		return tx.Set(ref, job)
+	})
+}
+
+// keyRecord is the document recording an idempotency key.
+type keyRecord struct {
+	Fingerprint string    `firestore:"fingerprint"`
+	Expires     time.Time `firestore:"expires"`
+	// When the writes were committed; Firestore sets this to the commit time
+	Written time.Time `firestore:"written,serverTimestamp"`
+}
+
+// Ping reads a single document, which succeeds as long as Firestore is
+// reachable and the credentials are valid, whether or not it exists.
+func (fs *Firestore) Ping(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "Ping", pingDoc)
+	defer func() { endSpan(span, err) }()
+	_, err = fs.client.Collection(usersCollection).Doc(pingDoc).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	return err
+}
+
+// Close closes the Firestore client.
+func (fs *Firestore) Close() error {
+	return fs.client.Close()
+}
+
+// writer is the part of the Firestore transaction and batch APIs used to
+// make writes.
+type writer interface {
+	Set(dr *firestore.DocumentRef, data interface{}, opts ...firestore.SetOption) error
+	Delete(dr *firestore.DocumentRef, opts ...firestore.Precondition) error
+}
+
+// batchWriter adapts a batch to writer.
+type batchWriter struct {
+	*firestore.WriteBatch
+}
+
+func (b batchWriter) Set(dr *firestore.DocumentRef, data interface{}, opts ...firestore.SetOption) error {
+	b.WriteBatch.Set(dr, data, opts...)
+	return nil
+}
+
+func (b batchWriter) Delete(dr *firestore.DocumentRef, opts ...firestore.Precondition) error {
+	b.WriteBatch.Delete(dr, opts...)
+	return nil
+}
+
+// apply adds the writes to a transaction or batch, in each layout writes are
+// made in.
+func (fs *Firestore) apply(wr writer, writes []Write) error {
+	if fs.layout != SubcollectionLayout {
+		for _, w := range writes {
+			if err := wr.Set(fs.doc(w.User), fsData(w), firestore.MergeAll); err != nil {
+				return err
+			}
+		}
+	}
+	if fs.layout != DocumentLayout {
+		return fs.applySubcollections(wr, writes)
+	}
+	return nil
+}
+
+// fsData returns the data to merge into a user document to make the write in
+// the document layout.
+func fsData(w Write) map[string]map[string]interface{} {
+	var value interface{}
+	switch w.Op {
+	case Increment:
+		value = firestore.Increment(w.Value)
+	case Delete:
+		value = firestore.Delete
+	default:
+		value = w.Value
+	}
+	return map[string]map[string]interface{}{
+		w.Relationship: {w.Target: value},
+	}
+}
+
+// startSpan starts a span for a single Firestore call on a user document.
+func startSpan(ctx context.Context, op, user string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "firestore."+op,
+		attribute.String("db.system", "firestore"),
+		attribute.String("tomolink.user", user),
+	)
+}
+
+// endSpan ends a Firestore call span.  Reading a document that doesn't exist
+// is an expected outcome, so it isn't marked as a failure.
+func endSpan(span trace.Span, err error) {
+	if status.Code(err) == codes.NotFound {
+		err = nil
+	}
+	tracing.End(span, err)
+}
+
+// fsError translates Firestore errors into the storage package errors.
+func fsError(err error) error {
+	if status.Code(err) == codes.NotFound {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,375 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// scoreField is the field of a relationship document in the subcollection
+// layout holding the score.
+const scoreField = "score"
+
+// updatedField is the field of a user document set on every write in the
+// subcollection layout.  Writes to subcollections don't change the parent
+// document, so this keeps the user document's update time, and so the
+// user's version, current.
+const updatedField = "_updated"
+
+// settingsCollection holds Tomolink's own settings, shared by every
+// instance.  The layoutDoc document's readsField is the layout reads come
+// from with MigratingLayout, and the definitionsDoc document holds the
+// relationship type definitions managed at runtime.
+const (
+	settingsCollection = "tomolink"
+	layoutDoc          = "layout"
+	readsField         = "reads"
+	definitionsDoc     = "relationshipTypes"
+)
+
+// layoutRefresh is how often the read layout is re-read with
+// MigratingLayout, and so how long after SetReadLayout every instance has
+// switched.
+const layoutRefresh = 10 * time.Second
+
+// readLayout returns the layout reads come from.
+func (fs *Firestore) readLayout(ctx context.Context) string {
+	if fs.layout != MigratingLayout {
+		return fs.layout
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.reads != "" && time.Since(fs.readsLoaded) < layoutRefresh {
+		return fs.reads
+	}
+	// Keep reading from the last known layout if the setting can't be read
+	reads, err := fs.ReadLayout(ctx)
+	if err == nil {
+		fs.reads = reads
+	} else if fs.reads == "" {
+		fs.reads = DocumentLayout
+	}
+	fs.readsLoaded = time.Now()
+	return fs.reads
+}
+
+// ReadLayout returns the layout reads come from with MigratingLayout, which
+// is DocumentLayout until SetReadLayout is called.
+func (fs *Firestore) ReadLayout(ctx context.Context) (reads string, err error) {
+	ctx, span := startSpan(ctx, "Get", layoutDoc)
+	defer func() { endSpan(span, err) }()
+	docsnap, err := fs.client.Collection(settingsCollection).Doc(layoutDoc).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return DocumentLayout, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	v, err := docsnap.DataAt(readsField)
+	if err != nil {
+		return DocumentLayout, nil
+	}
+	reads, _ = v.(string)
+	if reads != SubcollectionLayout {
+		reads = DocumentLayout
+	}
+	return reads, nil
+}
+
+// SetReadLayout sets the layout reads come from with MigratingLayout, either
+// DocumentLayout or SubcollectionLayout.
+func (fs *Firestore) SetReadLayout(ctx context.Context, reads string) (err error) {
+	if reads != DocumentLayout && reads != SubcollectionLayout {
+		return fmt.Errorf("can't read from the '%s' layout", reads)
+	}
+	ctx, span := startSpan(ctx, "Set", layoutDoc)
+	defer func() { endSpan(span, err) }()
+	_, err = fs.client.Collection(settingsCollection).Doc(layoutDoc).Set(ctx, map[string]interface{}{readsField: reads})
+	return err
+}
+
+// edge returns the document of a relationship in the subcollection layout.
+func (fs *Firestore) edge(user, relationship, target string) *firestore.DocumentRef {
+	return fs.doc(user).Collection(relationship).Doc(target)
+}
+
+// getUserSubcollections returns all of a user's relationships in the
+// subcollection layout.
+func (fs *Firestore) getUserSubcollections(ctx context.Context, user string) (_ map[string]Scores, _ string, err error) {
+	docsnap, err := fs.get(ctx, user)
+	if err != nil {
+		return nil, "", fsError(err)
+	}
+	ctx, span := startSpan(ctx, "Collections", user)
+	defer func() { endSpan(span, err) }()
+	relationships := map[string]Scores{}
+	iter := fs.doc(user).Collections(ctx)
+	for {
+		coll, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		scores, err := readScores(coll.Documents(ctx))
+		if err != nil {
+			return nil, "", err
+		}
+		relationships[coll.ID] = scores
+	}
+	return relationships, version(docsnap), nil
+}
+
+// getRelationshipsSubcollection returns a user's relationships of one type
+// in the subcollection layout.
+func (fs *Firestore) getRelationshipsSubcollection(ctx context.Context, user, relationship string) (_ Scores, _ string, err error) {
+	docsnap, err := fs.get(ctx, user)
+	if err != nil {
+		return nil, "", fsError(err)
+	}
+	ctx, span := startSpan(ctx, "Documents", user)
+	defer func() { endSpan(span, err) }()
+	scores, err := readScores(fs.doc(user).Collection(relationship).Documents(ctx))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(scores) == 0 {
+		return nil, "", ErrNotFound
+	}
+	return scores, version(docsnap), nil
+}
+
+// getScoreSubcollection returns the score of a single relationship in the
+// subcollection layout, reading the user document for the version in the
+// same call.
+func (fs *Firestore) getScoreSubcollection(ctx context.Context, user, relationship, target string) (_ int64, _ string, err error) {
+	ctx, span := startSpan(ctx, "GetAll", user)
+	defer func() { endSpan(span, err) }()
+	docs, err := fs.client.GetAll(ctx, []*firestore.DocumentRef{fs.doc(user), fs.edge(user, relationship, target)})
+	if err != nil {
+		return 0, "", fsError(err)
+	}
+	if !docs[0].Exists() || !docs[1].Exists() {
+		return 0, "", ErrNotFound
+	}
+	score, ok := edgeScore(docs[1])
+	if !ok {
+		return 0, "", ErrNotFound
+	}
+	return score, version(docs[0]), nil
+}
+
+// readScores reads the relationship documents of one subcollection.
+func readScores(iter *firestore.DocumentIterator) (Scores, error) {
+	defer iter.Stop()
+	scores := Scores{}
+	for {
+		docsnap, err := iter.Next()
+		if err == iterator.Done {
+			return scores, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if score, ok := edgeScore(docsnap); ok {
+			scores[docsnap.Ref.ID] = score
+		}
+	}
+}
+
+// edgeScore returns the score in a relationship document.
+func edgeScore(docsnap *firestore.DocumentSnapshot) (int64, bool) {
+	v, err := docsnap.DataAt(scoreField)
+	if err != nil {
+		return 0, false
+	}
+	return toScore(v)
+}
+
+// applySubcollections adds the writes to a transaction or batch in the
+// subcollection layout.
+func (fs *Firestore) applySubcollections(wr writer, writes []Write) error {
+	touched := map[string]bool{}
+	for _, w := range writes {
+		var err error
+		ref := fs.edge(w.User, w.Relationship, w.Target)
+		switch w.Op {
+		case Delete:
+			err = wr.Delete(ref)
+		case Increment:
+			err = wr.Set(ref, map[string]interface{}{scoreField: firestore.Increment(w.Value)}, firestore.MergeAll)
+		default:
+			err = wr.Set(ref, map[string]interface{}{scoreField: w.Value})
+		}
+		if err != nil {
+			return err
+		}
+
+		// While migrating, the write to the user document in the document
+		// layout already updates it
+		if touched[w.User] || fs.layout != SubcollectionLayout {
+			continue
+		}
+		touched[w.User] = true
+		if err := wr.Set(fs.doc(w.User), map[string]interface{}{updatedField: firestore.ServerTimestamp}, firestore.MergeAll); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteSubcollections deletes all of a user's relationship documents, in
+// batches within Firestore's limit of 500 writes.
+func (fs *Firestore) deleteSubcollections(ctx context.Context, user string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteSubcollections", user)
+	defer func() { endSpan(span, err) }()
+	colls, err := fs.doc(user).Collections(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	for _, coll := range colls {
+		for {
+			refs, err := coll.Select().Limit(migrationChunk).Documents(ctx).GetAll()
+			if err != nil {
+				return err
+			}
+			if len(refs) == 0 {
+				break
+			}
+			batch := fs.client.Batch()
+			for _, docsnap := range refs {
+				batch.Delete(docsnap.Ref)
+			}
+			if _, err := batch.Commit(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// txReader reads what a transaction needs to check its writes, from the
+// layout reads come from.  Each document and count is only read once.
+type txReader struct {
+	ctx    context.Context
+	fs     *Firestore
+	tx     *firestore.Transaction
+	layout string
+	// Documents read so far by path; nil if they don't exist
+	docs map[string]*firestore.DocumentSnapshot
+	// Relationships of each user and type, in the subcollection layout
+	counts map[string]int
+}
+
+func newTxReader(ctx context.Context, fs *Firestore, tx *firestore.Transaction, layout string) *txReader {
+	return &txReader{
+		ctx:    ctx,
+		fs:     fs,
+		tx:     tx,
+		layout: layout,
+		docs:   map[string]*firestore.DocumentSnapshot{},
+		counts: map[string]int{},
+	}
+}
+
+// get reads a document, returning nil if it doesn't exist.
+func (r *txReader) get(ref *firestore.DocumentRef, user string) (*firestore.DocumentSnapshot, error) {
+	if docsnap, ok := r.docs[ref.Path]; ok {
+		return docsnap, nil
+	}
+	_, span := startSpan(r.ctx, "Transaction.Get", user)
+	docsnap, err := r.tx.Get(ref)
+	endSpan(span, err)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, err
+	}
+	if err != nil {
+		docsnap = nil
+	}
+	r.docs[ref.Path] = docsnap
+	return docsnap, nil
+}
+
+// version returns the user's version, and whether the user exists.
+func (r *txReader) version(user string) (string, bool, error) {
+	docsnap, err := r.get(r.fs.doc(user), user)
+	if err != nil || docsnap == nil {
+		return "", false, err
+	}
+	return version(docsnap), true, nil
+}
+
+// score returns the current score of a relationship, and whether it exists.
+func (r *txReader) score(user, relationship, target string) (int64, bool, error) {
+	if r.layout == SubcollectionLayout {
+		docsnap, err := r.get(r.fs.edge(user, relationship, target), user)
+		if err != nil || docsnap == nil {
+			return 0, false, err
+		}
+		score, ok := edgeScore(docsnap)
+		return score, ok, nil
+	}
+	existing, err := r.nested(user, relationship)
+	if err != nil {
+		return 0, false, err
+	}
+	score, exists := toScores(existing)[target]
+	return score, exists, nil
+}
+
+// count returns how many relationships of one type the user has, counting no
+// further than limit.
+func (r *txReader) count(user, relationship string, limit int) (int, error) {
+	if r.layout != SubcollectionLayout {
+		existing, err := r.nested(user, relationship)
+		return len(existing), err
+	}
+	key := user + "/" + relationship
+	if n, ok := r.counts[key]; ok {
+		return n, nil
+	}
+	// Only the document names are needed to count them
+	_, span := startSpan(r.ctx, "Transaction.Documents", user)
+	docs, err := r.tx.Documents(r.fs.doc(user).Collection(relationship).Select().Limit(limit)).GetAll()
+	endSpan(span, err)
+	if err != nil {
+		return 0, err
+	}
+	r.counts[key] = len(docs)
+	return len(docs), nil
+}
+
+// nested returns the relationships of one type in a user document in the
+// document layout.
+func (r *txReader) nested(user, relationship string) (map[string]interface{}, error) {
+	docsnap, err := r.get(r.fs.doc(user), user)
+	if err != nil || docsnap == nil {
+		return nil, err
+	}
+	v, err := docsnap.DataAt(relationship)
+	if err != nil {
+		return nil, nil
+	}
+	existing, _ := v.(map[string]interface{})
+	return existing, nil
+}
@@ -0,0 +1,230 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
+)
+
+// newTestFirestore returns a Firestore engine whose client is never
+// connected, for testing what doesn't call Firestore.
+func newTestFirestore(t *testing.T, layout string) *Firestore {
+	t.Helper()
+	client, err := firestore.NewClient(context.Background(), "tomolink-test", option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	fs, err := NewFirestore(client, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+// newEmulatorFirestore returns a Firestore engine using the emulator at
+// FIRESTORE_EMULATOR_HOST, skipping the test if it isn't set.  Each call uses
+// its own project, so tests don't see each other's data.
+func newEmulatorFirestore(t *testing.T, layout string) *Firestore {
+	t.Helper()
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST isn't set")
+	}
+	project := fmt.Sprintf("tomolink-test-%d", time.Now().UnixNano())
+	client, err := firestore.NewClient(context.Background(), project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	fs, err := NewFirestore(client, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+// withLayout returns another engine on the same client using another layout,
+// as a deployment being migrated would.
+func withLayout(t *testing.T, fs *Firestore, layout string) *Firestore {
+	t.Helper()
+	other, err := NewFirestore(fs.client, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return other
+}
+
+// recordingWriter records the paths of the documents written to, the fields
+// set in each, and how many document writes were made.
+type recordingWriter struct {
+	sets    map[string][]string
+	deletes []string
+	n       int
+}
+
+func (r *recordingWriter) Set(dr *firestore.DocumentRef, data interface{}, opts ...firestore.SetOption) error {
+	if r.sets == nil {
+		r.sets = map[string][]string{}
+	}
+	var fields []string
+	switch d := data.(type) {
+	case map[string]interface{}:
+		for field := range d {
+			fields = append(fields, field)
+		}
+	case map[string]map[string]interface{}:
+		for field, targets := range d {
+			for target := range targets {
+				fields = append(fields, field+"."+target)
+			}
+		}
+	}
+	sort.Strings(fields)
+	r.sets[dr.Path] = append(r.sets[dr.Path], fields...)
+	r.n++
+	return nil
+}
+
+func (r *recordingWriter) Delete(dr *firestore.DocumentRef, opts ...firestore.Precondition) error {
+	r.deletes = append(r.deletes, dr.Path)
+	r.n++
+	return nil
+}
+
+func TestFirestoreApply(t *testing.T) {
+	writes := []Write{
+		{User: "a", Relationship: "friends", Target: "b", Op: Set, Value: 1},
+		{User: "a", Relationship: "friends", Target: "c", Op: Increment, Value: 1},
+		{User: "a", Relationship: "friends", Target: "d", Op: Delete},
+	}
+	tests := []struct {
+		layout string
+		// Fields set in the user document, and whether relationship
+		// documents are written
+		userFields []string
+		edges      bool
+	}{
+		{DocumentLayout, []string{"friends.b", "friends.c", "friends.d"}, false},
+		// The '_updated' field is set once, so the user's version changes
+		{SubcollectionLayout, []string{updatedField}, true},
+		// Writes are made in both layouts; the nested write already
+		// updates the user document
+		{MigratingLayout, []string{"friends.b", "friends.c", "friends.d"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			assert := assert.New(t)
+			fs := newTestFirestore(t, tt.layout)
+			wr := &recordingWriter{}
+			assert.Nil(fs.apply(wr, writes))
+
+			assert.Equal(tt.userFields, wr.sets[fs.doc("a").Path])
+			if tt.edges {
+				assert.Equal([]string{scoreField}, wr.sets[fs.edge("a", "friends", "b").Path])
+				assert.Equal([]string{scoreField}, wr.sets[fs.edge("a", "friends", "c").Path])
+				assert.Equal([]string{fs.edge("a", "friends", "d").Path}, wr.deletes)
+				assert.Len(wr.sets, 3)
+			} else {
+				assert.Empty(wr.deletes)
+				assert.Len(wr.sets, 1)
+			}
+		})
+	}
+}
+
+func TestFirestoreMaxWrites(t *testing.T) {
+	assert := assert.New(t)
+	// Every layout's writes, plus an idempotency key, fit in a transaction
+	for _, layout := range []string{DocumentLayout, SubcollectionLayout, MigratingLayout} {
+		fs := newTestFirestore(t, layout)
+		writes := make([]Write, fs.MaxWrites())
+		for i := range writes {
+			writes[i] = Write{User: fmt.Sprint(i), Relationship: "friends", Target: "a", Op: Set, Value: 1}
+		}
+		wr := &recordingWriter{}
+		assert.Nil(fs.apply(wr, writes))
+		assert.True(wr.n+1 <= maxTransactionWrites, "%s: %d writes", layout, wr.n)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	assert := assert.New(t)
+	// Versions are in microseconds, the precision Firestore keeps
+	updated := time.Unix(1, 2500)
+	assert.Equal("1000002", version(&firestore.DocumentSnapshot{UpdateTime: updated}))
+	later := updated.Add(time.Microsecond)
+	assert.NotEqual(version(&firestore.DocumentSnapshot{UpdateTime: updated}), version(&firestore.DocumentSnapshot{UpdateTime: later}))
+}
+
+func TestReadLayout(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	assert.Equal(DocumentLayout, newTestFirestore(t, DocumentLayout).readLayout(ctx))
+	assert.Equal(SubcollectionLayout, newTestFirestore(t, SubcollectionLayout).readLayout(ctx))
+
+	// While migrating, the layout last read from Firestore is used until it
+	// needs reading again
+	fs := newTestFirestore(t, MigratingLayout)
+	fs.reads, fs.readsLoaded = SubcollectionLayout, time.Now()
+	assert.Equal(SubcollectionLayout, fs.readLayout(ctx))
+	fs.reads = DocumentLayout
+	assert.Equal(DocumentLayout, fs.readLayout(ctx))
+
+	assert.NotNil(fs.SetReadLayout(ctx, MigratingLayout))
+}
+
+func TestFirestoreSubcollectionVersion(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	fs := newEmulatorFirestore(t, SubcollectionLayout)
+
+	_, err := fs.Write(ctx, Write{User: "a", Relationship: "friends", Target: "b", Op: Set, Value: 1})
+	assert.Nil(err)
+	_, before, err := fs.GetUser(ctx, "a")
+	assert.Nil(err)
+
+	// Writing only relationship documents still changes the user's version
+	_, err = fs.Write(ctx, Write{User: "a", Relationship: "friends", Target: "c", Op: Set, Value: 2})
+	assert.Nil(err)
+	relationships, after, err := fs.GetUser(ctx, "a")
+	assert.Nil(err)
+	assert.NotEqual(before, after)
+	assert.Equal(map[string]Scores{"friends": {"b": 1, "c": 2}}, relationships)
+
+	_, v, err := fs.GetRelationships(ctx, "a", "friends")
+	assert.Nil(err)
+	assert.Equal(after, v)
+	score, v, err := fs.GetScore(ctx, "a", "friends", "c")
+	assert.Nil(err)
+	assert.Equal(int64(2), score)
+	assert.Equal(after, v)
+}
+
+func TestFirestoreWriteTimes(t *testing.T) {
+	for _, layout := range []string{DocumentLayout, SubcollectionLayout, MigratingLayout} {
+		t.Run(layout, func(t *testing.T) {
+			testWriteTimes(t, newEmulatorFirestore(t, layout))
+		})
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// migrationChunk is the most relationship document writes a migration
+// transaction makes, within Firestore's limit of writes per transaction.
+// They are written directly, not as Writes, so each is one Firestore write
+// whatever the layout.
+const migrationChunk = 400
+
+// migrationAttempts is how many times copying a user is restarted because
+// the user's relationships changed during the copy.
+const migrationAttempts = 5
+
+// errUserChanged is returned from a migration transaction when the user's
+// relationships changed since they were read.
+var errUserChanged = errors.New("user changed during migration")
+
+// MigrationStats counts what a migration step did.
+type MigrationStats struct {
+	Users         int
+	Relationships int
+	// Users whose relationships don't match between the layouts
+	Mismatched []string
+}
+
+// edgeOp is a single relationship document write made by the migration.
+type edgeOp struct {
+	relationship, target string
+	score                int64
+	delete               bool
+}
+
+// CopyToSubcollections copies every user's relationships from the document
+// layout to the subcollection layout, deleting relationships in the
+// subcollection layout that aren't in the document layout, so it can be run
+// again after a failure.  It can be run while Tomolink is serving, as long as
+// every instance uses MigratingLayout, so writes made during the copy are
+// made in both layouts.  progress, if not nil, is called after each user.
+func (fs *Firestore) CopyToSubcollections(ctx context.Context, progress func(user string)) (MigrationStats, error) {
+	var stats MigrationStats
+	err := fs.ScanUsers(ctx, "", func(user string) error {
+		n, err := fs.copyUser(ctx, user)
+		if err != nil {
+			return fmt.Errorf("copying user %s: %v", user, err)
+		}
+		stats.Users++
+		stats.Relationships += n
+		if progress != nil {
+			progress(user)
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// copyUser copies one user's relationships to the subcollection layout,
+// returning how many there are.  Large users are copied in several
+// transactions; each checks the user document is unchanged, and if it has
+// changed the copy restarts from the new relationships.
+func (fs *Firestore) copyUser(ctx context.Context, user string) (int, error) {
+	for attempt := 0; attempt < migrationAttempts; attempt++ {
+		docsnap, err := fs.get(ctx, user)
+		if err != nil {
+			if fsError(err) == ErrNotFound {
+				return 0, nil
+			}
+			return 0, err
+		}
+		edges, err := fs.readSubcollections(ctx, user)
+		if err != nil {
+			return 0, err
+		}
+		ops, count := migrationOps(nestedRelationships(docsnap), edges)
+		for _, chunk := range chunkOps(ops, migrationChunk) {
+			if err = fs.copyChunk(ctx, user, docsnap.UpdateTime, chunk); err != nil {
+				break
+			}
+		}
+		if err == errUserChanged {
+			continue
+		}
+		return count, err
+	}
+	return 0, fmt.Errorf("relationships changed during %d attempts to copy them", migrationAttempts)
+}
+
+// migrationOps returns the relationship document writes that make the
+// subcollection layout match the document layout, and how many relationships
+// there are in the document layout.  Only what differs is written, so copying
+// again is cheap.
+func migrationOps(nested, edges map[string]Scores) (ops []edgeOp, count int) {
+	for rel, scores := range nested {
+		for target, score := range scores {
+			count++
+			if current, ok := edges[rel][target]; !ok || current != score {
+				ops = append(ops, edgeOp{relationship: rel, target: target, score: score})
+			}
+		}
+	}
+	for rel, scores := range edges {
+		for target := range scores {
+			if _, ok := nested[rel][target]; !ok {
+				ops = append(ops, edgeOp{relationship: rel, target: target, delete: true})
+			}
+		}
+	}
+	return ops, count
+}
+
+// chunkOps splits the writes into chunks of at most size writes.
+func chunkOps(ops []edgeOp, size int) [][]edgeOp {
+	var chunks [][]edgeOp
+	for len(ops) > size {
+		chunks = append(chunks, ops[:size])
+		ops = ops[size:]
+	}
+	if len(ops) > 0 {
+		chunks = append(chunks, ops)
+	}
+	return chunks
+}
+
+// copyChunk makes one chunk of a user's relationship document writes in a
+// transaction, returning errUserChanged without writing anything if the user
+// document was updated since updated.
+func (fs *Firestore) copyChunk(ctx context.Context, user string, updated time.Time, ops []edgeOp) error {
+	return fs.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		current, err := tx.Get(fs.doc(user))
+		if err != nil {
+			return err
+		}
+		if !current.UpdateTime.Equal(updated) {
+			return errUserChanged
+		}
+		for _, op := range ops {
+			ref := fs.edge(user, op.relationship, op.target)
+			if op.delete {
+				err = tx.Delete(ref)
+			} else {
+				err = tx.Set(ref, map[string]interface{}{scoreField: op.score})
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// VerifySubcollections compares every user's relationships in the two
+// layouts, returning the users whose relationships differ.  Each user is
+// compared in a read-only transaction, so writes during the verification
+// can't cause false mismatches.
+func (fs *Firestore) VerifySubcollections(ctx context.Context, progress func(user string)) (MigrationStats, error) {
+	var stats MigrationStats
+	err := fs.ScanUsers(ctx, "", func(user string) error {
+		n, match, err := fs.verifyUser(ctx, user)
+		if err != nil {
+			return fmt.Errorf("verifying user %s: %v", user, err)
+		}
+		stats.Users++
+		stats.Relationships += n
+		if !match {
+			stats.Mismatched = append(stats.Mismatched, user)
+		}
+		if progress != nil {
+			progress(user)
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// verifyUser compares one user's relationships in the two layouts, returning
+// how many relationships the user has in the document layout.
+func (fs *Firestore) verifyUser(ctx context.Context, user string) (count int, match bool, err error) {
+	// Subcollections can't be listed in a transaction, so list them first
+	colls, err := fs.doc(user).Collections(ctx).GetAll()
+	if err != nil {
+		return 0, false, err
+	}
+	err = fs.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docsnap, err := tx.Get(fs.doc(user))
+		if err != nil {
+			return err
+		}
+		nested := nestedRelationships(docsnap)
+		// Relationship types in either layout
+		types := map[string]bool{}
+		for rel := range nested {
+			types[rel] = true
+		}
+		for _, coll := range colls {
+			types[coll.ID] = true
+		}
+
+		count, match = 0, true
+		for rel := range types {
+			edges, err := readScores(tx.Documents(fs.doc(user).Collection(rel)))
+			if err != nil {
+				return err
+			}
+			count += len(nested[rel])
+			if !equalScores(nested[rel], edges) {
+				match = false
+			}
+		}
+		return nil
+	}, firestore.ReadOnly)
+	if fsError(err) == ErrNotFound {
+		return 0, true, nil
+	}
+	return count, match, err
+}
+
+// RemoveDocumentLayout deletes the relationships in the document layout,
+// once every instance uses SubcollectionLayout.  It refuses to if reads still
+// come from the document layout.
+func (fs *Firestore) RemoveDocumentLayout(ctx context.Context, progress func(user string)) (MigrationStats, error) {
+	var stats MigrationStats
+	reads, err := fs.ReadLayout(ctx)
+	if err != nil {
+		return stats, err
+	}
+	if reads != SubcollectionLayout {
+		return stats, errors.New("reads still come from the document layout")
+	}
+	err = fs.ScanUsers(ctx, "", func(user string) error {
+		docsnap, err := fs.get(ctx, user)
+		if err != nil {
+			if fsError(err) == ErrNotFound {
+				return nil
+			}
+			return err
+		}
+		var updates []firestore.Update
+		count := 0
+		for rel, scores := range nestedRelationships(docsnap) {
+			updates = append(updates, firestore.Update{FieldPath: firestore.FieldPath{rel}, Value: firestore.Delete})
+			count += len(scores)
+		}
+		stats.Users++
+		if len(updates) > 0 {
+			if _, err := fs.doc(user).Update(ctx, updates); err != nil {
+				return fmt.Errorf("cleaning up user %s: %v", user, err)
+			}
+			stats.Relationships += count
+		}
+		if progress != nil {
+			progress(user)
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// readSubcollections reads all of a user's relationships in the
+// subcollection layout, whether or not the user document exists.
+func (fs *Firestore) readSubcollections(ctx context.Context, user string) (map[string]Scores, error) {
+	colls, err := fs.doc(user).Collections(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	relationships := map[string]Scores{}
+	for _, coll := range colls {
+		scores, err := readScores(coll.Documents(ctx))
+		if err != nil {
+			return nil, err
+		}
+		relationships[coll.ID] = scores
+	}
+	return relationships, nil
+}
+
+// equalScores returns whether two sets of relationships are the same.
+func equalScores(a, b Scores) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for target, score := range a {
+		if other, ok := b[target]; !ok || other != score {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationOps(t *testing.T) {
+	assert := assert.New(t)
+	nested := map[string]Scores{
+		"friends": {"b": 1, "c": 2},
+		"blocked": {"d": 1},
+	}
+	edges := map[string]Scores{
+		"friends":   {"b": 1, "c": 3},
+		"followers": {"e": 1},
+	}
+	ops, count := migrationOps(nested, edges)
+	assert.Equal(3, count)
+	sort.Slice(ops, func(i, j int) bool { return ops[i].relationship+ops[i].target < ops[j].relationship+ops[j].target })
+	assert.Equal([]edgeOp{
+		{relationship: "blocked", target: "d", score: 1},
+		{relationship: "followers", target: "e", delete: true},
+		{relationship: "friends", target: "c", score: 2},
+	}, ops)
+
+	// Nothing is written once the layouts match
+	ops, count = migrationOps(nested, nested)
+	assert.Equal(3, count)
+	assert.Empty(ops)
+}
+
+func TestChunkOps(t *testing.T) {
+	ops := make([]edgeOp, 7)
+	tests := []struct {
+		n, size int
+		want    []int
+	}{
+		{0, 3, nil},
+		{2, 3, []int{2}},
+		{3, 3, []int{3}},
+		{7, 3, []int{3, 3, 1}},
+	}
+	for _, tt := range tests {
+		var sizes []int
+		for _, chunk := range chunkOps(ops[:tt.n], tt.size) {
+			sizes = append(sizes, len(chunk))
+		}
+		assert.Equal(t, tt.want, sizes, "%d ops in chunks of %d", tt.n, tt.size)
+	}
+}
+
+func TestFirestoreMigration(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	docs := newEmulatorFirestore(t, DocumentLayout)
+
+	// A user with more relationships than fit in one migration chunk
+	var writes []Write
+	for i := 0; i < migrationChunk+10; i++ {
+		writes = append(writes, Write{User: "a", Relationship: "friends", Target: fmt.Sprint(i), Op: Set, Value: 1})
+	}
+	_, err := docs.Write(ctx, writes...)
+	assert.Nil(err)
+	_, err = docs.Write(ctx, Write{User: "b", Relationship: "friends", Target: "a", Op: Set, Value: 2})
+	assert.Nil(err)
+	// A relationship only in the subcollection layout is removed by the copy
+	subs := withLayout(t, docs, SubcollectionLayout)
+	_, err = subs.edge("b", "blocked", "c").Set(ctx, map[string]interface{}{scoreField: int64(1)})
+	assert.Nil(err)
+
+	migrating := withLayout(t, docs, MigratingLayout)
+	stats, err := migrating.VerifySubcollections(ctx, nil)
+	assert.Nil(err)
+	assert.Equal([]string{"a", "b"}, stats.Mismatched)
+
+	stats, err = migrating.CopyToSubcollections(ctx, nil)
+	assert.Nil(err)
+	assert.Equal(2, stats.Users)
+	assert.Equal(migrationChunk+11, stats.Relationships)
+	stats, err = migrating.VerifySubcollections(ctx, nil)
+	assert.Nil(err)
+	assert.Empty(stats.Mismatched)
+
+	// Writes while migrating are made in both layouts
+	_, err = migrating.Write(ctx,
+		Write{User: "b", Relationship: "friends", Target: "c", Op: Increment, Value: 3},
+		Write{User: "b", Relationship: "friends", Target: "a", Op: Delete},
+	)
+	assert.Nil(err)
+	stats, err = migrating.VerifySubcollections(ctx, nil)
+	assert.Nil(err)
+	assert.Empty(stats.Mismatched)
+
+	// The document layout can only be removed once reads come from the
+	// subcollections
+	_, err = migrating.RemoveDocumentLayout(ctx, nil)
+	assert.NotNil(err)
+	assert.Nil(migrating.SetReadLayout(ctx, SubcollectionLayout))
+	migrating.readsLoaded = migrating.readsLoaded.Add(-layoutRefresh)
+	assert.Equal(SubcollectionLayout, migrating.readLayout(ctx))
+	stats, err = migrating.RemoveDocumentLayout(ctx, nil)
+	assert.Nil(err)
+	assert.Equal(migrationChunk+11, stats.Relationships)
+
+	relationships, _, err := subs.GetUser(ctx, "b")
+	assert.Nil(err)
+	assert.Equal(map[string]Scores{"friends": {"c": 3}}, relationships)
+	relationships, _, err = docs.GetUser(ctx, "b")
+	assert.Nil(err)
+	assert.Empty(relationships)
+	scores, _, err := subs.GetRelationships(ctx, "a", "friends")
+	assert.Nil(err)
+	assert.Len(scores, migrationChunk+10)
+}
+
+func TestFirestoreCopyChunkUserChanged(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	fs := newEmulatorFirestore(t, MigratingLayout)
+
+	_, err := fs.Write(ctx, Write{User: "a", Relationship: "friends", Target: "b", Op: Set, Value: 1})
+	assert.Nil(err)
+	docsnap, err := fs.get(ctx, "a")
+	assert.Nil(err)
+	_, err = fs.Write(ctx, Write{User: "a", Relationship: "friends", Target: "c", Op: Set, Value: 1})
+	assert.Nil(err)
+
+	// A chunk read before the user changed isn't written
+	ops := []edgeOp{{relationship: "friends", target: "d", score: 1}}
+	assert.Equal(errUserChanged, fs.copyChunk(ctx, "a", docsnap.UpdateTime, ops))
+	edges, err := fs.readSubcollections(ctx, "a")
+	assert.Nil(err)
+	assert.Equal(map[string]Scores{"friends": {"b": 1, "c": 1}}, edges)
+
+	// Copying the user reads it again
+	n, err := fs.copyUser(ctx, "a")
+	assert.Nil(err)
+	assert.Equal(2, n)
+}
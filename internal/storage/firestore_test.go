@@ -0,0 +1,89 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelationshipPath(t *testing.T) {
+	tests := []struct {
+		relationship string
+		target       []string
+		want         firestore.FieldPath
+	}{
+		{"friends", nil, firestore.FieldPath{"friends"}},
+		{"friends", []string{"b"}, firestore.FieldPath{"friends", "b"}},
+		// User IDs aren't split or escaped
+		{"friends", []string{"b.c"}, firestore.FieldPath{"friends", "b.c"}},
+		{"friends", []string{"`b`"}, firestore.FieldPath{"friends", "`b`"}},
+		{"friends", []string{"b c"}, firestore.FieldPath{"friends", "b c"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, relationshipPath(tt.relationship, tt.target...), "%s %v", tt.relationship, tt.target)
+	}
+}
+
+func TestFirestoreFieldReads(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	fs := newEmulatorFirestore(t, DocumentLayout)
+
+	_, err := fs.Write(ctx,
+		Write{User: "a", Relationship: "friends", Target: "b", Op: Set, Value: 1},
+		Write{User: "a", Relationship: "friends", Target: "c.d", Op: Set, Value: 2},
+		Write{User: "a", Relationship: "friends", Target: "`e`", Op: Set, Value: 3},
+		Write{User: "a", Relationship: "blocks", Target: "f", Op: Set, Value: 4},
+	)
+	assert.Nil(err)
+	_, userVersion, err := fs.GetUser(ctx, "a")
+	assert.Nil(err)
+
+	// Only the type's field is read, at the same version as the whole user
+	scores, v, err := fs.GetRelationships(ctx, "a", "friends")
+	assert.Nil(err)
+	assert.Equal(Scores{"b": 1, "c.d": 2, "`e`": 3}, scores)
+	assert.Equal(userVersion, v)
+	for target, want := range scores {
+		score, v, err := fs.GetScore(ctx, "a", "friends", target)
+		assert.Nil(err, target)
+		assert.Equal(want, score, target)
+		assert.Equal(userVersion, v, target)
+	}
+
+	tests := []struct {
+		name                       string
+		user, relationship, target string
+	}{
+		{"missing user", "z", "friends", "b"},
+		{"missing type", "a", "followers", "b"},
+		{"missing target", "a", "friends", "z"},
+		// The first part of the target isn't read as a field of its own
+		{"partial target", "a", "friends", "c"},
+		{"other type's target", "a", "friends", "f"},
+	}
+	for _, tt := range tests {
+		_, _, err := fs.GetScore(ctx, tt.user, tt.relationship, tt.target)
+		assert.Equal(ErrNotFound, err, tt.name)
+	}
+	_, _, err = fs.GetRelationships(ctx, "z", "friends")
+	assert.Equal(ErrNotFound, err)
+	_, _, err = fs.GetRelationships(ctx, "a", "followers")
+	assert.Equal(ErrNotFound, err)
+}
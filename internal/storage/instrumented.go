@@ -0,0 +1,107 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/joeholley/tomolink/internal/metrics"
+)
+
+// instrumented wraps an Engine, recording the latency and errors of every
+// operation.
+type instrumented struct {
+	Engine
+}
+
+// Instrument returns an Engine that records metrics for every operation on e.
+func Instrument(e Engine) Engine {
+	return &instrumented{Engine: e}
+}
+
+// observe records the metrics for one operation. Missing data and refused
+// writes are expected outcomes, so they aren't counted as errors.
+func (i *instrumented) observe(operation string, start time.Time, err error) {
+	var capErr *CapExceededError
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrIdempotencyKeyReused) ||
+		errors.Is(err, ErrVersionMismatch) || errors.Is(err, ErrConditionFailed) || errors.As(err, &capErr) {
+		err = nil
+	}
+	metrics.ObserveStorage(i.Engine.Name(), operation, start, err)
+}
+
+func (i *instrumented) GetUser(ctx context.Context, user string) (relationships map[string]Scores, version string, err error) {
+	defer func(start time.Time) { i.observe("GetUser", start, err) }(time.Now())
+	return i.Engine.GetUser(ctx, user)
+}
+
+func (i *instrumented) GetRelationships(ctx context.Context, user, relationship string) (scores Scores, version string, err error) {
+	defer func(start time.Time) { i.observe("GetRelationships", start, err) }(time.Now())
+	return i.Engine.GetRelationships(ctx, user, relationship)
+}
+
+func (i *instrumented) GetScore(ctx context.Context, user, relationship, target string) (score int64, version string, err error) {
+	defer func(start time.Time) { i.observe("GetScore", start, err) }(time.Now())
+	return i.Engine.GetScore(ctx, user, relationship, target)
+}
+
+func (i *instrumented) Write(ctx context.Context, writes ...Write) (_ WriteResult, err error) {
+	defer func(start time.Time) { i.observe("Write", start, err) }(time.Now())
+	return i.Engine.Write(ctx, writes...)
+}
+
+func (i *instrumented) WriteOnce(ctx context.Context, key string, ttl time.Duration, writes ...Write) (_ WriteResult, err error) {
+	defer func(start time.Time) { i.observe("WriteOnce", start, err) }(time.Now())
+	return i.Engine.WriteOnce(ctx, key, ttl, writes...)
+}
+
+func (i *instrumented) DeleteUser(ctx context.Context, user string) (err error) {
+	defer func(start time.Time) { i.observe("DeleteUser", start, err) }(time.Now())
+	return i.Engine.DeleteUser(ctx, user)
+}
+
+// ScanUsers is recorded as a single operation, however many users it
+// covers.
+func (i *instrumented) ScanUsers(ctx context.Context, after string, f func(user string) error) (err error) {
+	defer func(start time.Time) { i.observe("ScanUsers", start, err) }(time.Now())
+	return i.Engine.ScanUsers(ctx, after, f)
+}
+
+func (i *instrumented) GetDefinitions(ctx context.Context) (definitions map[string]Definition, version string, err error) {
+	defer func(start time.Time) { i.observe("GetDefinitions", start, err) }(time.Now())
+	return i.Engine.GetDefinitions(ctx)
+}
+
+func (i *instrumented) SetDefinitions(ctx context.Context, definitions map[string]Definition, ifVersion string) (err error) {
+	defer func(start time.Time) { i.observe("SetDefinitions", start, err) }(time.Now())
+	return i.Engine.SetDefinitions(ctx, definitions, ifVersion)
+}
+
+func (i *instrumented) GetJob(ctx context.Context, id string) (job Job, version string, err error) {
+	defer func(start time.Time) { i.observe("GetJob", start, err) }(time.Now())
+	return i.Engine.GetJob(ctx, id)
+}
+
+func (i *instrumented) SetJob(ctx context.Context, id string, job Job, ifVersion string) (err error) {
+	defer func(start time.Time) { i.observe("SetJob", start, err) }(time.Now())
+	return i.Engine.SetJob(ctx, id, job, ifVersion)
+}
+
+func (i *instrumented) Ping(ctx context.Context) (err error) {
+	defer func(start time.Time) { i.observe("Ping", start, err) }(time.Now())
+	return i.Engine.Ping(ctx)
+}
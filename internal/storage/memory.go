@@ -0,0 +1,356 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Memory is an Engine that keeps relationships in memory, for local
+// development and tests.  Nothing is persisted, and each Tomolink instance
+// has its own data.
+type Memory struct {
+	mu    sync.Mutex
+	users map[string]*memoryUser
+	keys  map[string]keyRecord
+	// The stored relationship type definitions, and the counter value when
+	// they were set
+	definitions        map[string]Definition
+	definitionsVersion int64
+	// The jobs stored with SetJob, by ID
+	jobs map[string]memoryJob
+	// Incremented on every write, to give each version a unique number
+	counter int64
+	// Returns the current time; replaced in tests
+	now func() time.Time
+}
+
+// expiredKeysInterval is how many writes Memory makes between removing
+// expired idempotency keys.
+const expiredKeysInterval = 1000
+
+// memoryJob holds a job stored with SetJob, and the counter value when it was
+// stored.
+type memoryJob struct {
+	job     Job
+	version int64
+}
+
+// memoryUser holds one user's relationships, like a Firestore user document.
+type memoryUser struct {
+	relationships map[string]Scores
+	version       int64
+}
+
+// NewMemory returns an empty Memory engine.
+func NewMemory() *Memory {
+	return &Memory{
+		users: map[string]*memoryUser{},
+		keys:  map[string]keyRecord{},
+		jobs:  map[string]memoryJob{},
+		now:   time.Now,
+	}
+}
+
+// Name returns "memory".
+func (m *Memory) Name() string {
+	return "memory"
+}
+
+// GetUser returns all of a user's relationships.
+func (m *Memory) GetUser(ctx context.Context, user string) (map[string]Scores, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[user]
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+	relationships := make(map[string]Scores, len(u.relationships))
+	for rel, scores := range u.relationships {
+		relationships[rel] = copyScores(scores)
+	}
+	return relationships, u.versionString(), nil
+}
+
+// GetRelationships returns a user's relationships of one type.
+func (m *Memory) GetRelationships(ctx context.Context, user, relationship string) (Scores, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[user]
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+	scores, ok := u.relationships[relationship]
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+	return copyScores(scores), u.versionString(), nil
+}
+
+// GetScore returns the score of a single relationship.
+func (m *Memory) GetScore(ctx context.Context, user, relationship, target string) (int64, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[user]
+	if !ok {
+		return 0, "", ErrNotFound
+	}
+	score, ok := u.relationships[relationship][target]
+	if !ok {
+		return 0, "", ErrNotFound
+	}
+	return score, u.versionString(), nil
+}
+
+// Write checks the versions and caps, and then applies all the writes.
+func (m *Memory) Write(ctx context.Context, writes ...Write) (WriteResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.write(writes)
+}
+
+// WriteOnce applies the writes unless the idempotency key has already been
+// used.
+func (m *Memory) WriteOnce(ctx context.Context, key string, ttl time.Duration, writes ...Write) (WriteResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rec, ok := m.keys[key]; ok && m.now().Before(rec.Expires) {
+		if rec.Fingerprint != fingerprint(writes) {
+			return WriteResult{}, ErrIdempotencyKeyReused
+		}
+		return WriteResult{Time: rec.Written, Replayed: true}, nil
+	}
+	result, err := m.write(writes)
+	if err != nil {
+		return WriteResult{}, err
+	}
+	m.keys[key] = keyRecord{Fingerprint: fingerprint(writes), Expires: m.now().Add(ttl), Written: result.Time}
+
+	// Clear out expired keys every so often, so they don't build up forever
+	if m.counter%expiredKeysInterval == 0 {
+		for k, rec := range m.keys {
+			if !m.now().Before(rec.Expires) {
+				delete(m.keys, k)
+			}
+		}
+	}
+	return result, nil
+}
+
+// write makes the writes.  Everything is checked before anything is changed,
+// so either all the writes are applied or none of them are.  As with
+// Firestore, conditional writes are checked against the scores from before
+// any of the writes.  m.mu must be held.
+func (m *Memory) write(writes []Write) (WriteResult, error) {
+	resolved := make([]Write, len(writes))
+	added := addedTargets{}
+	for i, w := range writes {
+		u, ok := m.users[w.User]
+		if w.IfVersion != "" {
+			if !ok || (w.IfVersion != AnyVersion && w.IfVersion != u.versionString()) {
+				return WriteResult{}, ErrVersionMismatch
+			}
+		}
+		var existing Scores
+		if ok {
+			existing = u.relationships[w.Relationship]
+		}
+
+		resolved[i] = w
+		if w.conditional() {
+			current, exists := existing[w.Target]
+			for _, old := range w.oldNames {
+				if ok {
+					score, oldExists := u.relationships[old][w.Target]
+					current, exists = higherScore(current, exists, score, oldExists)
+				}
+			}
+			r, err := w.resolve(current, exists)
+			if err != nil {
+				return WriteResult{}, err
+			}
+			resolved[i] = r
+		}
+
+		if w.Cap <= 0 || resolved[i].Op == Delete {
+			continue
+		}
+		if _, ok := existing[w.Target]; ok || added.has(w.User, w.Relationship, w.Target) {
+			continue
+		}
+		if len(existing)+added.count(w.User, w.Relationship) >= w.Cap {
+			return WriteResult{}, &CapExceededError{User: w.User, Relationship: w.Relationship, Max: w.Cap}
+		}
+		added.add(w.User, w.Relationship, w.Target)
+	}
+
+	m.counter++
+	for _, w := range resolved {
+		u, ok := m.users[w.User]
+		if !ok {
+			u = &memoryUser{relationships: map[string]Scores{}}
+			m.users[w.User] = u
+		}
+		u.version = m.counter
+		scores, ok := u.relationships[w.Relationship]
+		if !ok {
+			scores = Scores{}
+			u.relationships[w.Relationship] = scores
+		}
+		switch w.Op {
+		case Increment:
+			scores[w.Target] += w.Value
+		case Delete:
+			delete(scores, w.Target)
+		default:
+			scores[w.Target] = w.Value
+		}
+	}
+	return WriteResult{Time: m.now()}, nil
+}
+
+// DeleteUser removes the user.
+func (m *Memory) DeleteUser(ctx context.Context, user string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.users, user)
+	return nil
+}
+
+// ScanUsers calls f with the users there were when the scan started.  f is
+// called without the lock held, so it can use the engine.
+func (m *Memory) ScanUsers(ctx context.Context, after string, f func(user string) error) error {
+	m.mu.Lock()
+	var users []string
+	for user := range m.users {
+		if user > after {
+			users = append(users, user)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Strings(users)
+	for _, user := range users {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := f(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDefinitions returns a copy of the stored definitions.
+func (m *Memory) GetDefinitions(ctx context.Context) (map[string]Definition, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	definitions := make(map[string]Definition, len(m.definitions))
+	for name, d := range m.definitions {
+		definitions[name] = d
+	}
+	if m.definitionsVersion == 0 {
+		return definitions, "", nil
+	}
+	return definitions, strconv.FormatInt(m.definitionsVersion, 10), nil
+}
+
+// SetDefinitions stores a copy of the definitions.
+func (m *Memory) SetDefinitions(ctx context.Context, definitions map[string]Definition, ifVersion string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	version := ""
+	if m.definitionsVersion != 0 {
+		version = strconv.FormatInt(m.definitionsVersion, 10)
+	}
+	if ifVersion != version {
+		return ErrVersionMismatch
+	}
+	m.definitions = make(map[string]Definition, len(definitions))
+	for name, d := range definitions {
+		m.definitions[name] = d
+	}
+	m.counter++
+	m.definitionsVersion = m.counter
+	return nil
+}
+
+// MaxWrites doesn't limit Memory, but batches writes like Firestore's
+// document layout, so tests exercise the batching.
+func (m *Memory) MaxWrites() int {
+	return maxTransactionWrites - 1
+}
+
+// GetJob returns a copy of the stored job.
+func (m *Memory) GetJob(ctx context.Context, id string) (Job, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.jobs[id]
+	if !ok || m.now().After(stored.job.Expires) {
+		return Job{}, "", ErrNotFound
+	}
+	job := stored.job
+	job.Status = append([]byte(nil), job.Status...)
+	return job, strconv.FormatInt(stored.version, 10), nil
+}
+
+// SetJob stores a copy of the job, and removes expired jobs.
+func (m *Memory) SetJob(ctx context.Context, id string, job Job, ifVersion string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	for other, stored := range m.jobs {
+		if now.After(stored.job.Expires) {
+			delete(m.jobs, other)
+		}
+	}
+	version := ""
+	if stored, ok := m.jobs[id]; ok {
+		version = strconv.FormatInt(stored.version, 10)
+	}
+	if ifVersion != version {
+		return ErrVersionMismatch
+	}
+	job.Status = append([]byte(nil), job.Status...)
+	m.counter++
+	m.jobs[id] = memoryJob{job: job, version: m.counter}
+	return nil
+}
+
+// Ping always succeeds.
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing.
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (u *memoryUser) versionString() string {
+	return strconv.FormatInt(u.version, 10)
+}
+
+func copyScores(s Scores) Scores {
+	c := make(Scores, len(s))
+	for target, score := range s {
+		c[target] = score
+	}
+	return c
+}
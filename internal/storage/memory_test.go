@@ -0,0 +1,257 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryWrite(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	m := NewMemory()
+
+	_, _, err := m.GetUser(ctx, "a")
+	assert.Equal(ErrNotFound, err)
+
+	_, err = m.Write(ctx,
+		Write{User: "a", Relationship: "friends", Target: "b", Op: Set, Value: 5},
+		Write{User: "b", Relationship: "friends", Target: "a", Op: Set, Value: 5},
+	)
+	assert.Nil(err)
+	_, err = m.Write(ctx, Write{User: "a", Relationship: "friends", Target: "b", Op: Increment, Value: 2})
+	assert.Nil(err)
+	score, _, err := m.GetScore(ctx, "a", "friends", "b")
+	assert.Nil(err)
+	assert.Equal(int64(7), score)
+
+	_, err = m.Write(ctx, Write{User: "a", Relationship: "friends", Target: "b", Op: Delete})
+	assert.Nil(err)
+	_, _, err = m.GetScore(ctx, "a", "friends", "b")
+	assert.Equal(ErrNotFound, err)
+	scores, _, err := m.GetRelationships(ctx, "b", "friends")
+	assert.Nil(err)
+	assert.Equal(Scores{"a": 5}, scores)
+
+	// Caps are checked before anything is written
+	_, err = m.Write(ctx,
+		Write{User: "c", Relationship: "friends", Target: "b", Op: Set, Value: 1},
+		Write{User: "b", Relationship: "friends", Target: "c", Op: Set, Value: 1, Cap: 1},
+	)
+	var capErr *CapExceededError
+	assert.True(errors.As(err, &capErr))
+	_, _, err = m.GetUser(ctx, "c")
+	assert.Equal(ErrNotFound, err)
+
+	// Relationships added earlier in the same write count towards the cap
+	_, err = m.Write(ctx,
+		Write{User: "d", Relationship: "friends", Target: "a", Op: Set, Value: 1, Cap: 2},
+		Write{User: "d", Relationship: "friends", Target: "b", Op: Set, Value: 1, Cap: 2},
+		Write{User: "d", Relationship: "friends", Target: "c", Op: Set, Value: 1, Cap: 2},
+	)
+	assert.True(errors.As(err, &capErr))
+	_, err = m.Write(ctx,
+		Write{User: "d", Relationship: "friends", Target: "a", Op: Set, Value: 1, Cap: 1},
+		Write{User: "d", Relationship: "friends", Target: "a", Op: Increment, Value: 1, Cap: 1},
+	)
+	assert.Nil(err)
+}
+
+func TestMemoryConditionalWrites(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	m := NewMemory()
+	ceiling := int64(10)
+
+	steps := []struct {
+		write Write
+		err   error
+		score int64
+	}{
+		{Write{Op: CompareAndSet, Expected: 0, Value: 1}, ErrConditionFailed, 0},
+		{Write{Op: SetIfMissing, Value: 2}, nil, 2},
+		{Write{Op: SetIfMissing, Value: 5}, nil, 2},
+		{Write{Op: Max, Value: 3}, nil, 3},
+		{Write{Op: Max, Value: 2}, nil, 3},
+		{Write{Op: CompareAndSet, Expected: 4, Value: 8}, ErrConditionFailed, 3},
+		{Write{Op: CompareAndSet, Expected: 3, Value: 8}, nil, 8},
+		{Write{Op: Increment, Value: 5, Ceiling: &ceiling}, nil, 10},
+		{Write{Op: Increment, Value: -10, DeleteIfNotPositive: true}, nil, 0},
+	}
+	for i, step := range steps {
+		w := step.write
+		w.User, w.Relationship, w.Target = "a", "friends", "b"
+		_, err := m.Write(ctx, w)
+		assert.Equal(step.err, err, "step %d", i)
+		score, _, _ := m.GetScore(ctx, "a", "friends", "b")
+		assert.Equal(step.score, score, "step %d", i)
+	}
+	_, _, err := m.GetScore(ctx, "a", "friends", "b")
+	assert.Equal(ErrNotFound, err)
+}
+
+func TestMemoryVersions(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	m := NewMemory()
+
+	// A user that doesn't exist doesn't match any version
+	_, err := m.Write(ctx, Write{User: "a", Relationship: "friends", Target: "b", IfVersion: AnyVersion})
+	assert.Equal(ErrVersionMismatch, err)
+
+	_, err = m.Write(ctx, Write{User: "a", Relationship: "friends", Target: "b", Value: 1})
+	assert.Nil(err)
+	_, v1, err := m.GetUser(ctx, "a")
+	assert.Nil(err)
+
+	_, err = m.Write(ctx, Write{User: "a", Relationship: "friends", Target: "b", Value: 2, IfVersion: v1})
+	assert.Nil(err)
+	_, v2, err := m.GetScore(ctx, "a", "friends", "b")
+	assert.Nil(err)
+	assert.NotEqual(v1, v2)
+
+	_, err = m.Write(ctx, Write{User: "a", Relationship: "friends", Target: "b", Value: 3, IfVersion: v1})
+	assert.Equal(ErrVersionMismatch, err)
+	score, _, _ := m.GetScore(ctx, "a", "friends", "b")
+	assert.Equal(int64(2), score)
+}
+
+func TestMemoryWriteOnce(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	m := NewMemory()
+	now := time.Unix(1577836800, 0)
+	m.now = func() time.Time { return now }
+	inc := Write{User: "a", Relationship: "friends", Target: "b", Op: Increment, Value: 1}
+
+	result, err := m.WriteOnce(ctx, "k", time.Hour, inc)
+	assert.Nil(err)
+	assert.Equal(WriteResult{Time: now}, result)
+	// Replays return the time of the original write
+	now = now.Add(time.Minute)
+	result, err = m.WriteOnce(ctx, "k", time.Hour, inc)
+	assert.Nil(err)
+	assert.Equal(WriteResult{Time: now.Add(-time.Minute), Replayed: true}, result)
+	score, _, _ := m.GetScore(ctx, "a", "friends", "b")
+	assert.Equal(int64(1), score)
+
+	// The same key can't be used for a different write
+	_, err = m.WriteOnce(ctx, "k", time.Hour, Write{User: "a", Relationship: "friends", Target: "c", Op: Increment, Value: 1})
+	assert.Equal(ErrIdempotencyKeyReused, err)
+
+	// Once the key expires, it can be used again
+	now = now.Add(time.Hour)
+	result, err = m.WriteOnce(ctx, "k", time.Hour, inc)
+	assert.Nil(err)
+	assert.False(result.Replayed)
+	score, _, _ = m.GetScore(ctx, "a", "friends", "b")
+	assert.Equal(int64(2), score)
+}
+
+func TestMemoryDefinitions(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	m := NewMemory()
+
+	definitions, version, err := m.GetDefinitions(ctx)
+	assert.Nil(err)
+	assert.Empty(definitions)
+	assert.Equal("", version)
+
+	// Definitions are only replaced if they haven't changed since they were read
+	assert.Nil(m.SetDefinitions(ctx, map[string]Definition{"likes": {Type: "score", Merge: "sum"}}, ""))
+	assert.Equal(ErrVersionMismatch, m.SetDefinitions(ctx, map[string]Definition{}, ""))
+	definitions, version, err = m.GetDefinitions(ctx)
+	assert.Nil(err)
+	assert.Equal(map[string]Definition{"likes": {Type: "score", Merge: "sum"}}, definitions)
+	assert.NotEqual("", version)
+
+	definitions["likes"] = Definition{Type: "score", Deprecated: true}
+	assert.Nil(m.SetDefinitions(ctx, definitions, version))
+	definitions, _, err = m.GetDefinitions(ctx)
+	assert.Nil(err)
+	assert.True(definitions["likes"].Deprecated)
+}
+
+func TestMemoryJobs(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	m := NewMemory()
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	_, _, err := m.GetJob(ctx, "deletion/a")
+	assert.Equal(ErrNotFound, err)
+
+	// Jobs are only replaced if they haven't changed since they were read
+	assert.Nil(m.SetJob(ctx, "deletion/a", Job{Status: []byte(`{"status":"running"}`), Expires: now.Add(time.Hour)}, ""))
+	assert.Equal(ErrVersionMismatch, m.SetJob(ctx, "deletion/a", Job{Status: []byte(`{}`), Expires: now.Add(time.Hour)}, ""))
+	job, version, err := m.GetJob(ctx, "deletion/a")
+	assert.Nil(err)
+	assert.Equal(`{"status":"running"}`, string(job.Status))
+	assert.NotEqual("", version)
+	assert.Nil(m.SetJob(ctx, "deletion/a", Job{Status: []byte(`{"status":"done"}`), Expires: now.Add(time.Hour)}, version))
+
+	// Expired jobs are forgotten
+	now = now.Add(2 * time.Hour)
+	_, _, err = m.GetJob(ctx, "deletion/a")
+	assert.Equal(ErrNotFound, err)
+	assert.Nil(m.SetJob(ctx, "deletion/a", Job{Status: []byte(`{}`), Expires: now.Add(time.Hour)}, ""))
+}
+
+// testWriteTimes checks every kind of write reports when it was committed.
+func testWriteTimes(t *testing.T, e Engine) {
+	ctx := context.Background()
+	// The user has to exist for the version check
+	_, err := e.Write(ctx, Write{User: "a", Relationship: "friends", Target: "b", Op: Set, Value: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		w    Write
+	}{
+		{"batch", Write{Op: Increment, Value: 1}},
+		{"capped", Write{Op: Set, Value: 1, Cap: 10}},
+		{"conditional", Write{Op: Max, Value: 5}},
+		{"if version", Write{Op: Increment, Value: 1, IfVersion: AnyVersion}},
+	}
+	for i, tt := range tests {
+		w := tt.w
+		w.User, w.Relationship, w.Target = "a", "friends", fmt.Sprint(i)
+		result, err := e.Write(ctx, w)
+		assert.Nil(t, err, tt.name)
+		assert.False(t, result.Time.IsZero(), tt.name)
+	}
+
+	w := Write{User: "a", Relationship: "friends", Target: "c", Op: Increment, Value: 1}
+	result, err := e.WriteOnce(ctx, "key", time.Hour, w)
+	assert.Nil(t, err)
+	assert.False(t, result.Time.IsZero())
+	replayed, err := e.WriteOnce(ctx, "key", time.Hour, w)
+	assert.Nil(t, err)
+	assert.True(t, replayed.Replayed)
+	assert.True(t, result.Time.Equal(replayed.Time))
+}
+
+func TestMemoryWriteTimes(t *testing.T) {
+	testWriteTimes(t, NewMemory())
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// setScript stores an entry in a user's hash, and sets the hash to expire
+// when it is first created.  The expiry isn't pushed back by later entries,
+// so no entry outlives the TTL, however often the user is read.
+//   KEYS[1]: user hash key
+//   ARGV[1]: entry key
+//   ARGV[2]: entry value
+//   ARGV[3]: TTL in milliseconds
+var setScript = redis.NewScript(1, `
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+if redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return 1
+`)
+
+// RedisCache is a Cache shared by all the Tomolink instances using the same
+// Redis server.  Each user's entries are kept in a hash, so they can be
+// invalidated with a single command.
+type RedisCache struct {
+	pool   *redis.Pool
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedisCache returns a RedisCache using the Redis server at address, whose
+// entries expire after ttl.  Connections are made lazily, so an unreachable
+// server shows up as errors from the Cache methods.  Keys are prefixed with
+// prefix.
+func NewRedisCache(address, password, prefix string, ttl time.Duration) *RedisCache {
+	return &RedisCache{
+		pool: &redis.Pool{
+			MaxIdle:     16,
+			IdleTimeout: 240 * time.Second,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", address,
+					redis.DialPassword(password),
+					redis.DialConnectTimeout(time.Second),
+					redis.DialReadTimeout(time.Second),
+					redis.DialWriteTimeout(time.Second))
+			},
+		},
+		prefix: prefix,
+		ttl:    ttl,
+	}
+}
+
+// Name returns "redis".
+func (rc *RedisCache) Name() string {
+	return "redis"
+}
+
+// Get returns the entry stored under key for user, if there is one.
+func (rc *RedisCache) Get(ctx context.Context, user, key string) ([]byte, bool, error) {
+	conn, err := rc.pool.GetContext(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+	value, err := redis.Bytes(conn.Do("HGET", rc.prefix+user, key))
+	if err == redis.ErrNil {
+		return nil, false, nil
+	}
+	return value, err == nil, err
+}
+
+// Set stores an entry under key for user.
+func (rc *RedisCache) Set(ctx context.Context, user, key string, value []byte) error {
+	conn, err := rc.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = setScript.Do(conn, rc.prefix+user, key, value, rc.ttl.Milliseconds())
+	return err
+}
+
+// Invalidate removes all of user's entries.
+func (rc *RedisCache) Invalidate(ctx context.Context, user string) error {
+	conn, err := rc.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Do("DEL", rc.prefix+user)
+	return err
+}
+
+// Close releases the connections to Redis.
+func (rc *RedisCache) Close() error {
+	return rc.pool.Close()
+}
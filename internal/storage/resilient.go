@@ -0,0 +1,349 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/joeholley/tomolink/internal/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ResilienceOptions configures the retries and circuit breaker added by
+// Resilient.
+type ResilienceOptions struct {
+	// The most times each operation is tried.  1 or less turns retries off.
+	Attempts int
+	// How long to wait before the first retry.  The wait doubles with each
+	// retry, up to MaxBackoff, and a random amount of it is used so retries
+	// from many requests don't arrive together.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// How long each attempt can take, within the request's own deadline.  0
+	// means only the request's deadline applies.
+	AttemptTimeout time.Duration
+	// How many operations in a row have to fail with transient errors to open
+	// the circuit breaker.  0 turns the breaker off.
+	BreakerFailures int
+	// How long the breaker stays open before letting a trial operation
+	// through.  If that succeeds the breaker closes, and if not it stays open
+	// for another BreakerCooldown.
+	BreakerCooldown time.Duration
+}
+
+// resilient wraps an Engine, retrying operations that fail with transient
+// errors when it is safe to, and failing fast with ErrUnavailable while the
+// database is unhealthy.
+type resilient struct {
+	Engine
+	opts    ResilienceOptions
+	breaker breaker
+}
+
+// Resilient returns an Engine that retries e's operations and stops calling
+// e while it keeps failing, as configured by opts.  Ping and Close are passed
+// straight through, so health checks see the state of the database itself.
+func Resilient(e Engine, opts ResilienceOptions) Engine {
+	return &resilient{
+		Engine: e,
+		opts:   opts,
+		breaker: breaker{
+			engine:    e.Name(),
+			threshold: opts.BreakerFailures,
+			cooldown:  opts.BreakerCooldown,
+			now:       time.Now,
+		},
+	}
+}
+
+func (r *resilient) GetUser(ctx context.Context, user string) (relationships map[string]Scores, version string, err error) {
+	err = r.do(ctx, "GetUser", alwaysRetry, func(ctx context.Context) error {
+		relationships, version, err = r.Engine.GetUser(ctx, user)
+		return err
+	})
+	return relationships, version, err
+}
+
+func (r *resilient) GetRelationships(ctx context.Context, user, relationship string) (scores Scores, version string, err error) {
+	err = r.do(ctx, "GetRelationships", alwaysRetry, func(ctx context.Context) error {
+		scores, version, err = r.Engine.GetRelationships(ctx, user, relationship)
+		return err
+	})
+	return scores, version, err
+}
+
+func (r *resilient) GetScore(ctx context.Context, user, relationship, target string) (score int64, version string, err error) {
+	err = r.do(ctx, "GetScore", alwaysRetry, func(ctx context.Context) error {
+		score, version, err = r.Engine.GetScore(ctx, user, relationship, target)
+		return err
+	})
+	return score, version, err
+}
+
+// Write is only retried if applying the writes twice has the same result as
+// applying them once, or if the error shows they weren't applied.
+func (r *resilient) Write(ctx context.Context, writes ...Write) (result WriteResult, err error) {
+	retry := notApplied
+	if repeatable(writes) {
+		retry = alwaysRetry
+	}
+	err = r.do(ctx, "Write", retry, func(ctx context.Context) error {
+		result, err = r.Engine.Write(ctx, writes...)
+		return err
+	})
+	return result, err
+}
+
+// WriteOnce is always safe to retry, as the idempotency key stops the writes
+// being applied twice.
+func (r *resilient) WriteOnce(ctx context.Context, key string, ttl time.Duration, writes ...Write) (result WriteResult, err error) {
+	err = r.do(ctx, "WriteOnce", alwaysRetry, func(ctx context.Context) error {
+		result, err = r.Engine.WriteOnce(ctx, key, ttl, writes...)
+		return err
+	})
+	return result, err
+}
+
+// DeleteUser is always safe to retry, as deleting a user twice has the same
+// result as deleting them once.
+func (r *resilient) DeleteUser(ctx context.Context, user string) error {
+	return r.do(ctx, "DeleteUser", alwaysRetry, func(ctx context.Context) error {
+		return r.Engine.DeleteUser(ctx, user)
+	})
+}
+
+// ScanUsers is retried from the last user f was called with, so each user is
+// only passed to f once.  Scans take much longer than other operations, so
+// they aren't limited by AttemptTimeout or counted by the circuit breaker,
+// and errors from f aren't retried.
+func (r *resilient) ScanUsers(ctx context.Context, after string, f func(user string) error) error {
+	for attempt := 1; ; attempt++ {
+		var fErr error
+		err := r.Engine.ScanUsers(ctx, after, func(user string) error {
+			if fErr = f(user); fErr != nil {
+				return fErr
+			}
+			after = user
+			return nil
+		})
+		if err == nil || fErr != nil || !transient(err) || ctx.Err() != nil || attempt >= r.opts.Attempts {
+			return err
+		}
+		metrics.ObserveStorageRetry(r.Engine.Name(), "ScanUsers")
+		select {
+		case <-time.After(r.backoff(attempt)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (r *resilient) GetDefinitions(ctx context.Context) (definitions map[string]Definition, version string, err error) {
+	err = r.do(ctx, "GetDefinitions", alwaysRetry, func(ctx context.Context) error {
+		definitions, version, err = r.Engine.GetDefinitions(ctx)
+		return err
+	})
+	return definitions, version, err
+}
+
+// SetDefinitions is only retried if the error shows the definitions weren't
+// stored, as a retry after they were would fail the version check.
+func (r *resilient) SetDefinitions(ctx context.Context, definitions map[string]Definition, ifVersion string) error {
+	return r.do(ctx, "SetDefinitions", notApplied, func(ctx context.Context) error {
+		return r.Engine.SetDefinitions(ctx, definitions, ifVersion)
+	})
+}
+
+func (r *resilient) GetJob(ctx context.Context, id string) (job Job, version string, err error) {
+	err = r.do(ctx, "GetJob", alwaysRetry, func(ctx context.Context) error {
+		job, version, err = r.Engine.GetJob(ctx, id)
+		return err
+	})
+	return job, version, err
+}
+
+// SetJob is only retried if the error shows the job wasn't stored, like
+// SetDefinitions.
+func (r *resilient) SetJob(ctx context.Context, id string, job Job, ifVersion string) error {
+	return r.do(ctx, "SetJob", notApplied, func(ctx context.Context) error {
+		return r.Engine.SetJob(ctx, id, job, ifVersion)
+	})
+}
+
+// do calls op until it succeeds, fails with an error retry rejects, or runs
+// out of attempts or time, and records the outcome in the circuit breaker.
+func (r *resilient) do(ctx context.Context, operation string, retry func(error) bool, op func(ctx context.Context) error) error {
+	if !r.breaker.allow() {
+		metrics.ObserveStorageRejected(r.Engine.Name(), operation)
+		return ErrUnavailable
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = r.attempt(ctx, op)
+		if err == nil || !transient(err) || ctx.Err() != nil ||
+			attempt >= r.opts.Attempts || !retry(err) {
+			break
+		}
+		wait := r.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			// The request would time out before the retry
+			break
+		}
+		metrics.ObserveStorageRetry(r.Engine.Name(), operation)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+	}
+
+	// Only errors from the database count against it; the caller giving up
+	// doesn't
+	r.breaker.record(transient(err) && ctx.Err() == nil)
+	return err
+}
+
+// attempt calls op once, within the attempt timeout.
+func (r *resilient) attempt(ctx context.Context, op func(ctx context.Context) error) error {
+	if r.opts.AttemptTimeout <= 0 {
+		return op(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.opts.AttemptTimeout)
+	defer cancel()
+	return op(ctx)
+}
+
+// backoff returns how long to wait before retrying after the given attempt:
+// a random duration up to the exponential backoff for that attempt.
+func (r *resilient) backoff(attempt int) time.Duration {
+	d := r.opts.Backoff
+	for i := 1; i < attempt && d < r.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	if r.opts.MaxBackoff > 0 && d > r.opts.MaxBackoff {
+		d = r.opts.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// transient returns true for errors that mean the database was briefly
+// unable to handle the operation, so trying again later may succeed.
+func transient(err error) bool {
+	if err == context.DeadlineExceeded {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+func alwaysRetry(error) bool {
+	return true
+}
+
+// notApplied returns true for errors that show the writes weren't applied.
+// Firestore returns Aborted when a transaction didn't commit because it
+// conflicted with another one.  Unavailable and DeadlineExceeded errors can
+// happen after the database has committed the writes, so they don't count.
+func notApplied(err error) bool {
+	return status.Code(err) == codes.Aborted
+}
+
+// repeatable returns true if applying the writes a second time leaves the
+// same result, and doesn't fail where the first time succeeded.
+func repeatable(writes []Write) bool {
+	for _, w := range writes {
+		if w.IfVersion != "" {
+			// The first write changes the version
+			return false
+		}
+		switch {
+		case w.Op == Set && !w.conditional(), w.Op == Delete:
+		case (w.Op == Max || w.Op == SetIfMissing) && !w.DeleteIfNotPositive:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// breaker is a circuit breaker.  It opens after threshold operations in a row
+// fail, and then refuses operations until cooldown has passed, when it lets
+// a single trial operation through to see if the database has recovered.
+type breaker struct {
+	engine    string
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	// When the breaker lets a trial operation through, if open
+	openUntil time.Time
+	// Whether a trial operation is in progress
+	trial bool
+}
+
+// allow returns true if an operation can go ahead.
+func (b *breaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.trial || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// record records the outcome of an operation allowed by allow.
+func (b *breaker) record(failed bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := b.failures >= b.threshold
+	b.trial = false
+	if !failed {
+		b.failures = 0
+		if wasOpen {
+			metrics.SetStorageBreakerOpen(b.engine, false)
+		}
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+		if !wasOpen {
+			metrics.SetStorageBreakerOpen(b.engine, true)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// flaky is a Memory engine whose writes fail with the queued errors.  Errors
+// can be returned before or after the writes are applied.
+type flaky struct {
+	*Memory
+	errs       []error
+	afterWrite bool
+	calls      int
+}
+
+func (f *flaky) Write(ctx context.Context, writes ...Write) (WriteResult, error) {
+	f.calls++
+	if len(f.errs) == 0 {
+		return f.Memory.Write(ctx, writes...)
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	if f.afterWrite {
+		f.Memory.Write(ctx, writes...)
+	}
+	return WriteResult{}, err
+}
+
+func TestResilientRetries(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	aborted := status.Error(codes.Aborted, "aborted")
+	inc := Write{User: "a", Relationship: "friends", Target: "b", Op: Increment, Value: 1}
+	set := Write{User: "a", Relationship: "friends", Target: "b", Op: Set, Value: 1}
+
+	tests := []struct {
+		name       string
+		write      Write
+		errs       []error
+		afterWrite bool
+		calls      int
+		err        error
+		score      int64
+	}{
+		{"set retried", set, []error{unavailable, unavailable}, true, 3, nil, 1},
+		{"set out of attempts", set, []error{unavailable, unavailable, unavailable}, false, 3, unavailable, 0},
+		{"increment not retried when it may have applied", inc, []error{unavailable}, true, 1, unavailable, 1},
+		{"increment retried when aborted", inc, []error{aborted}, false, 2, nil, 1},
+		{"other errors not retried", set, []error{ErrNotFound}, false, 1, ErrNotFound, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &flaky{Memory: NewMemory(), errs: tt.errs, afterWrite: tt.afterWrite}
+			r := Resilient(f, ResilienceOptions{Attempts: 3})
+			_, err := r.Write(context.Background(), tt.write)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.calls, f.calls)
+			score, _, _ := f.GetScore(context.Background(), "a", "friends", "b")
+			assert.Equal(t, tt.score, score)
+		})
+	}
+}
+
+func TestResilientBreaker(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	f := &flaky{Memory: NewMemory(), errs: []error{unavailable, unavailable, unavailable}}
+	r := Resilient(f, ResilienceOptions{Attempts: 1, BreakerFailures: 2, BreakerCooldown: time.Minute}).(*resilient)
+	now := time.Unix(1577836800, 0)
+	r.breaker.now = func() time.Time { return now }
+	set := Write{User: "a", Relationship: "friends", Target: "b", Op: Set, Value: 1}
+
+	for i := 0; i < 2; i++ {
+		_, err := r.Write(ctx, set)
+		assert.Equal(unavailable, err)
+	}
+	// The breaker is open, so the database isn't called
+	_, err := r.Write(ctx, set)
+	assert.Equal(ErrUnavailable, err)
+	assert.Equal(2, f.calls)
+
+	// After the cooldown a trial write is let through, and as it fails the
+	// breaker stays open
+	now = now.Add(time.Minute)
+	_, err = r.Write(ctx, set)
+	assert.Equal(unavailable, err)
+	_, err = r.Write(ctx, set)
+	assert.Equal(ErrUnavailable, err)
+
+	// A successful trial closes it
+	now = now.Add(time.Minute)
+	_, err = r.Write(ctx, set)
+	assert.Nil(err)
+	_, err = r.Write(ctx, set)
+	assert.Nil(err)
+	assert.Equal(5, f.calls)
+}
@@ -0,0 +1,406 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage defines the interface between the Tomolink request handlers
+// and the database engines that store relationships, and contains the
+// implementations of that interface for each supported engine.
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when the requested user, relationship type, or
+// relationship doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrConditionFailed is returned by Engine.Write when a CompareAndSet write's
+// relationship doesn't have the expected score.
+var ErrConditionFailed = errors.New("relationship doesn't have the expected score")
+
+// ErrVersionMismatch is returned by Engine.Write when a write's IfVersion
+// doesn't match the current version of the user's relationships.
+var ErrVersionMismatch = errors.New("relationships have changed since they were read")
+
+// AnyVersion can be used as a Write's IfVersion to only make the write if the
+// user already has relationships, whatever their version.
+const AnyVersion = "*"
+
+// ErrIdempotencyKeyReused is returned by Engine.WriteOnce when the idempotency
+// key was already used for a request making different writes.
+var ErrIdempotencyKeyReused = errors.New("idempotency key already used for a different request")
+
+// ErrUnavailable is returned by engines wrapped with Resilient while the
+// circuit breaker is open, without trying the database.
+var ErrUnavailable = errors.New("storage engine is unavailable")
+
+// maxNameLength is the longest relationship type name, in bytes; Firestore
+// allows field names of up to 1500 bytes.
+const maxNameLength = 1500
+
+// routeNames are taken by the API's user-level routes, such as
+// '/users/<uuidsource>/export', so relationship types with these names
+// couldn't be retrieved.
+var routeNames = map[string]bool{"deletion": true, "export": true, "mergeInto": true}
+
+// ValidRelationshipName checks that every engine can store relationships of
+// a type with the name.  Firestore uses names as field names, where periods
+// and backticks have special meaning and names like '__name__' are reserved,
+// and as collection IDs in the subcollection layout, which can't contain
+// slashes.  Slashes would also split the name in the API's URL paths, and
+// some names are taken by other routes.
+func ValidRelationshipName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("relationship type names can't be empty")
+	case strings.ContainsAny(name, ".`/"):
+		return fmt.Errorf("relationship type name '%s' can't contain periods, backticks or slashes", name)
+	case len(name) > 4 && strings.HasPrefix(name, "__") && strings.HasSuffix(name, "__"):
+		return fmt.Errorf("relationship type name '%s' is reserved by Firestore", name)
+	case name == updatedField:
+		return fmt.Errorf("relationship type name '%s' is used by Tomolink", name)
+	case routeNames[name]:
+		return fmt.Errorf("relationship type name '%s' is taken by the API's user routes", name)
+	case len(name) > maxNameLength:
+		return fmt.Errorf("relationship type names can't be longer than %d bytes", maxNameLength)
+	}
+	return nil
+}
+
+// CapExceededError is returned by Engine.Write when a write would take a user
+// past the cap on the number of relationships of one type.
+type CapExceededError struct {
+	User         string
+	Relationship string
+	Max          int
+}
+
+func (e *CapExceededError) Error() string {
+	return fmt.Sprintf("relationship cap reached: user '%s' already has the maximum of %d '%s' relationships",
+		e.User, e.Max, e.Relationship)
+}
+
+// Op is the kind of change a Write makes to a relationship.
+type Op int
+
+const (
+	// Set the relationship score to Value, creating the relationship if needed.
+	Set Op = iota
+	// Increment adds Value to the relationship score. Relationships that
+	// don't exist yet start with a score of 0.
+	Increment
+	// Delete removes the relationship.
+	Delete
+	// CompareAndSet sets the relationship score to Value, if it currently
+	// exists with a score of Expected.  Otherwise the write fails with
+	// ErrConditionFailed.
+	CompareAndSet
+	// Max sets the relationship score to Value, if that is higher than the
+	// current score or the relationship doesn't exist yet.
+	Max
+	// SetIfMissing sets the relationship score to Value, if the relationship
+	// doesn't exist yet.  Existing relationships keep their score.
+	SetIfMissing
+)
+
+// Write is a change to the relationship of type Relationship from User to
+// Target.
+type Write struct {
+	User         string
+	Relationship string
+	Target       string
+	Op           Op
+	Value        int64
+	// If Cap is more than 0, the write fails with a CapExceededError if it
+	// would add a new relationship while User already has Cap relationships
+	// of this type.
+	Cap int
+	// If IfVersion isn't empty, the write fails with ErrVersionMismatch
+	// unless User's relationships are still at this version, as returned by
+	// the Get methods.
+	IfVersion string
+	// The score a CompareAndSet write requires.
+	Expected int64
+	// If not nil, an Increment write never takes the score above Ceiling.
+	Ceiling *int64
+	// If true, a write that leaves the score at or below 0 deletes the
+	// relationship instead.
+	DeleteIfNotPositive bool
+
+	// Old names of the relationship type, whose scores for Target count
+	// towards the current score, taking the higher.  Set by Aliased, so
+	// writes depending on the current score see the relationships not moved
+	// from the old names yet, in the same transaction as the write.
+	oldNames []string
+}
+
+// conditional reports if the result of the write depends on the current
+// score, so the engine has to read it first.
+func (w Write) conditional() bool {
+	return w.Op == CompareAndSet || w.Op == Max || w.Op == SetIfMissing || w.Ceiling != nil || w.DeleteIfNotPositive ||
+		(w.Op == Increment && len(w.oldNames) > 0)
+}
+
+// higherScore returns the higher of two scores, of which only those that
+// exist count.
+func higherScore(score int64, exists bool, other int64, otherExists bool) (int64, bool) {
+	if otherExists && (!exists || other > score) {
+		return other, true
+	}
+	return score, exists
+}
+
+// resolve works out the result of a conditional write, given the current
+// score (if exists is true), as an unconditional Set or Delete write.
+func (w Write) resolve(current int64, exists bool) (Write, error) {
+	score := current
+	switch w.Op {
+	case Set:
+		score = w.Value
+	case Increment:
+		score = current + w.Value
+		if w.Ceiling != nil && score > *w.Ceiling {
+			// Scores already over the ceiling aren't lowered
+			score = max64(current, *w.Ceiling)
+		}
+	case CompareAndSet:
+		if !exists || current != w.Expected {
+			return w, ErrConditionFailed
+		}
+		score = w.Value
+	case Max:
+		if !exists || w.Value > current {
+			score = w.Value
+		}
+	case SetIfMissing:
+		if !exists {
+			score = w.Value
+		}
+	case Delete:
+		return w, nil
+	}
+
+	r := w
+	r.Op, r.Value, r.Ceiling, r.DeleteIfNotPositive = Set, score, nil, false
+	if w.DeleteIfNotPositive && score <= 0 {
+		r.Op, r.Value = Delete, 0
+	}
+	return r, nil
+}
+
+// addedTargets records the relationships a batch of writes adds, by user and
+// relationship type, so caps also count the relationships added earlier in
+// the same batch.
+type addedTargets map[[2]string]map[string]bool
+
+func (a addedTargets) has(user, relationship, target string) bool {
+	return a[[2]string{user, relationship}][target]
+}
+
+// count returns how many relationships of the type the batch adds for the
+// user.
+func (a addedTargets) count(user, relationship string) int {
+	return len(a[[2]string{user, relationship}])
+}
+
+func (a addedTargets) add(user, relationship, target string) {
+	key := [2]string{user, relationship}
+	if a[key] == nil {
+		a[key] = map[string]bool{}
+	}
+	a[key][target] = true
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// WriteResult describes writes that were committed.
+type WriteResult struct {
+	// When the database committed the writes.  For replayed writes, when
+	// they were first committed.  Zero if the engine can't tell.
+	Time time.Time
+	// True if WriteOnce didn't apply the writes, because they had already
+	// been applied with the same idempotency key.
+	Replayed bool
+}
+
+// Scores holds the scores of one user's relationships of one type, keyed by
+// target user ID.
+type Scores map[string]int64
+
+// Definition is a relationship type definition managed at runtime, which the
+// engine stores so every Tomolink instance uses the same definitions.
+type Definition struct {
+	// The kind of relationship, as in the config file's 'type'
+	Type string `json:"type" firestore:"type"`
+	// Maximum number of relationships of the type a user can have, or 0
+	Max int `json:"max" firestore:"max"`
+	// How scores are combined when merging users: 'sum', 'max' or 'keep'
+	Merge string `json:"merge" firestore:"merge"`
+	// Relationships of deprecated types can be read, but not written
+	Deprecated bool `json:"deprecated" firestore:"deprecated"`
+}
+
+// MergePolicy is how merging users combines a relationship the merged user has
+// with a relationship of the same type with the same user that is already
+// there.
+type MergePolicy string
+
+const (
+	// MergeSum adds the scores together.
+	MergeSum MergePolicy = "sum"
+	// MergeMax keeps the higher score.
+	MergeMax MergePolicy = "max"
+	// MergeKeep keeps the score that is already there.
+	MergeKeep MergePolicy = "keep"
+)
+
+// Valid reports if p is a known policy.
+func (p MergePolicy) Valid() bool {
+	return p == MergeSum || p == MergeMax || p == MergeKeep
+}
+
+// Op returns the write operation that applies the policy.
+func (p MergePolicy) Op() Op {
+	switch p {
+	case MergeSum:
+		return Increment
+	case MergeKeep:
+		return SetIfMissing
+	}
+	return Max
+}
+
+// Job is the stored status of a background job, such as removing a deleted
+// user's relationships from every other user, which the engine stores so
+// every Tomolink instance can report on it and pick it up again.
+type Job struct {
+	// The job's status, as JSON in whatever form the job records it
+	Status []byte `firestore:"status"`
+	// When the job is forgotten
+	Expires time.Time `firestore:"expires"`
+}
+
+// Engine is implemented by each supported storage engine.
+type Engine interface {
+	// Name returns the engine name, as used in the 'database.engine' config.
+	Name() string
+	// GetUser returns all of a user's relationships, keyed by relationship
+	// type, and their version.
+	//
+	// Versions are opaque strings that change every time any of the user's
+	// relationships change, for optimistic concurrency control (see
+	// Write.IfVersion).  Every Get method returns the version of all the
+	// user's relationships, not just the ones returned.
+	GetUser(ctx context.Context, user string) (map[string]Scores, string, error)
+	// GetRelationships returns a user's relationships of one type, and the
+	// version of the user's relationships.
+	GetRelationships(ctx context.Context, user, relationship string) (Scores, string, error)
+	// GetScore returns the score of a single relationship, and the version of
+	// the user's relationships.
+	GetScore(ctx context.Context, user, relationship, target string) (int64, string, error)
+	// Write atomically applies all the writes: either all of them are
+	// applied, or none of them are.  It only returns a nil error once the
+	// writes are committed.
+	Write(ctx context.Context, writes ...Write) (WriteResult, error)
+	// MaxWrites returns the most Writes a single call to Write or WriteOnce
+	// can make, as engines limit how much a transaction can change.
+	MaxWrites() int
+	// WriteOnce is like Write, but only applies the writes the first time it
+	// is called with key.  Until the key expires after ttl, calling it again
+	// with the same key and writes returns a replayed result without applying
+	// them again, and calling it with different writes returns
+	// ErrIdempotencyKeyReused.  The key is stored atomically with the writes,
+	// so it is only used up once they are applied.
+	WriteOnce(ctx context.Context, key string, ttl time.Duration, writes ...Write) (WriteResult, error)
+	// DeleteUser removes the user and all their relationships.  It doesn't
+	// remove other users' relationships with the user.  Deleting a user that
+	// doesn't exist succeeds.
+	DeleteUser(ctx context.Context, user string) error
+	// ScanUsers calls f with the ID of every user, in ID order, starting
+	// after the user after ("" starts from the first user), so an interrupted
+	// scan can be resumed.  It stops at the first error from f and returns
+	// it.  Users written during the scan may or may not be included.
+	ScanUsers(ctx context.Context, after string, f func(user string) error) error
+	// GetDefinitions returns the relationship type definitions stored with
+	// SetDefinitions, keyed by name, and their version, which is "" if none
+	// have been stored.
+	GetDefinitions(ctx context.Context) (map[string]Definition, string, error)
+	// SetDefinitions replaces the stored relationship type definitions, as
+	// long as their version is still ifVersion, and returns
+	// ErrVersionMismatch otherwise.
+	SetDefinitions(ctx context.Context, definitions map[string]Definition, ifVersion string) error
+	// GetJob returns the job stored under id with SetJob, and its version,
+	// or ErrNotFound if there isn't one or it has expired.
+	GetJob(ctx context.Context, id string) (Job, string, error)
+	// SetJob stores the job under id, as long as the version of the job
+	// stored under id is still ifVersion ("" if there isn't one, or it has
+	// expired), and returns ErrVersionMismatch otherwise.
+	SetJob(ctx context.Context, id string, job Job, ifVersion string) error
+	// Ping checks the engine can reach the database, as cheaply as possible.
+	Ping(ctx context.Context) error
+	// Close releases any resources held by the engine.
+	Close() error
+}
+
+// fingerprint returns a hash identifying the writes, to check an idempotency
+// key isn't reused for a different request.  Caps aren't included, so retries
+// of the same write still match if the caller's caps have changed.
+func fingerprint(writes []Write) string {
+	h := sha256.New()
+	for _, w := range writes {
+		ceiling := "none"
+		if w.Ceiling != nil {
+			ceiling = fmt.Sprint(*w.Ceiling)
+		}
+		fmt.Fprintf(h, "%q %q %q %d %d %d %s %v\n", w.User, w.Relationship, w.Target, w.Op, w.Value,
+			w.Expected, ceiling, w.DeleteIfNotPositive)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// toScores converts a relationship type's map as read from a schemaless
+// database into Scores, skipping any values that aren't numbers.
+func toScores(v interface{}) Scores {
+	m, _ := v.(map[string]interface{})
+	scores := make(Scores, len(m))
+	for target, v := range m {
+		if score, ok := toScore(v); ok {
+			scores[target] = score
+		}
+	}
+	return scores
+}
+
+// toScore converts a score as read from a schemaless database, returning
+// false if it isn't a number.
+func toScore(v interface{}) (int64, bool) {
+	switch s := v.(type) {
+	case int64:
+		return s, true
+	case float64:
+		return int64(s), true
+	}
+	return 0, false
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSpansContinueRemoteTrace(t *testing.T) {
+	assert := assert.New(t)
+	exporter := tracetest.NewInMemoryExporter()
+	tp := Setup(exporter, "tomolink-test", 0)
+
+	// The caller's trace is sampled, so the span is recorded even though the
+	// sample ratio for new traces is 0
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := Extract(context.Background(), propagation.HeaderCarrier(header))
+	ctx, span := Start(ctx, "test")
+
+	entry := Logger(ctx, logrus.NewEntry(logrus.New()))
+	assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736", entry.Data["trace_id"])
+	assert.Equal(span.SpanContext().SpanID().String(), entry.Data["span_id"])
+
+	End(span, errors.New("failed"))
+	assert.Nil(tp.ForceFlush(context.Background()))
+
+	spans := exporter.GetSpans()
+	if assert.Len(spans, 1) {
+		assert.Equal("test", spans[0].Name)
+		assert.Equal("00f067aa0ba902b7", spans[0].Parent.SpanID().String())
+		assert.Equal(codes.Error, spans[0].Status.Code)
+	}
+}
+
+func TestLoggerWithoutSpan(t *testing.T) {
+	entry := Logger(context.Background(), logrus.NewEntry(logrus.New()))
+	assert.Empty(t, entry.Data)
+}
@@ -0,0 +1,357 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package client is a Go client for the Tomolink HTTP API.
+//
+// Create a client with New, passing the base URL of your Tomolink service:
+//
+//	c, err := client.New("https://tomolink.example.com", client.WithTimeout(5*time.Second))
+//	err = c.CreateRelationship(ctx, client.Relationship{
+//		UUIDSource:   "player-1",
+//		UUIDTarget:   "player-2",
+//		Relationship: "friends",
+//		Delta:        1,
+//		Direction:    client.Mutual,
+//	})
+//
+// Code using the client should depend on the API interface, so unit tests can
+// use the in-memory Fake instead.
+package client
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Direction of a relationship write.
+type Direction string
+
+const (
+	// Single writes only the relationship from the source to the target user.
+	Single Direction = "single"
+	// Mutual also writes the reciprocal relationship from the target to the
+	// source user.
+	Mutual Direction = "mutual"
+)
+
+// Relationship is the relationship to act on in a write.  Delta is the score
+// to set on create, or the amount to add on update, and is ignored on delete.
+type Relationship struct {
+	UUIDSource   string    `json:"uuidsource"`
+	UUIDTarget   string    `json:"uuidtarget"`
+	Relationship string    `json:"relationship"`
+	Delta        int       `json:"delta"`
+	Direction    Direction `json:"direction,omitempty"`
+
+	// The fields below only apply to updates.  Operation defaults to
+	// Increment.  Expected is required by CompareAndSet, and Ceiling only
+	// limits Increment.
+	Operation           Operation `json:"operation,omitempty"`
+	Expected            *int64    `json:"expected,omitempty"`
+	Ceiling             *int64    `json:"ceiling,omitempty"`
+	DeleteIfNotPositive bool      `json:"deleteIfNotPositive,omitempty"`
+}
+
+// Operation is how an update changes the score of a relationship.
+type Operation string
+
+const (
+	// Increment adds the delta to the score.
+	Increment Operation = "increment"
+	// CompareAndSet sets the score to the delta, only if it is currently
+	// Expected.  Otherwise the update fails with ErrConflict.
+	CompareAndSet Operation = "compareAndSet"
+	// Max sets the score to the delta, if that is higher than the current
+	// score.
+	Max Operation = "max"
+)
+
+// Scores holds the scores of one user's relationships of one type, keyed by
+// target user ID.
+type Scores map[string]int64
+
+// API is the interface implemented by Client and Fake.
+type API interface {
+	// RetrieveUserRelationships returns all of a user's relationships, keyed
+	// by relationship type.
+	RetrieveUserRelationships(ctx context.Context, user string) (map[string]Scores, error)
+	// RetrieveUserRelationshipsByType returns a user's relationships of one
+	// type.
+	RetrieveUserRelationshipsByType(ctx context.Context, user, relationship string) (Scores, error)
+	// RetrieveSingleRelationship returns the score of a single relationship.
+	RetrieveSingleRelationship(ctx context.Context, user, relationship, target string) (int64, error)
+	// CreateRelationship creates a relationship, or resets the score of an
+	// existing relationship.
+	CreateRelationship(ctx context.Context, rel Relationship) error
+	// UpdateRelationship adds the delta to the score of a relationship.
+	UpdateRelationship(ctx context.Context, rel Relationship) error
+	// DeleteRelationship deletes a relationship.
+	DeleteRelationship(ctx context.Context, rel Relationship) error
+}
+
+// Client calls the Tomolink HTTP API.  It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	timeout    time.Duration
+	retries    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	headers    http.Header
+	// The header to send idempotency keys in, or "" to not send them
+	idempotencyHeader string
+}
+
+var _ API = (*Client)(nil)
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used to make requests, for example to
+// add authentication.  By default, http.DefaultClient is used.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithTimeout sets the timeout for each attempt at a request.  The default is
+// 10 seconds.  The context passed to each method limits the total time taken,
+// including retries.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithRetries sets the number of times a failed request is retried.  The
+// default is 3.
+func WithRetries(n int) Option {
+	return func(c *Client) { c.retries = n }
+}
+
+// WithBackoff sets the minimum and maximum delay between retries.  The delay
+// doubles after each attempt, with random jitter.  The defaults are 100ms and
+// 5 seconds.
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *Client) { c.minBackoff, c.maxBackoff = min, max }
+}
+
+// WithHeader adds a header to every request, for example the end-user token
+// or admin key headers.
+func WithHeader(name, value string) Option {
+	return func(c *Client) { c.headers.Add(name, value) }
+}
+
+// WithIdempotencyKeys sends a random idempotency key in the named header
+// (usually "Idempotency-Key") with every write, reusing it for each retry, so
+// the server applies each write only once.  This makes updates safe to retry
+// on any error, so only use it with servers that have idempotency keys turned
+// on.
+func WithIdempotencyKeys(header string) Option {
+	return func(c *Client) { c.idempotencyHeader = header }
+}
+
+// New returns a client for the Tomolink service at baseURL.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Tomolink URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Tomolink URL '%s': scheme and host are required", baseURL)
+	}
+	c := &Client{
+		baseURL:    strings.TrimSuffix(u.String(), "/"),
+		httpClient: http.DefaultClient,
+		timeout:    10 * time.Second,
+		retries:    3,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 5 * time.Second,
+		headers:    http.Header{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// RetrieveUserRelationships returns all of a user's relationships, keyed by
+// relationship type.
+func (c *Client) RetrieveUserRelationships(ctx context.Context, user string) (map[string]Scores, error) {
+	relationships := map[string]Scores{}
+	err := c.do(ctx, http.MethodGet, userPath(user), nil, nil, true, &relationships)
+	return relationships, err
+}
+
+// RetrieveUserRelationshipsByType returns a user's relationships of one type.
+func (c *Client) RetrieveUserRelationshipsByType(ctx context.Context, user, relationship string) (Scores, error) {
+	scores := Scores{}
+	err := c.do(ctx, http.MethodGet, userPath(user, relationship), nil, nil, true, &scores)
+	return scores, err
+}
+
+// RetrieveSingleRelationship returns the score of a single relationship.
+func (c *Client) RetrieveSingleRelationship(ctx context.Context, user, relationship, target string) (int64, error) {
+	var score int64
+	err := c.do(ctx, http.MethodGet, userPath(user, relationship, target), nil, nil, true, &score)
+	return score, err
+}
+
+// CreateRelationship creates a relationship, or resets the score of an
+// existing relationship.
+func (c *Client) CreateRelationship(ctx context.Context, rel Relationship) error {
+	return c.write(ctx, http.MethodPost, "/createRelationship", &rel, true)
+}
+
+// UpdateRelationship adds the delta to the score of a relationship.  As
+// retrying an update that was applied would add the delta twice, unless the
+// client sends idempotency keys (see WithIdempotencyKeys) updates are only
+// retried when the server says it didn't apply them (HTTP 429 or 503).
+func (c *Client) UpdateRelationship(ctx context.Context, rel Relationship) error {
+	return c.write(ctx, http.MethodPost, "/updateRelationship", &rel, false)
+}
+
+// DeleteRelationship deletes a relationship.
+func (c *Client) DeleteRelationship(ctx context.Context, rel Relationship) error {
+	return c.write(ctx, http.MethodDelete, "/deleteRelationship", &rel, true)
+}
+
+// write makes a write request, with an idempotency key if they are turned
+// on.
+func (c *Client) write(ctx context.Context, method, path string, rel *Relationship, idempotent bool) error {
+	header := http.Header{}
+	if c.idempotencyHeader != "" {
+		key := make([]byte, 16)
+		if _, err := crand.Read(key); err != nil {
+			return err
+		}
+		header.Set(c.idempotencyHeader, hex.EncodeToString(key))
+		idempotent = true
+	}
+	return c.do(ctx, method, path, header, rel, idempotent, nil)
+}
+
+// do makes a request with the extra header (if not nil), retrying it if it
+// fails with a retryable error, and decodes the JSON response into out (if
+// not nil).
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, in interface{}, idempotent bool, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	backoff := c.minBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, method, path, header, body, out)
+		if err == nil || ctx.Err() != nil || attempt >= c.retries || !retryable(err, idempotent) {
+			return err
+		}
+
+		// Wait before trying again, for as long as the server asked if it
+		// said, or else with exponential backoff and jitter.
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		var e *Error
+		if errors.As(err, &e) && e.RetryAfter > 0 {
+			wait = e.RetryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		if backoff *= 2; backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+// attempt makes a single attempt at a request.
+func (c *Client) attempt(ctx context.Context, method, path string, header http.Header, body []byte, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for name, values := range c.headers {
+		req.Header[name] = values
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		// The server only matches the write routes with this content type
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		e := &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		}
+		return e
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// retryable reports if a request that failed with err should be retried.
+func retryable(err error, idempotent bool) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		switch {
+		case e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusServiceUnavailable:
+			return true
+		case e.StatusCode >= 500:
+			return idempotent
+		}
+		return false
+	}
+	// Requests that failed without a response (for example, because the
+	// attempt timed out) may or may not have been applied
+	return idempotent
+}
+
+// userPath returns the escaped path for the /users retrieval endpoints.
+func userPath(elems ...string) string {
+	path := "/users"
+	for _, e := range elems {
+		path += "/" + url.PathEscape(e)
+	}
+	return path
+}
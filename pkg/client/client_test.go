@@ -0,0 +1,151 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// server returns a test server that responds with the given status codes in
+// turn, and then with 200 and body, counting the requests it gets.  Close the
+// returned server when done.
+func server(t *testing.T, calls *int32, body string, statuses ...int) (*Client, *httptest.Server) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(calls, 1))
+		if n <= len(statuses) {
+			if statuses[n-1] == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			http.Error(w, http.StatusText(statuses[n-1]), statuses[n-1])
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	c, err := New(ts.URL, WithBackoff(time.Millisecond, time.Millisecond))
+	assert.Nil(t, err)
+	return c, ts
+}
+
+func TestRetries(t *testing.T) {
+	rel := Relationship{UUIDSource: "a", UUIDTarget: "b", Relationship: "friends", Delta: 1}
+
+	testCases := []struct {
+		name     string
+		call     func(c *Client) error
+		statuses []int
+		calls    int32
+		is       error
+	}{
+		{"retries unavailable", func(c *Client) error {
+			_, err := c.RetrieveSingleRelationship(context.Background(), "a", "friends", "b")
+			return err
+		}, []int{503, 503}, 3, nil},
+		{"gives up after retries", func(c *Client) error {
+			_, err := c.RetrieveSingleRelationship(context.Background(), "a", "friends", "b")
+			return err
+		}, []int{500, 500, 500, 500}, 4, ErrServer},
+		{"doesn't retry not found", func(c *Client) error {
+			_, err := c.RetrieveSingleRelationship(context.Background(), "a", "friends", "b")
+			return err
+		}, []int{404}, 1, ErrNotFound},
+		{"doesn't retry update on server error", func(c *Client) error {
+			return c.UpdateRelationship(context.Background(), rel)
+		}, []int{500}, 1, ErrServer},
+		{"retries update when unavailable", func(c *Client) error {
+			return c.UpdateRelationship(context.Background(), rel)
+		}, []int{503}, 2, nil},
+		{"retries update with idempotency key", func(c *Client) error {
+			WithIdempotencyKeys("Idempotency-Key")(c)
+			return c.UpdateRelationship(context.Background(), rel)
+		}, []int{500}, 2, nil},
+		{"retries create on server error", func(c *Client) error {
+			return c.CreateRelationship(context.Background(), rel)
+		}, []int{500}, 2, nil},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			var calls int32
+			c, ts := server(t, &calls, "1", tc.statuses...)
+			defer ts.Close()
+			err := tc.call(c)
+			if tc.is == nil {
+				assert.Nil(err)
+			} else {
+				assert.True(errors.Is(err, tc.is), "got %v", err)
+			}
+			assert.Equal(tc.calls, atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+	var calls int32
+	c, ts := server(t, &calls, "7", http.StatusTooManyRequests)
+	defer ts.Close()
+
+	start := time.Now()
+	score, err := c.RetrieveSingleRelationship(context.Background(), "a", "friends", "b")
+	assert.Nil(err)
+	assert.Equal(int64(7), score)
+	assert.True(time.Since(start) >= time.Second, "didn't wait for Retry-After")
+}
+
+func TestFake(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	f := &Fake{}
+
+	_, err := f.RetrieveUserRelationships(ctx, "a")
+	assert.True(errors.Is(err, ErrNotFound))
+
+	rel := Relationship{UUIDSource: "a", UUIDTarget: "b", Relationship: "friends", Delta: 2, Direction: Mutual}
+	assert.Nil(f.CreateRelationship(ctx, rel))
+	assert.Nil(f.UpdateRelationship(ctx, Relationship{UUIDSource: "a", UUIDTarget: "b", Relationship: "friends", Delta: 3}))
+
+	score, err := f.RetrieveSingleRelationship(ctx, "a", "friends", "b")
+	assert.Nil(err)
+	assert.Equal(int64(5), score)
+	score, err = f.RetrieveSingleRelationship(ctx, "b", "friends", "a")
+	assert.Nil(err)
+	assert.Equal(int64(2), score)
+
+	assert.Nil(f.DeleteRelationship(ctx, rel))
+	scores, err := f.RetrieveUserRelationshipsByType(ctx, "b", "friends")
+	assert.Nil(err)
+	assert.Empty(scores)
+
+	expected := int64(4)
+	cas := Relationship{UUIDSource: "a", UUIDTarget: "b", Relationship: "friends", Delta: 1, Operation: CompareAndSet, Expected: &expected}
+	assert.True(errors.Is(f.UpdateRelationship(ctx, cas), ErrConflict))
+	assert.Nil(f.CreateRelationship(ctx, Relationship{UUIDSource: "a", UUIDTarget: "b", Relationship: "friends", Delta: 4}))
+	assert.Nil(f.UpdateRelationship(ctx, cas))
+	score, _ = f.RetrieveSingleRelationship(ctx, "a", "friends", "b")
+	assert.Equal(int64(1), score)
+
+	f.Err = errors.New("boom")
+	assert.Equal(f.Err, f.CreateRelationship(ctx, rel))
+}
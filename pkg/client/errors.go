@@ -0,0 +1,88 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Errors matching the HTTP status codes returned by Tomolink.  Use errors.Is
+// to check for them, for example:
+//
+//	if errors.Is(err, client.ErrNotFound) {
+var (
+	// ErrInvalid is returned for HTTP 400: the request parameters are
+	// invalid, or the relationship type isn't defined in strict mode.
+	ErrInvalid = errors.New("tomolink: invalid request")
+	// ErrUnauthenticated is returned for HTTP 401: the end-user token is
+	// missing or invalid.
+	ErrUnauthenticated = errors.New("tomolink: unauthenticated")
+	// ErrPermissionDenied is returned for HTTP 403: the end-user token was
+	// issued to a different user.
+	ErrPermissionDenied = errors.New("tomolink: permission denied")
+	// ErrNotFound is returned for HTTP 404: the user or relationship doesn't
+	// exist.
+	ErrNotFound = errors.New("tomolink: not found")
+	// ErrConflict is returned for HTTP 409: the write would take a user past
+	// a relationship cap.
+	ErrConflict = errors.New("tomolink: conflict")
+	// ErrIdempotencyKeyReused is returned for HTTP 422: the idempotency key
+	// was already used for a different request.
+	ErrIdempotencyKeyReused = errors.New("tomolink: idempotency key reused")
+	// ErrRateLimited is returned for HTTP 429: the request was over a rate
+	// limit.
+	ErrRateLimited = errors.New("tomolink: rate limited")
+	// ErrServer is returned for HTTP 5xx responses.
+	ErrServer = errors.New("tomolink: server error")
+)
+
+// Error is returned when Tomolink responds with an HTTP error status.
+type Error struct {
+	StatusCode int
+	// The response body
+	Message string
+	// How long the server asked the client to wait before retrying, if it
+	// did.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("tomolink: HTTP %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is lets errors.Is match an Error against the error variables for its status
+// code.
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return target == ErrInvalid
+	case http.StatusUnauthorized:
+		return target == ErrUnauthenticated
+	case http.StatusForbidden:
+		return target == ErrPermissionDenied
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusConflict:
+		return target == ErrConflict
+	case http.StatusUnprocessableEntity:
+		return target == ErrIdempotencyKeyReused
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	}
+	return e.StatusCode >= 500 && target == ErrServer
+}
@@ -0,0 +1,198 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Fake is an in-memory implementation of API for unit tests of code that uses
+// Tomolink.  It behaves like a Tomolink service that allows every
+// relationship type, and has no authentication or relationship caps.  The
+// zero value is an empty Fake, ready to use.  It is safe for concurrent use.
+type Fake struct {
+	// If not nil, every method returns Err without doing anything, to test
+	// error handling.
+	Err error
+
+	mu sync.Mutex
+	// user => relationship => target => score
+	users map[string]map[string]Scores
+}
+
+var _ API = (*Fake)(nil)
+
+// RetrieveUserRelationships returns all of a user's relationships, keyed by
+// relationship type.
+func (f *Fake) RetrieveUserRelationships(ctx context.Context, user string) (map[string]Scores, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	relationships, ok := f.users[user]
+	if !ok {
+		return nil, notFound()
+	}
+	out := make(map[string]Scores, len(relationships))
+	for rel, scores := range relationships {
+		out[rel] = copyScores(scores)
+	}
+	return out, nil
+}
+
+// RetrieveUserRelationshipsByType returns a user's relationships of one type.
+func (f *Fake) RetrieveUserRelationshipsByType(ctx context.Context, user, relationship string) (Scores, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	scores, ok := f.users[user][relationship]
+	if !ok {
+		return nil, notFound()
+	}
+	return copyScores(scores), nil
+}
+
+// RetrieveSingleRelationship returns the score of a single relationship.
+func (f *Fake) RetrieveSingleRelationship(ctx context.Context, user, relationship, target string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return 0, f.Err
+	}
+	score, ok := f.users[user][relationship][target]
+	if !ok {
+		return 0, notFound()
+	}
+	return score, nil
+}
+
+// CreateRelationship creates a relationship, or resets the score of an
+// existing relationship.
+func (f *Fake) CreateRelationship(ctx context.Context, rel Relationship) error {
+	// The update-only fields are ignored, as they are by the service
+	rel.DeleteIfNotPositive = false
+	return f.write(rel, func(int64, bool) (int64, error) { return int64(rel.Delta), nil })
+}
+
+// UpdateRelationship adds the delta to the score of a relationship, or makes
+// a conditional update.
+func (f *Fake) UpdateRelationship(ctx context.Context, rel Relationship) error {
+	delta := int64(rel.Delta)
+	return f.write(rel, func(current int64, exists bool) (int64, error) {
+		switch rel.Operation {
+		case CompareAndSet:
+			if rel.Expected == nil {
+				return 0, &Error{StatusCode: http.StatusBadRequest, Message: "expected is required for compareAndSet"}
+			}
+			if !exists || current != *rel.Expected {
+				return 0, &Error{StatusCode: http.StatusConflict, Message: "condition failed"}
+			}
+			return delta, nil
+		case Max:
+			if !exists || delta > current {
+				return delta, nil
+			}
+			return current, nil
+		}
+		score := current + delta
+		if rel.Ceiling != nil && score > *rel.Ceiling {
+			// Scores already over the ceiling aren't lowered
+			score = *rel.Ceiling
+			if current > score {
+				score = current
+			}
+		}
+		return score, nil
+	})
+}
+
+// DeleteRelationship deletes a relationship.
+func (f *Fake) DeleteRelationship(ctx context.Context, rel Relationship) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return f.Err
+	}
+	for _, pair := range pairs(rel) {
+		delete(f.users[pair[0]][rel.Relationship], pair[1])
+	}
+	return nil
+}
+
+// write sets the score of the relationship (and its reciprocal, if mutual)
+// to the value returned by score, which is passed the current score and
+// whether the relationship exists.  If score fails for either relationship,
+// nothing is written.
+func (f *Fake) write(rel Relationship, score func(current int64, exists bool) (int64, error)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return f.Err
+	}
+	if f.users == nil {
+		f.users = map[string]map[string]Scores{}
+	}
+	ps := pairs(rel)
+	scores := make([]int64, len(ps))
+	for i, pair := range ps {
+		current, exists := f.users[pair[0]][rel.Relationship][pair[1]]
+		s, err := score(current, exists)
+		if err != nil {
+			return err
+		}
+		scores[i] = s
+	}
+	for i, pair := range ps {
+		user, target := pair[0], pair[1]
+		if f.users[user] == nil {
+			f.users[user] = map[string]Scores{}
+		}
+		if f.users[user][rel.Relationship] == nil {
+			f.users[user][rel.Relationship] = Scores{}
+		}
+		if rel.DeleteIfNotPositive && scores[i] <= 0 {
+			delete(f.users[user][rel.Relationship], target)
+			continue
+		}
+		f.users[user][rel.Relationship][target] = scores[i]
+	}
+	return nil
+}
+
+// pairs returns the [user, target] pairs a write applies to.
+func pairs(rel Relationship) [][2]string {
+	p := [][2]string{{rel.UUIDSource, rel.UUIDTarget}}
+	if rel.Direction == Mutual {
+		p = append(p, [2]string{rel.UUIDTarget, rel.UUIDSource})
+	}
+	return p
+}
+
+func copyScores(s Scores) Scores {
+	out := make(Scores, len(s))
+	for target, score := range s {
+		out[target] = score
+	}
+	return out
+}
+
+func notFound() error {
+	return &Error{StatusCode: http.StatusNotFound, Message: "not found"}
+}
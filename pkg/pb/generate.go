@@ -0,0 +1,20 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pb contains the Go code generated from the Tomolink gRPC API
+// definition in api/tomolink.proto.  To regenerate it, install protoc,
+// protoc-gen-go and protoc-gen-go-grpc, then run 'go generate ./pkg/pb'.
+package pb
+
+//go:generate protoc -I ../../api --go_out=../.. --go_opt=module=github.com/joeholley/tomolink --go-grpc_out=../.. --go-grpc_opt=module=github.com/joeholley/tomolink tomolink.proto
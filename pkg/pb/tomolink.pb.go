@@ -0,0 +1,1362 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.1
+// 	protoc        v3.11.4
+// source: tomolink.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Direction of a relationship write.
+type Direction int32
+
+const (
+	// Same as SINGLE.
+	Direction_DIRECTION_UNSPECIFIED Direction = 0
+	// Only the relationship from the source to the target user.
+	Direction_SINGLE Direction = 1
+	// The relationship from the source to the target user, and the reciprocal
+	// relationship from the target to the source user.
+	Direction_MUTUAL Direction = 2
+)
+
+// Enum value maps for Direction.
+var (
+	Direction_name = map[int32]string{
+		0: "DIRECTION_UNSPECIFIED",
+		1: "SINGLE",
+		2: "MUTUAL",
+	}
+	Direction_value = map[string]int32{
+		"DIRECTION_UNSPECIFIED": 0,
+		"SINGLE":                1,
+		"MUTUAL":                2,
+	}
+)
+
+func (x Direction) Enum() *Direction {
+	p := new(Direction)
+	*p = x
+	return p
+}
+
+func (x Direction) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Direction) Descriptor() protoreflect.EnumDescriptor {
+	return file_tomolink_proto_enumTypes[0].Descriptor()
+}
+
+func (Direction) Type() protoreflect.EnumType {
+	return &file_tomolink_proto_enumTypes[0]
+}
+
+func (x Direction) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Direction.Descriptor instead.
+func (Direction) EnumDescriptor() ([]byte, []int) {
+	return file_tomolink_proto_rawDescGZIP(), []int{0}
+}
+
+// How an update changes the score of a relationship.
+type Operation int32
+
+const (
+	// Same as INCREMENT.
+	Operation_OPERATION_UNSPECIFIED Operation = 0
+	// Adds the delta to the score.
+	Operation_INCREMENT Operation = 1
+	// Sets the score to the delta, only if it is currently the expected score.
+	Operation_COMPARE_AND_SET Operation = 2
+	// Sets the score to the delta, if that is higher than the current score.
+	Operation_MAX Operation = 3
+)
+
+// Enum value maps for Operation.
+var (
+	Operation_name = map[int32]string{
+		0: "OPERATION_UNSPECIFIED",
+		1: "INCREMENT",
+		2: "COMPARE_AND_SET",
+		3: "MAX",
+	}
+	Operation_value = map[string]int32{
+		"OPERATION_UNSPECIFIED": 0,
+		"INCREMENT":             1,
+		"COMPARE_AND_SET":       2,
+		"MAX":                   3,
+	}
+)
+
+func (x Operation) Enum() *Operation {
+	p := new(Operation)
+	*p = x
+	return p
+}
+
+func (x Operation) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Operation) Descriptor() protoreflect.EnumDescriptor {
+	return file_tomolink_proto_enumTypes[1].Descriptor()
+}
+
+func (Operation) Type() protoreflect.EnumType {
+	return &file_tomolink_proto_enumTypes[1]
+}
+
+func (x Operation) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Operation.Descriptor instead.
+func (Operation) EnumDescriptor() ([]byte, []int) {
+	return file_tomolink_proto_rawDescGZIP(), []int{1}
+}
+
+type BatchWriteRequest_Op int32
+
+const (
+	BatchWriteRequest_OP_UNSPECIFIED BatchWriteRequest_Op = 0
+	BatchWriteRequest_CREATE         BatchWriteRequest_Op = 1
+	BatchWriteRequest_UPDATE         BatchWriteRequest_Op = 2
+	BatchWriteRequest_DELETE         BatchWriteRequest_Op = 3
+)
+
+// Enum value maps for BatchWriteRequest_Op.
+var (
+	BatchWriteRequest_Op_name = map[int32]string{
+		0: "OP_UNSPECIFIED",
+		1: "CREATE",
+		2: "UPDATE",
+		3: "DELETE",
+	}
+	BatchWriteRequest_Op_value = map[string]int32{
+		"OP_UNSPECIFIED": 0,
+		"CREATE":         1,
+		"UPDATE":         2,
+		"DELETE":         3,
+	}
+)
+
+func (x BatchWriteRequest_Op) Enum() *BatchWriteRequest_Op {
+	p := new(BatchWriteRequest_Op)
+	*p = x
+	return p
+}
+
+func (x BatchWriteRequest_Op) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (BatchWriteRequest_Op) Descriptor() protoreflect.EnumDescriptor {
+	return file_tomolink_proto_enumTypes[2].Descriptor()
+}
+
+func (BatchWriteRequest_Op) Type() protoreflect.EnumType {
+	return &file_tomolink_proto_enumTypes[2]
+}
+
+func (x BatchWriteRequest_Op) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use BatchWriteRequest_Op.Descriptor instead.
+func (BatchWriteRequest_Op) EnumDescriptor() ([]byte, []int) {
+	return file_tomolink_proto_rawDescGZIP(), []int{9, 0}
+}
+
+// A relationship between two users, as used by the write operations.
+type Relationship struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Uuidsource   string `protobuf:"bytes,1,opt,name=uuidsource,proto3" json:"uuidsource,omitempty"`
+	Uuidtarget   string `protobuf:"bytes,2,opt,name=uuidtarget,proto3" json:"uuidtarget,omitempty"`
+	Relationship string `protobuf:"bytes,3,opt,name=relationship,proto3" json:"relationship,omitempty"`
+	// The score to set on create, or the amount to add to the score on update.
+	// Ignored on delete.
+	Delta     int64     `protobuf:"varint,4,opt,name=delta,proto3" json:"delta,omitempty"`
+	Direction Direction `protobuf:"varint,5,opt,name=direction,proto3,enum=tomolink.Direction" json:"direction,omitempty"`
+	// The fields below only apply to updates.
+	Operation Operation `protobuf:"varint,6,opt,name=operation,proto3,enum=tomolink.Operation" json:"operation,omitempty"`
+	// The score a COMPARE_AND_SET update requires.
+	Expected *int64 `protobuf:"varint,7,opt,name=expected,proto3,oneof" json:"expected,omitempty"`
+	// If set, an INCREMENT update never takes the score above the ceiling.
+	Ceiling *int64 `protobuf:"varint,8,opt,name=ceiling,proto3,oneof" json:"ceiling,omitempty"`
+	// If true, an update that leaves the score at or below 0 deletes the
+	// relationship instead.
+	DeleteIfNotPositive bool `protobuf:"varint,9,opt,name=delete_if_not_positive,json=deleteIfNotPositive,proto3" json:"delete_if_not_positive,omitempty"`
+}
+
+func (x *Relationship) Reset() {
+	*x = Relationship{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tomolink_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Relationship) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Relationship) ProtoMessage() {}
+
+func (x *Relationship) ProtoReflect() protoreflect.Message {
+	mi := &file_tomolink_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Relationship.ProtoReflect.Descriptor instead.
+func (*Relationship) Descriptor() ([]byte, []int) {
+	return file_tomolink_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Relationship) GetUuidsource() string {
+	if x != nil {
+		return x.Uuidsource
+	}
+	return ""
+}
+
+func (x *Relationship) GetUuidtarget() string {
+	if x != nil {
+		return x.Uuidtarget
+	}
+	return ""
+}
+
+func (x *Relationship) GetRelationship() string {
+	if x != nil {
+		return x.Relationship
+	}
+	return ""
+}
+
+func (x *Relationship) GetDelta() int64 {
+	if x != nil {
+		return x.Delta
+	}
+	return 0
+}
+
+func (x *Relationship) GetDirection() Direction {
+	if x != nil {
+		return x.Direction
+	}
+	return Direction_DIRECTION_UNSPECIFIED
+}
+
+func (x *Relationship) GetOperation() Operation {
+	if x != nil {
+		return x.Operation
+	}
+	return Operation_OPERATION_UNSPECIFIED
+}
+
+func (x *Relationship) GetExpected() int64 {
+	if x != nil && x.Expected != nil {
+		return *x.Expected
+	}
+	return 0
+}
+
+func (x *Relationship) GetCeiling() int64 {
+	if x != nil && x.Ceiling != nil {
+		return *x.Ceiling
+	}
+	return 0
+}
+
+func (x *Relationship) GetDeleteIfNotPositive() bool {
+	if x != nil {
+		return x.DeleteIfNotPositive
+	}
+	return false
+}
+
+// Relationship scores of one type, keyed by target user.
+type Scores struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Scores map[string]int64 `protobuf:"bytes,1,rep,name=scores,proto3" json:"scores,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+}
+
+func (x *Scores) Reset() {
+	*x = Scores{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tomolink_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Scores) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Scores) ProtoMessage() {}
+
+func (x *Scores) ProtoReflect() protoreflect.Message {
+	mi := &file_tomolink_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Scores.ProtoReflect.Descriptor instead.
+func (*Scores) Descriptor() ([]byte, []int) {
+	return file_tomolink_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Scores) GetScores() map[string]int64 {
+	if x != nil {
+		return x.Scores
+	}
+	return nil
+}
+
+type RetrieveUserRelationshipsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Uuidsource string `protobuf:"bytes,1,opt,name=uuidsource,proto3" json:"uuidsource,omitempty"`
+}
+
+func (x *RetrieveUserRelationshipsRequest) Reset() {
+	*x = RetrieveUserRelationshipsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tomolink_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RetrieveUserRelationshipsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RetrieveUserRelationshipsRequest) ProtoMessage() {}
+
+func (x *RetrieveUserRelationshipsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tomolink_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RetrieveUserRelationshipsRequest.ProtoReflect.Descriptor instead.
+func (*RetrieveUserRelationshipsRequest) Descriptor() ([]byte, []int) {
+	return file_tomolink_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RetrieveUserRelationshipsRequest) GetUuidsource() string {
+	if x != nil {
+		return x.Uuidsource
+	}
+	return ""
+}
+
+type RetrieveUserRelationshipsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Keyed by relationship type.
+	Relationships map[string]*Scores `protobuf:"bytes,1,rep,name=relationships,proto3" json:"relationships,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (x *RetrieveUserRelationshipsResponse) Reset() {
+	*x = RetrieveUserRelationshipsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tomolink_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RetrieveUserRelationshipsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RetrieveUserRelationshipsResponse) ProtoMessage() {}
+
+func (x *RetrieveUserRelationshipsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tomolink_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RetrieveUserRelationshipsResponse.ProtoReflect.Descriptor instead.
+func (*RetrieveUserRelationshipsResponse) Descriptor() ([]byte, []int) {
+	return file_tomolink_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RetrieveUserRelationshipsResponse) GetRelationships() map[string]*Scores {
+	if x != nil {
+		return x.Relationships
+	}
+	return nil
+}
+
+type RetrieveUserRelationshipsByTypeRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Uuidsource   string `protobuf:"bytes,1,opt,name=uuidsource,proto3" json:"uuidsource,omitempty"`
+	Relationship string `protobuf:"bytes,2,opt,name=relationship,proto3" json:"relationship,omitempty"`
+}
+
+func (x *RetrieveUserRelationshipsByTypeRequest) Reset() {
+	*x = RetrieveUserRelationshipsByTypeRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tomolink_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RetrieveUserRelationshipsByTypeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RetrieveUserRelationshipsByTypeRequest) ProtoMessage() {}
+
+func (x *RetrieveUserRelationshipsByTypeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tomolink_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RetrieveUserRelationshipsByTypeRequest.ProtoReflect.Descriptor instead.
+func (*RetrieveUserRelationshipsByTypeRequest) Descriptor() ([]byte, []int) {
+	return file_tomolink_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *RetrieveUserRelationshipsByTypeRequest) GetUuidsource() string {
+	if x != nil {
+		return x.Uuidsource
+	}
+	return ""
+}
+
+func (x *RetrieveUserRelationshipsByTypeRequest) GetRelationship() string {
+	if x != nil {
+		return x.Relationship
+	}
+	return ""
+}
+
+type RetrieveSingleRelationshipRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Uuidsource   string `protobuf:"bytes,1,opt,name=uuidsource,proto3" json:"uuidsource,omitempty"`
+	Relationship string `protobuf:"bytes,2,opt,name=relationship,proto3" json:"relationship,omitempty"`
+	Uuidtarget   string `protobuf:"bytes,3,opt,name=uuidtarget,proto3" json:"uuidtarget,omitempty"`
+}
+
+func (x *RetrieveSingleRelationshipRequest) Reset() {
+	*x = RetrieveSingleRelationshipRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tomolink_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RetrieveSingleRelationshipRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RetrieveSingleRelationshipRequest) ProtoMessage() {}
+
+func (x *RetrieveSingleRelationshipRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tomolink_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RetrieveSingleRelationshipRequest.ProtoReflect.Descriptor instead.
+func (*RetrieveSingleRelationshipRequest) Descriptor() ([]byte, []int) {
+	return file_tomolink_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *RetrieveSingleRelationshipRequest) GetUuidsource() string {
+	if x != nil {
+		return x.Uuidsource
+	}
+	return ""
+}
+
+func (x *RetrieveSingleRelationshipRequest) GetRelationship() string {
+	if x != nil {
+		return x.Relationship
+	}
+	return ""
+}
+
+func (x *RetrieveSingleRelationshipRequest) GetUuidtarget() string {
+	if x != nil {
+		return x.Uuidtarget
+	}
+	return ""
+}
+
+type RetrieveSingleRelationshipResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Score int64 `protobuf:"varint,1,opt,name=score,proto3" json:"score,omitempty"`
+}
+
+func (x *RetrieveSingleRelationshipResponse) Reset() {
+	*x = RetrieveSingleRelationshipResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tomolink_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RetrieveSingleRelationshipResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RetrieveSingleRelationshipResponse) ProtoMessage() {}
+
+func (x *RetrieveSingleRelationshipResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tomolink_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RetrieveSingleRelationshipResponse.ProtoReflect.Descriptor instead.
+func (*RetrieveSingleRelationshipResponse) Descriptor() ([]byte, []int) {
+	return file_tomolink_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RetrieveSingleRelationshipResponse) GetScore() int64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+type BatchRetrieveSingleRelationshipsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Requests []*RetrieveSingleRelationshipRequest `protobuf:"bytes,1,rep,name=requests,proto3" json:"requests,omitempty"`
+}
+
+func (x *BatchRetrieveSingleRelationshipsRequest) Reset() {
+	*x = BatchRetrieveSingleRelationshipsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tomolink_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BatchRetrieveSingleRelationshipsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchRetrieveSingleRelationshipsRequest) ProtoMessage() {}
+
+func (x *BatchRetrieveSingleRelationshipsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tomolink_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchRetrieveSingleRelationshipsRequest.ProtoReflect.Descriptor instead.
+func (*BatchRetrieveSingleRelationshipsRequest) Descriptor() ([]byte, []int) {
+	return file_tomolink_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *BatchRetrieveSingleRelationshipsRequest) GetRequests() []*RetrieveSingleRelationshipRequest {
+	if x != nil {
+		return x.Requests
+	}
+	return nil
+}
+
+type BatchRetrieveSingleRelationshipsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// One result per request, in the same order as the requests.
+	Results []*BatchRetrieveSingleRelationshipsResponse_Result `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+}
+
+func (x *BatchRetrieveSingleRelationshipsResponse) Reset() {
+	*x = BatchRetrieveSingleRelationshipsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tomolink_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BatchRetrieveSingleRelationshipsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchRetrieveSingleRelationshipsResponse) ProtoMessage() {}
+
+func (x *BatchRetrieveSingleRelationshipsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tomolink_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchRetrieveSingleRelationshipsResponse.ProtoReflect.Descriptor instead.
+func (*BatchRetrieveSingleRelationshipsResponse) Descriptor() ([]byte, []int) {
+	return file_tomolink_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *BatchRetrieveSingleRelationshipsResponse) GetResults() []*BatchRetrieveSingleRelationshipsResponse_Result {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type BatchWriteRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Writes []*BatchWriteRequest_Write `protobuf:"bytes,1,rep,name=writes,proto3" json:"writes,omitempty"`
+}
+
+func (x *BatchWriteRequest) Reset() {
+	*x = BatchWriteRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tomolink_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BatchWriteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchWriteRequest) ProtoMessage() {}
+
+func (x *BatchWriteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tomolink_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchWriteRequest.ProtoReflect.Descriptor instead.
+func (*BatchWriteRequest) Descriptor() ([]byte, []int) {
+	return file_tomolink_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *BatchWriteRequest) GetWrites() []*BatchWriteRequest_Write {
+	if x != nil {
+		return x.Writes
+	}
+	return nil
+}
+
+type WriteResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// When the storage engine committed the write.  For replayed writes, when
+	// the write was first committed.  Not set if the storage engine can't tell.
+	WriteTime *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=write_time,json=writeTime,proto3" json:"write_time,omitempty"`
+}
+
+func (x *WriteResponse) Reset() {
+	*x = WriteResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tomolink_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WriteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WriteResponse) ProtoMessage() {}
+
+func (x *WriteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tomolink_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WriteResponse.ProtoReflect.Descriptor instead.
+func (*WriteResponse) Descriptor() ([]byte, []int) {
+	return file_tomolink_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *WriteResponse) GetWriteTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.WriteTime
+	}
+	return nil
+}
+
+type BatchRetrieveSingleRelationshipsResponse_Result struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// False if the relationship doesn't exist.
+	Found bool  `protobuf:"varint,1,opt,name=found,proto3" json:"found,omitempty"`
+	Score int64 `protobuf:"varint,2,opt,name=score,proto3" json:"score,omitempty"`
+}
+
+func (x *BatchRetrieveSingleRelationshipsResponse_Result) Reset() {
+	*x = BatchRetrieveSingleRelationshipsResponse_Result{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tomolink_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BatchRetrieveSingleRelationshipsResponse_Result) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchRetrieveSingleRelationshipsResponse_Result) ProtoMessage() {}
+
+func (x *BatchRetrieveSingleRelationshipsResponse_Result) ProtoReflect() protoreflect.Message {
+	mi := &file_tomolink_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchRetrieveSingleRelationshipsResponse_Result.ProtoReflect.Descriptor instead.
+func (*BatchRetrieveSingleRelationshipsResponse_Result) Descriptor() ([]byte, []int) {
+	return file_tomolink_proto_rawDescGZIP(), []int{8, 0}
+}
+
+func (x *BatchRetrieveSingleRelationshipsResponse_Result) GetFound() bool {
+	if x != nil {
+		return x.Found
+	}
+	return false
+}
+
+func (x *BatchRetrieveSingleRelationshipsResponse_Result) GetScore() int64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+type BatchWriteRequest_Write struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Op           BatchWriteRequest_Op `protobuf:"varint,1,opt,name=op,proto3,enum=tomolink.BatchWriteRequest_Op" json:"op,omitempty"`
+	Relationship *Relationship        `protobuf:"bytes,2,opt,name=relationship,proto3" json:"relationship,omitempty"`
+}
+
+func (x *BatchWriteRequest_Write) Reset() {
+	*x = BatchWriteRequest_Write{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tomolink_proto_msgTypes[14]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BatchWriteRequest_Write) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchWriteRequest_Write) ProtoMessage() {}
+
+func (x *BatchWriteRequest_Write) ProtoReflect() protoreflect.Message {
+	mi := &file_tomolink_proto_msgTypes[14]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchWriteRequest_Write.ProtoReflect.Descriptor instead.
+func (*BatchWriteRequest_Write) Descriptor() ([]byte, []int) {
+	return file_tomolink_proto_rawDescGZIP(), []int{9, 0}
+}
+
+func (x *BatchWriteRequest_Write) GetOp() BatchWriteRequest_Op {
+	if x != nil {
+		return x.Op
+	}
+	return BatchWriteRequest_OP_UNSPECIFIED
+}
+
+func (x *BatchWriteRequest_Write) GetRelationship() *Relationship {
+	if x != nil {
+		return x.Relationship
+	}
+	return nil
+}
+
+var File_tomolink_proto protoreflect.FileDescriptor
+
+var file_tomolink_proto_rawDesc = []byte{
+	0x0a, 0x0e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x12, 0x08, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xfc, 0x02, 0x0a, 0x0c,
+	0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x12, 0x1e, 0x0a, 0x0a,
+	0x75, 0x75, 0x69, 0x64, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0a, 0x75, 0x75, 0x69, 0x64, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x1e, 0x0a, 0x0a,
+	0x75, 0x75, 0x69, 0x64, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0a, 0x75, 0x75, 0x69, 0x64, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x12, 0x22, 0x0a, 0x0c,
+	0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x0c, 0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70,
+	0x12, 0x14, 0x0a, 0x05, 0x64, 0x65, 0x6c, 0x74, 0x61, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x05, 0x64, 0x65, 0x6c, 0x74, 0x61, 0x12, 0x31, 0x0a, 0x09, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74,
+	0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x13, 0x2e, 0x74, 0x6f, 0x6d, 0x6f,
+	0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x44, 0x69, 0x72, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x09,
+	0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x31, 0x0a, 0x09, 0x6f, 0x70, 0x65,
+	0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x13, 0x2e, 0x74,
+	0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x4f, 0x70, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x52, 0x09, 0x6f, 0x70, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x1f, 0x0a, 0x08,
+	0x65, 0x78, 0x70, 0x65, 0x63, 0x74, 0x65, 0x64, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x48, 0x00,
+	0x52, 0x08, 0x65, 0x78, 0x70, 0x65, 0x63, 0x74, 0x65, 0x64, 0x88, 0x01, 0x01, 0x12, 0x1d, 0x0a,
+	0x07, 0x63, 0x65, 0x69, 0x6c, 0x69, 0x6e, 0x67, 0x18, 0x08, 0x20, 0x01, 0x28, 0x03, 0x48, 0x01,
+	0x52, 0x07, 0x63, 0x65, 0x69, 0x6c, 0x69, 0x6e, 0x67, 0x88, 0x01, 0x01, 0x12, 0x33, 0x0a, 0x16,
+	0x64, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x5f, 0x69, 0x66, 0x5f, 0x6e, 0x6f, 0x74, 0x5f, 0x70, 0x6f,
+	0x73, 0x69, 0x74, 0x69, 0x76, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x08, 0x52, 0x13, 0x64, 0x65,
+	0x6c, 0x65, 0x74, 0x65, 0x49, 0x66, 0x4e, 0x6f, 0x74, 0x50, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x76,
+	0x65, 0x42, 0x0b, 0x0a, 0x09, 0x5f, 0x65, 0x78, 0x70, 0x65, 0x63, 0x74, 0x65, 0x64, 0x42, 0x0a,
+	0x0a, 0x08, 0x5f, 0x63, 0x65, 0x69, 0x6c, 0x69, 0x6e, 0x67, 0x22, 0x79, 0x0a, 0x06, 0x53, 0x63,
+	0x6f, 0x72, 0x65, 0x73, 0x12, 0x34, 0x0a, 0x06, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e,
+	0x53, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x2e, 0x53, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x45, 0x6e, 0x74,
+	0x72, 0x79, 0x52, 0x06, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x1a, 0x39, 0x0a, 0x0b, 0x53, 0x63,
+	0x6f, 0x72, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76,
+	0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75,
+	0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x42, 0x0a, 0x20, 0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76,
+	0x65, 0x55, 0x73, 0x65, 0x72, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69,
+	0x70, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1e, 0x0a, 0x0a, 0x75, 0x75, 0x69,
+	0x64, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x75,
+	0x75, 0x69, 0x64, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x22, 0xdd, 0x01, 0x0a, 0x21, 0x52, 0x65,
+	0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x55, 0x73, 0x65, 0x72, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
+	0x64, 0x0a, 0x0d, 0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x73,
+	0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x3e, 0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e,
+	0x6b, 0x2e, 0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x55, 0x73, 0x65, 0x72, 0x52, 0x65,
+	0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x2e, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70,
+	0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x0d, 0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x73, 0x68, 0x69, 0x70, 0x73, 0x1a, 0x52, 0x0a, 0x12, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x73, 0x68, 0x69, 0x70, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b,
+	0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x26, 0x0a,
+	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x74,
+	0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x53, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x52, 0x05,
+	0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x6c, 0x0a, 0x26, 0x52, 0x65, 0x74,
+	0x72, 0x69, 0x65, 0x76, 0x65, 0x55, 0x73, 0x65, 0x72, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x73, 0x68, 0x69, 0x70, 0x73, 0x42, 0x79, 0x54, 0x79, 0x70, 0x65, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x12, 0x1e, 0x0a, 0x0a, 0x75, 0x75, 0x69, 0x64, 0x73, 0x6f, 0x75, 0x72, 0x63,
+	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x75, 0x75, 0x69, 0x64, 0x73, 0x6f, 0x75,
+	0x72, 0x63, 0x65, 0x12, 0x22, 0x0a, 0x0c, 0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73,
+	0x68, 0x69, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x72, 0x65, 0x6c, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x22, 0x87, 0x01, 0x0a, 0x21, 0x52, 0x65, 0x74, 0x72,
+	0x69, 0x65, 0x76, 0x65, 0x53, 0x69, 0x6e, 0x67, 0x6c, 0x65, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1e, 0x0a,
+	0x0a, 0x75, 0x75, 0x69, 0x64, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0a, 0x75, 0x75, 0x69, 0x64, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x22, 0x0a,
+	0x0c, 0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0c, 0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69,
+	0x70, 0x12, 0x1e, 0x0a, 0x0a, 0x75, 0x75, 0x69, 0x64, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x75, 0x75, 0x69, 0x64, 0x74, 0x61, 0x72, 0x67, 0x65,
+	0x74, 0x22, 0x3a, 0x0a, 0x22, 0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x53, 0x69, 0x6e,
+	0x67, 0x6c, 0x65, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x63, 0x6f, 0x72, 0x65,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x22, 0x72, 0x0a,
+	0x27, 0x42, 0x61, 0x74, 0x63, 0x68, 0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x53, 0x69,
+	0x6e, 0x67, 0x6c, 0x65, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70,
+	0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x47, 0x0a, 0x08, 0x72, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x2b, 0x2e, 0x74, 0x6f, 0x6d,
+	0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x53, 0x69,
+	0x6e, 0x67, 0x6c, 0x65, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x52, 0x08, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x73, 0x22, 0xb5, 0x01, 0x0a, 0x28, 0x42, 0x61, 0x74, 0x63, 0x68, 0x52, 0x65, 0x74, 0x72, 0x69,
+	0x65, 0x76, 0x65, 0x53, 0x69, 0x6e, 0x67, 0x6c, 0x65, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x73, 0x68, 0x69, 0x70, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x53,
+	0x0a, 0x07, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x39, 0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x42, 0x61, 0x74, 0x63, 0x68,
+	0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x53, 0x69, 0x6e, 0x67, 0x6c, 0x65, 0x52, 0x65,
+	0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x2e, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x52, 0x07, 0x72, 0x65, 0x73, 0x75,
+	0x6c, 0x74, 0x73, 0x1a, 0x34, 0x0a, 0x06, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x14, 0x0a,
+	0x05, 0x66, 0x6f, 0x75, 0x6e, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x66, 0x6f,
+	0x75, 0x6e, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x05, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x22, 0x81, 0x02, 0x0a, 0x11, 0x42, 0x61,
+	0x74, 0x63, 0x68, 0x57, 0x72, 0x69, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
+	0x39, 0x0a, 0x06, 0x77, 0x72, 0x69, 0x74, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x21, 0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x42, 0x61, 0x74, 0x63, 0x68,
+	0x57, 0x72, 0x69, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x57, 0x72, 0x69,
+	0x74, 0x65, 0x52, 0x06, 0x77, 0x72, 0x69, 0x74, 0x65, 0x73, 0x1a, 0x73, 0x0a, 0x05, 0x57, 0x72,
+	0x69, 0x74, 0x65, 0x12, 0x2e, 0x0a, 0x02, 0x6f, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32,
+	0x1e, 0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x42, 0x61, 0x74, 0x63, 0x68,
+	0x57, 0x72, 0x69, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x4f, 0x70, 0x52,
+	0x02, 0x6f, 0x70, 0x12, 0x3a, 0x0a, 0x0c, 0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73,
+	0x68, 0x69, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x74, 0x6f, 0x6d, 0x6f,
+	0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69,
+	0x70, 0x52, 0x0c, 0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x22,
+	0x3c, 0x0a, 0x02, 0x4f, 0x70, 0x12, 0x12, 0x0a, 0x0e, 0x4f, 0x50, 0x5f, 0x55, 0x4e, 0x53, 0x50,
+	0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x0a, 0x0a, 0x06, 0x43, 0x52, 0x45,
+	0x41, 0x54, 0x45, 0x10, 0x01, 0x12, 0x0a, 0x0a, 0x06, 0x55, 0x50, 0x44, 0x41, 0x54, 0x45, 0x10,
+	0x02, 0x12, 0x0a, 0x0a, 0x06, 0x44, 0x45, 0x4c, 0x45, 0x54, 0x45, 0x10, 0x03, 0x22, 0x4a, 0x0a,
+	0x0d, 0x57, 0x72, 0x69, 0x74, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x39,
+	0x0a, 0x0a, 0x77, 0x72, 0x69, 0x74, 0x65, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09,
+	0x77, 0x72, 0x69, 0x74, 0x65, 0x54, 0x69, 0x6d, 0x65, 0x2a, 0x3e, 0x0a, 0x09, 0x44, 0x69, 0x72,
+	0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x19, 0x0a, 0x15, 0x44, 0x49, 0x52, 0x45, 0x43, 0x54,
+	0x49, 0x4f, 0x4e, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10,
+	0x00, 0x12, 0x0a, 0x0a, 0x06, 0x53, 0x49, 0x4e, 0x47, 0x4c, 0x45, 0x10, 0x01, 0x12, 0x0a, 0x0a,
+	0x06, 0x4d, 0x55, 0x54, 0x55, 0x41, 0x4c, 0x10, 0x02, 0x2a, 0x53, 0x0a, 0x09, 0x4f, 0x70, 0x65,
+	0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x19, 0x0a, 0x15, 0x4f, 0x50, 0x45, 0x52, 0x41, 0x54,
+	0x49, 0x4f, 0x4e, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10,
+	0x00, 0x12, 0x0d, 0x0a, 0x09, 0x49, 0x4e, 0x43, 0x52, 0x45, 0x4d, 0x45, 0x4e, 0x54, 0x10, 0x01,
+	0x12, 0x13, 0x0a, 0x0f, 0x43, 0x4f, 0x4d, 0x50, 0x41, 0x52, 0x45, 0x5f, 0x41, 0x4e, 0x44, 0x5f,
+	0x53, 0x45, 0x54, 0x10, 0x02, 0x12, 0x07, 0x0a, 0x03, 0x4d, 0x41, 0x58, 0x10, 0x03, 0x32, 0x85,
+	0x06, 0x0a, 0x08, 0x54, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x12, 0x74, 0x0a, 0x19, 0x52,
+	0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x55, 0x73, 0x65, 0x72, 0x52, 0x65, 0x6c, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x73, 0x12, 0x2a, 0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c,
+	0x69, 0x6e, 0x6b, 0x2e, 0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x55, 0x73, 0x65, 0x72,
+	0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x73, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x2b, 0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e,
+	0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x55, 0x73, 0x65, 0x72, 0x52, 0x65, 0x6c, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x12, 0x65, 0x0a, 0x1f, 0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x55, 0x73, 0x65,
+	0x72, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x73, 0x42, 0x79,
+	0x54, 0x79, 0x70, 0x65, 0x12, 0x30, 0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e,
+	0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x55, 0x73, 0x65, 0x72, 0x52, 0x65, 0x6c, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x73, 0x42, 0x79, 0x54, 0x79, 0x70, 0x65, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x10, 0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e,
+	0x6b, 0x2e, 0x53, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x12, 0x77, 0x0a, 0x1a, 0x52, 0x65, 0x74, 0x72,
+	0x69, 0x65, 0x76, 0x65, 0x53, 0x69, 0x6e, 0x67, 0x6c, 0x65, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x12, 0x2b, 0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e,
+	0x6b, 0x2e, 0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x53, 0x69, 0x6e, 0x67, 0x6c, 0x65,
+	0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x1a, 0x2c, 0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x52,
+	0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x53, 0x69, 0x6e, 0x67, 0x6c, 0x65, 0x52, 0x65, 0x6c,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x12, 0x45, 0x0a, 0x12, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x52, 0x65, 0x6c, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x12, 0x16, 0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69,
+	0x6e, 0x6b, 0x2e, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x1a,
+	0x17, 0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x57, 0x72, 0x69, 0x74, 0x65,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x45, 0x0a, 0x12, 0x55, 0x70, 0x64, 0x61,
+	0x74, 0x65, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x12, 0x16,
+	0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x1a, 0x17, 0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e,
+	0x6b, 0x2e, 0x57, 0x72, 0x69, 0x74, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
+	0x45, 0x0a, 0x12, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x73, 0x68, 0x69, 0x70, 0x12, 0x16, 0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b,
+	0x2e, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x1a, 0x17, 0x2e,
+	0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x57, 0x72, 0x69, 0x74, 0x65, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x89, 0x01, 0x0a, 0x20, 0x42, 0x61, 0x74, 0x63, 0x68,
+	0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x53, 0x69, 0x6e, 0x67, 0x6c, 0x65, 0x52, 0x65,
+	0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x73, 0x12, 0x31, 0x2e, 0x74, 0x6f,
+	0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x42, 0x61, 0x74, 0x63, 0x68, 0x52, 0x65, 0x74, 0x72,
+	0x69, 0x65, 0x76, 0x65, 0x53, 0x69, 0x6e, 0x67, 0x6c, 0x65, 0x52, 0x65, 0x6c, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x32,
+	0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x42, 0x61, 0x74, 0x63, 0x68, 0x52,
+	0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x53, 0x69, 0x6e, 0x67, 0x6c, 0x65, 0x52, 0x65, 0x6c,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x68, 0x69, 0x70, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x12, 0x42, 0x0a, 0x0a, 0x42, 0x61, 0x74, 0x63, 0x68, 0x57, 0x72, 0x69, 0x74, 0x65,
+	0x12, 0x1b, 0x2e, 0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x42, 0x61, 0x74, 0x63,
+	0x68, 0x57, 0x72, 0x69, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x17, 0x2e,
+	0x74, 0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2e, 0x57, 0x72, 0x69, 0x74, 0x65, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x26, 0x5a, 0x24, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62,
+	0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6a, 0x6f, 0x65, 0x68, 0x6f, 0x6c, 0x6c, 0x65, 0x79, 0x2f, 0x74,
+	0x6f, 0x6d, 0x6f, 0x6c, 0x69, 0x6e, 0x6b, 0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x70, 0x62, 0x62, 0x06,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_tomolink_proto_rawDescOnce sync.Once
+	file_tomolink_proto_rawDescData = file_tomolink_proto_rawDesc
+)
+
+func file_tomolink_proto_rawDescGZIP() []byte {
+	file_tomolink_proto_rawDescOnce.Do(func() {
+		file_tomolink_proto_rawDescData = protoimpl.X.CompressGZIP(file_tomolink_proto_rawDescData)
+	})
+	return file_tomolink_proto_rawDescData
+}
+
+var file_tomolink_proto_enumTypes = make([]protoimpl.EnumInfo, 3)
+var file_tomolink_proto_msgTypes = make([]protoimpl.MessageInfo, 15)
+var file_tomolink_proto_goTypes = []interface{}{
+	(Direction)(0),                                   // 0: tomolink.Direction
+	(Operation)(0),                                   // 1: tomolink.Operation
+	(BatchWriteRequest_Op)(0),                        // 2: tomolink.BatchWriteRequest.Op
+	(*Relationship)(nil),                             // 3: tomolink.Relationship
+	(*Scores)(nil),                                   // 4: tomolink.Scores
+	(*RetrieveUserRelationshipsRequest)(nil),         // 5: tomolink.RetrieveUserRelationshipsRequest
+	(*RetrieveUserRelationshipsResponse)(nil),        // 6: tomolink.RetrieveUserRelationshipsResponse
+	(*RetrieveUserRelationshipsByTypeRequest)(nil),   // 7: tomolink.RetrieveUserRelationshipsByTypeRequest
+	(*RetrieveSingleRelationshipRequest)(nil),        // 8: tomolink.RetrieveSingleRelationshipRequest
+	(*RetrieveSingleRelationshipResponse)(nil),       // 9: tomolink.RetrieveSingleRelationshipResponse
+	(*BatchRetrieveSingleRelationshipsRequest)(nil),  // 10: tomolink.BatchRetrieveSingleRelationshipsRequest
+	(*BatchRetrieveSingleRelationshipsResponse)(nil), // 11: tomolink.BatchRetrieveSingleRelationshipsResponse
+	(*BatchWriteRequest)(nil),                        // 12: tomolink.BatchWriteRequest
+	(*WriteResponse)(nil),                            // 13: tomolink.WriteResponse
+	nil,                                              // 14: tomolink.Scores.ScoresEntry
+	nil,                                              // 15: tomolink.RetrieveUserRelationshipsResponse.RelationshipsEntry
+	(*BatchRetrieveSingleRelationshipsResponse_Result)(nil), // 16: tomolink.BatchRetrieveSingleRelationshipsResponse.Result
+	(*BatchWriteRequest_Write)(nil),                         // 17: tomolink.BatchWriteRequest.Write
+	(*timestamppb.Timestamp)(nil),                           // 18: google.protobuf.Timestamp
+}
+var file_tomolink_proto_depIdxs = []int32{
+	0,  // 0: tomolink.Relationship.direction:type_name -> tomolink.Direction
+	1,  // 1: tomolink.Relationship.operation:type_name -> tomolink.Operation
+	14, // 2: tomolink.Scores.scores:type_name -> tomolink.Scores.ScoresEntry
+	15, // 3: tomolink.RetrieveUserRelationshipsResponse.relationships:type_name -> tomolink.RetrieveUserRelationshipsResponse.RelationshipsEntry
+	8,  // 4: tomolink.BatchRetrieveSingleRelationshipsRequest.requests:type_name -> tomolink.RetrieveSingleRelationshipRequest
+	16, // 5: tomolink.BatchRetrieveSingleRelationshipsResponse.results:type_name -> tomolink.BatchRetrieveSingleRelationshipsResponse.Result
+	17, // 6: tomolink.BatchWriteRequest.writes:type_name -> tomolink.BatchWriteRequest.Write
+	18, // 7: tomolink.WriteResponse.write_time:type_name -> google.protobuf.Timestamp
+	4,  // 8: tomolink.RetrieveUserRelationshipsResponse.RelationshipsEntry.value:type_name -> tomolink.Scores
+	2,  // 9: tomolink.BatchWriteRequest.Write.op:type_name -> tomolink.BatchWriteRequest.Op
+	3,  // 10: tomolink.BatchWriteRequest.Write.relationship:type_name -> tomolink.Relationship
+	5,  // 11: tomolink.Tomolink.RetrieveUserRelationships:input_type -> tomolink.RetrieveUserRelationshipsRequest
+	7,  // 12: tomolink.Tomolink.RetrieveUserRelationshipsByType:input_type -> tomolink.RetrieveUserRelationshipsByTypeRequest
+	8,  // 13: tomolink.Tomolink.RetrieveSingleRelationship:input_type -> tomolink.RetrieveSingleRelationshipRequest
+	3,  // 14: tomolink.Tomolink.CreateRelationship:input_type -> tomolink.Relationship
+	3,  // 15: tomolink.Tomolink.UpdateRelationship:input_type -> tomolink.Relationship
+	3,  // 16: tomolink.Tomolink.DeleteRelationship:input_type -> tomolink.Relationship
+	10, // 17: tomolink.Tomolink.BatchRetrieveSingleRelationships:input_type -> tomolink.BatchRetrieveSingleRelationshipsRequest
+	12, // 18: tomolink.Tomolink.BatchWrite:input_type -> tomolink.BatchWriteRequest
+	6,  // 19: tomolink.Tomolink.RetrieveUserRelationships:output_type -> tomolink.RetrieveUserRelationshipsResponse
+	4,  // 20: tomolink.Tomolink.RetrieveUserRelationshipsByType:output_type -> tomolink.Scores
+	9,  // 21: tomolink.Tomolink.RetrieveSingleRelationship:output_type -> tomolink.RetrieveSingleRelationshipResponse
+	13, // 22: tomolink.Tomolink.CreateRelationship:output_type -> tomolink.WriteResponse
+	13, // 23: tomolink.Tomolink.UpdateRelationship:output_type -> tomolink.WriteResponse
+	13, // 24: tomolink.Tomolink.DeleteRelationship:output_type -> tomolink.WriteResponse
+	11, // 25: tomolink.Tomolink.BatchRetrieveSingleRelationships:output_type -> tomolink.BatchRetrieveSingleRelationshipsResponse
+	13, // 26: tomolink.Tomolink.BatchWrite:output_type -> tomolink.WriteResponse
+	19, // [19:27] is the sub-list for method output_type
+	11, // [11:19] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
+}
+
+func init() { file_tomolink_proto_init() }
+func file_tomolink_proto_init() {
+	if File_tomolink_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_tomolink_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Relationship); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_tomolink_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Scores); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_tomolink_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RetrieveUserRelationshipsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_tomolink_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RetrieveUserRelationshipsResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_tomolink_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RetrieveUserRelationshipsByTypeRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_tomolink_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RetrieveSingleRelationshipRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_tomolink_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RetrieveSingleRelationshipResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_tomolink_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BatchRetrieveSingleRelationshipsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_tomolink_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BatchRetrieveSingleRelationshipsResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_tomolink_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BatchWriteRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_tomolink_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WriteResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_tomolink_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BatchRetrieveSingleRelationshipsResponse_Result); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_tomolink_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BatchWriteRequest_Write); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_tomolink_proto_msgTypes[0].OneofWrappers = []interface{}{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_tomolink_proto_rawDesc,
+			NumEnums:      3,
+			NumMessages:   15,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_tomolink_proto_goTypes,
+		DependencyIndexes: file_tomolink_proto_depIdxs,
+		EnumInfos:         file_tomolink_proto_enumTypes,
+		MessageInfos:      file_tomolink_proto_msgTypes,
+	}.Build()
+	File_tomolink_proto = out.File
+	file_tomolink_proto_rawDesc = nil
+	file_tomolink_proto_goTypes = nil
+	file_tomolink_proto_depIdxs = nil
+}
@@ -0,0 +1,375 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.11.4
+// source: tomolink.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// TomolinkClient is the client API for Tomolink service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TomolinkClient interface {
+	// Returns all relationships of a user, keyed by relationship type.
+	RetrieveUserRelationships(ctx context.Context, in *RetrieveUserRelationshipsRequest, opts ...grpc.CallOption) (*RetrieveUserRelationshipsResponse, error)
+	// Returns a user's relationships of one type.
+	RetrieveUserRelationshipsByType(ctx context.Context, in *RetrieveUserRelationshipsByTypeRequest, opts ...grpc.CallOption) (*Scores, error)
+	// Returns the score of a single relationship.
+	RetrieveSingleRelationship(ctx context.Context, in *RetrieveSingleRelationshipRequest, opts ...grpc.CallOption) (*RetrieveSingleRelationshipResponse, error)
+	// Creates a relationship, or resets the score of an existing relationship.
+	CreateRelationship(ctx context.Context, in *Relationship, opts ...grpc.CallOption) (*WriteResponse, error)
+	// Adds the delta to the score of a relationship.
+	UpdateRelationship(ctx context.Context, in *Relationship, opts ...grpc.CallOption) (*WriteResponse, error)
+	// Deletes a relationship.
+	DeleteRelationship(ctx context.Context, in *Relationship, opts ...grpc.CallOption) (*WriteResponse, error)
+	// Returns the scores of several single relationships.
+	BatchRetrieveSingleRelationships(ctx context.Context, in *BatchRetrieveSingleRelationshipsRequest, opts ...grpc.CallOption) (*BatchRetrieveSingleRelationshipsResponse, error)
+	// Atomically applies several creates, updates and deletes: either all of
+	// them are applied, or none of them are.
+	BatchWrite(ctx context.Context, in *BatchWriteRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+}
+
+type tomolinkClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTomolinkClient(cc grpc.ClientConnInterface) TomolinkClient {
+	return &tomolinkClient{cc}
+}
+
+func (c *tomolinkClient) RetrieveUserRelationships(ctx context.Context, in *RetrieveUserRelationshipsRequest, opts ...grpc.CallOption) (*RetrieveUserRelationshipsResponse, error) {
+	out := new(RetrieveUserRelationshipsResponse)
+	err := c.cc.Invoke(ctx, "/tomolink.Tomolink/RetrieveUserRelationships", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tomolinkClient) RetrieveUserRelationshipsByType(ctx context.Context, in *RetrieveUserRelationshipsByTypeRequest, opts ...grpc.CallOption) (*Scores, error) {
+	out := new(Scores)
+	err := c.cc.Invoke(ctx, "/tomolink.Tomolink/RetrieveUserRelationshipsByType", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tomolinkClient) RetrieveSingleRelationship(ctx context.Context, in *RetrieveSingleRelationshipRequest, opts ...grpc.CallOption) (*RetrieveSingleRelationshipResponse, error) {
+	out := new(RetrieveSingleRelationshipResponse)
+	err := c.cc.Invoke(ctx, "/tomolink.Tomolink/RetrieveSingleRelationship", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tomolinkClient) CreateRelationship(ctx context.Context, in *Relationship, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := c.cc.Invoke(ctx, "/tomolink.Tomolink/CreateRelationship", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tomolinkClient) UpdateRelationship(ctx context.Context, in *Relationship, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := c.cc.Invoke(ctx, "/tomolink.Tomolink/UpdateRelationship", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tomolinkClient) DeleteRelationship(ctx context.Context, in *Relationship, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := c.cc.Invoke(ctx, "/tomolink.Tomolink/DeleteRelationship", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tomolinkClient) BatchRetrieveSingleRelationships(ctx context.Context, in *BatchRetrieveSingleRelationshipsRequest, opts ...grpc.CallOption) (*BatchRetrieveSingleRelationshipsResponse, error) {
+	out := new(BatchRetrieveSingleRelationshipsResponse)
+	err := c.cc.Invoke(ctx, "/tomolink.Tomolink/BatchRetrieveSingleRelationships", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tomolinkClient) BatchWrite(ctx context.Context, in *BatchWriteRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := c.cc.Invoke(ctx, "/tomolink.Tomolink/BatchWrite", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TomolinkServer is the server API for Tomolink service.
+// All implementations must embed UnimplementedTomolinkServer
+// for forward compatibility
+type TomolinkServer interface {
+	// Returns all relationships of a user, keyed by relationship type.
+	RetrieveUserRelationships(context.Context, *RetrieveUserRelationshipsRequest) (*RetrieveUserRelationshipsResponse, error)
+	// Returns a user's relationships of one type.
+	RetrieveUserRelationshipsByType(context.Context, *RetrieveUserRelationshipsByTypeRequest) (*Scores, error)
+	// Returns the score of a single relationship.
+	RetrieveSingleRelationship(context.Context, *RetrieveSingleRelationshipRequest) (*RetrieveSingleRelationshipResponse, error)
+	// Creates a relationship, or resets the score of an existing relationship.
+	CreateRelationship(context.Context, *Relationship) (*WriteResponse, error)
+	// Adds the delta to the score of a relationship.
+	UpdateRelationship(context.Context, *Relationship) (*WriteResponse, error)
+	// Deletes a relationship.
+	DeleteRelationship(context.Context, *Relationship) (*WriteResponse, error)
+	// Returns the scores of several single relationships.
+	BatchRetrieveSingleRelationships(context.Context, *BatchRetrieveSingleRelationshipsRequest) (*BatchRetrieveSingleRelationshipsResponse, error)
+	// Atomically applies several creates, updates and deletes: either all of
+	// them are applied, or none of them are.
+	BatchWrite(context.Context, *BatchWriteRequest) (*WriteResponse, error)
+	mustEmbedUnimplementedTomolinkServer()
+}
+
+// UnimplementedTomolinkServer must be embedded to have forward compatible implementations.
+type UnimplementedTomolinkServer struct {
+}
+
+func (UnimplementedTomolinkServer) RetrieveUserRelationships(context.Context, *RetrieveUserRelationshipsRequest) (*RetrieveUserRelationshipsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RetrieveUserRelationships not implemented")
+}
+func (UnimplementedTomolinkServer) RetrieveUserRelationshipsByType(context.Context, *RetrieveUserRelationshipsByTypeRequest) (*Scores, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RetrieveUserRelationshipsByType not implemented")
+}
+func (UnimplementedTomolinkServer) RetrieveSingleRelationship(context.Context, *RetrieveSingleRelationshipRequest) (*RetrieveSingleRelationshipResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RetrieveSingleRelationship not implemented")
+}
+func (UnimplementedTomolinkServer) CreateRelationship(context.Context, *Relationship) (*WriteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateRelationship not implemented")
+}
+func (UnimplementedTomolinkServer) UpdateRelationship(context.Context, *Relationship) (*WriteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateRelationship not implemented")
+}
+func (UnimplementedTomolinkServer) DeleteRelationship(context.Context, *Relationship) (*WriteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteRelationship not implemented")
+}
+func (UnimplementedTomolinkServer) BatchRetrieveSingleRelationships(context.Context, *BatchRetrieveSingleRelationshipsRequest) (*BatchRetrieveSingleRelationshipsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchRetrieveSingleRelationships not implemented")
+}
+func (UnimplementedTomolinkServer) BatchWrite(context.Context, *BatchWriteRequest) (*WriteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchWrite not implemented")
+}
+func (UnimplementedTomolinkServer) mustEmbedUnimplementedTomolinkServer() {}
+
+// UnsafeTomolinkServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TomolinkServer will
+// result in compilation errors.
+type UnsafeTomolinkServer interface {
+	mustEmbedUnimplementedTomolinkServer()
+}
+
+func RegisterTomolinkServer(s grpc.ServiceRegistrar, srv TomolinkServer) {
+	s.RegisterService(&Tomolink_ServiceDesc, srv)
+}
+
+func _Tomolink_RetrieveUserRelationships_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RetrieveUserRelationshipsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TomolinkServer).RetrieveUserRelationships(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tomolink.Tomolink/RetrieveUserRelationships",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TomolinkServer).RetrieveUserRelationships(ctx, req.(*RetrieveUserRelationshipsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tomolink_RetrieveUserRelationshipsByType_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RetrieveUserRelationshipsByTypeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TomolinkServer).RetrieveUserRelationshipsByType(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tomolink.Tomolink/RetrieveUserRelationshipsByType",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TomolinkServer).RetrieveUserRelationshipsByType(ctx, req.(*RetrieveUserRelationshipsByTypeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tomolink_RetrieveSingleRelationship_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RetrieveSingleRelationshipRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TomolinkServer).RetrieveSingleRelationship(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tomolink.Tomolink/RetrieveSingleRelationship",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TomolinkServer).RetrieveSingleRelationship(ctx, req.(*RetrieveSingleRelationshipRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tomolink_CreateRelationship_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Relationship)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TomolinkServer).CreateRelationship(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tomolink.Tomolink/CreateRelationship",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TomolinkServer).CreateRelationship(ctx, req.(*Relationship))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tomolink_UpdateRelationship_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Relationship)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TomolinkServer).UpdateRelationship(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tomolink.Tomolink/UpdateRelationship",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TomolinkServer).UpdateRelationship(ctx, req.(*Relationship))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tomolink_DeleteRelationship_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Relationship)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TomolinkServer).DeleteRelationship(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tomolink.Tomolink/DeleteRelationship",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TomolinkServer).DeleteRelationship(ctx, req.(*Relationship))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tomolink_BatchRetrieveSingleRelationships_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchRetrieveSingleRelationshipsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TomolinkServer).BatchRetrieveSingleRelationships(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tomolink.Tomolink/BatchRetrieveSingleRelationships",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TomolinkServer).BatchRetrieveSingleRelationships(ctx, req.(*BatchRetrieveSingleRelationshipsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tomolink_BatchWrite_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchWriteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TomolinkServer).BatchWrite(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tomolink.Tomolink/BatchWrite",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TomolinkServer).BatchWrite(ctx, req.(*BatchWriteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Tomolink_ServiceDesc is the grpc.ServiceDesc for Tomolink service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Tomolink_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "tomolink.Tomolink",
+	HandlerType: (*TomolinkServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RetrieveUserRelationships",
+			Handler:    _Tomolink_RetrieveUserRelationships_Handler,
+		},
+		{
+			MethodName: "RetrieveUserRelationshipsByType",
+			Handler:    _Tomolink_RetrieveUserRelationshipsByType_Handler,
+		},
+		{
+			MethodName: "RetrieveSingleRelationship",
+			Handler:    _Tomolink_RetrieveSingleRelationship_Handler,
+		},
+		{
+			MethodName: "CreateRelationship",
+			Handler:    _Tomolink_CreateRelationship_Handler,
+		},
+		{
+			MethodName: "UpdateRelationship",
+			Handler:    _Tomolink_UpdateRelationship_Handler,
+		},
+		{
+			MethodName: "DeleteRelationship",
+			Handler:    _Tomolink_DeleteRelationship_Handler,
+		},
+		{
+			MethodName: "BatchRetrieveSingleRelationships",
+			Handler:    _Tomolink_BatchRetrieveSingleRelationships_Handler,
+		},
+		{
+			MethodName: "BatchWrite",
+			Handler:    _Tomolink_BatchWrite_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "tomolink.proto",
+}